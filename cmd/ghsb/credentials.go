@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// credentials are stored by "ghsb login" so later commands don't need -url
+// and -token.
+type credentials struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func credentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ghsb", "credentials.json"), nil
+}
+
+// loadCredentials returns the stored credentials, or empty ones if there are
+// none or the token has expired.
+func loadCredentials() (credentials, error) {
+	var creds credentials
+	path, err := credentialsPath()
+	if err != nil {
+		return creds, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return creds, nil
+	}
+	if err != nil {
+		return creds, err
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return credentials{}, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if !creds.ExpiresAt.IsZero() && time.Now().After(creds.ExpiresAt) {
+		creds.Token = ""
+	}
+	return creds, nil
+}
+
+// saveCredentials writes creds readable only by the current user.
+func saveCredentials(creds credentials) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+func removeCredentials() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
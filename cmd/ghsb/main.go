@@ -0,0 +1,274 @@
+// Command ghsb is a command-line client for gh-secret-broker.
+//
+// It authenticates with an API token, given with -token or GHSB_TOKEN, or
+// obtained with "ghsb login". Secret values are read from stdin so they never
+// end up in the shell history.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: ghsb [-url URL] [-token TOKEN] <command> [arguments]
+
+Commands:
+  login [OWNER/REPO...]                log in with a device code
+  logout                               forget the stored token
+  repos list                           list the repositories you can manage
+  secrets list OWNER/REPO              list the secrets of a repository
+  secrets set OWNER/REPO NAME          set a secret, the value is read from stdin
+  secrets delete OWNER/REPO NAME       delete a secret
+  secrets import OWNER/REPO FILE       set every secret of a .env file ("-" for stdin)
+
+The broker URL and token default to GHSB_URL and GHSB_TOKEN, then to the
+credentials stored by "ghsb login". Its token can read and write, but not
+delete, the secrets of the given repositories, or of all you maintain, for a
+day; create longer-lived tokens in the broker's UI.
+`
+
+// requestTimeout bounds a single command, except for login which waits for
+// the user.
+const requestTimeout = time.Minute
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("ghsb", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() { _, _ = fmt.Fprint(stderr, usage) }
+	baseURL := fs.String("url", os.Getenv("GHSB_URL"), "Broker URL")
+	token := fs.String("token", os.Getenv("GHSB_TOKEN"), "API token")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if args[0] == "logout" && len(args) == 1 {
+		if err := removeCredentials(); err != nil {
+			_, _ = fmt.Fprintf(stderr, "ghsb: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	creds, err := loadCredentials()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "ghsb: %v\n", err)
+		return 1
+	}
+	if *baseURL == "" {
+		*baseURL = creds.URL
+	}
+	if *token == "" && strings.EqualFold(strings.TrimRight(*baseURL, "/"), strings.TrimRight(creds.URL, "/")) {
+		*token = creds.Token
+	}
+	if *baseURL == "" {
+		_, _ = fmt.Fprintln(stderr, "ghsb: no broker URL, pass -url or set GHSB_URL")
+		return 2
+	}
+	c := client.New(*baseURL, *token)
+
+	cmd := &command{client: c, stdin: stdin, stdout: stdout, stderr: stderr}
+	switch {
+	case args[0] == "login":
+		err = cmd.login(ctx, args[1:])
+	case len(args) >= 2 && args[0] == "repos" && args[1] == "list" && len(args) == 2:
+		err = cmd.withTimeout(ctx, cmd.listRepos)
+	case len(args) >= 2 && args[0] == "secrets":
+		err = cmd.secrets(ctx, args[1], args[2:])
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	var usageErr usageError
+	switch {
+	case errors.As(err, &usageErr):
+		_, _ = fmt.Fprintf(stderr, "ghsb: %v\n\n%s", err, usage)
+		return 2
+	case client.IsCode(err, client.CodeUnauthorized) && *token == "":
+		_, _ = fmt.Fprintln(stderr, `ghsb: not logged in, run "ghsb login" or pass -token`)
+		return 1
+	case err != nil:
+		_, _ = fmt.Fprintf(stderr, "ghsb: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// usageError is a command line that doesn't match any command.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+type command struct {
+	client *client.Client
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *command) withTimeout(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+func (c *command) login(ctx context.Context, repos []string) error {
+	code, err := c.client.StartDeviceLogin(ctx, client.DeviceCodeRequest{Repositories: repos})
+	var e *client.Error
+	if errors.As(err, &e) && e.Status == http.StatusNotFound {
+		return errors.New("this broker does not support device login, create an API token in its UI and pass it with -token or GHSB_TOKEN")
+	}
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(c.stderr, "Open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	tok, err := c.client.PollDeviceLogin(ctx, code)
+	if err != nil {
+		return err
+	}
+	if err := saveCredentials(credentials{URL: c.client.BaseURL, Token: tok.Token, ExpiresAt: tok.ExpiresAt}); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.stderr, "Logged in to %s, the token expires %s\n", c.client.BaseURL, tok.ExpiresAt.Local().Format(time.DateTime))
+	return nil
+}
+
+func (c *command) listRepos(ctx context.Context) error {
+	groups, err := c.client.ListRepositories(ctx)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		for _, repo := range group.Repositories {
+			_, _ = fmt.Fprintln(c.stdout, repo.GetFullName())
+		}
+	}
+	return nil
+}
+
+func (c *command) secrets(ctx context.Context, sub string, args []string) error {
+	want := map[string]int{"list": 1, "set": 2, "delete": 2, "import": 2}
+	n, ok := want[sub]
+	if !ok {
+		return usageError(fmt.Sprintf("unknown command %q", "secrets "+sub))
+	}
+	if len(args) != n {
+		return usageError(fmt.Sprintf("secrets %s takes %d arguments", sub, n))
+	}
+	owner, repo, ok := strings.Cut(args[0], "/")
+	if !ok || owner == "" || repo == "" {
+		return usageError(fmt.Sprintf("repository %q must be OWNER/REPO", args[0]))
+	}
+
+	switch sub {
+	case "list":
+		return c.withTimeout(ctx, func(ctx context.Context) error {
+			names, err := c.client.ListSecrets(ctx, owner, repo)
+			for _, name := range names {
+				_, _ = fmt.Fprintln(c.stdout, name)
+			}
+			return err
+		})
+	case "set":
+		value, err := c.readValue(args[1])
+		if err != nil {
+			return err
+		}
+		return c.withTimeout(ctx, func(ctx context.Context) error {
+			return c.client.SetSecret(ctx, owner, repo, args[1], value)
+		})
+	case "delete":
+		return c.withTimeout(ctx, func(ctx context.Context) error {
+			return c.client.DeleteSecret(ctx, owner, repo, args[1])
+		})
+	default:
+		return c.importFile(ctx, owner, repo, args[1])
+	}
+}
+
+// readValue reads a secret value from stdin. A trailing newline, as left by
+// echo or a terminal, is not part of the value.
+func (c *command) readValue(name string) (string, error) {
+	if f, ok := c.stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			_, _ = fmt.Fprintf(c.stderr, "Enter the value of %s, end with Ctrl-D:\n", name)
+		}
+	}
+	data, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return "", fmt.Errorf("reading the value from stdin: %w", err)
+	}
+	value := strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+	if value == "" {
+		return "", errors.New("the value read from stdin is empty")
+	}
+	return value, nil
+}
+
+// importFile sets every variable of a .env file as a secret. It keeps going
+// after a failure and reports all of them.
+func (c *command) importFile(ctx context.Context, owner, repo, path string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(c.stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	vars, err := godotenv.Parse(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	failed := 0
+	for _, name := range names {
+		err := c.withTimeout(ctx, func(ctx context.Context) error {
+			return c.client.SetSecret(ctx, owner, repo, name, vars[name])
+		})
+		if err != nil {
+			failed++
+			_, _ = fmt.Fprintf(c.stderr, "%s: %v\n", name, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(c.stdout, "Set %s\n", name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d secrets could not be set", failed, len(names))
+	}
+	return nil
+}
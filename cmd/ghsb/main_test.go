@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+)
+
+// fakeBroker records the secrets set through it. Setting FAIL fails.
+type fakeBroker struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+func newFakeBroker(t *testing.T) (*fakeBroker, *httptest.Server) {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GHSB_URL", "")
+	t.Setenv("GHSB_TOKEN", "")
+
+	b := &fakeBroker{secrets: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"org": "org", "repositories": [{"full_name": "org/repo-1"}, {"full_name": "org/repo-2"}]}]`))
+	})
+	mux.HandleFunc("PUT /api/repo/{owner}/{repo}/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gsb_test" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"status": 401, "code": "unauthorized", "title": "Unauthorized"}`))
+			return
+		}
+		if r.PathValue("name") == "FAIL" {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"status": 422, "code": "validation_failed", "detail": "Invalid secret name"}`))
+			return
+		}
+		var req client.SetSecretRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		b.mu.Lock()
+		b.secrets[r.PathValue("owner")+"/"+r.PathValue("repo")+"/"+r.PathValue("name")] = req.Value
+		b.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return b, srv
+}
+
+func runCommand(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(context.Background(), args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestSecretsSet(t *testing.T) {
+	b, srv := newFakeBroker(t)
+
+	code, _, stderr := runCommand("s3cret\n", "-url", srv.URL, "-token", "gsb_test", "secrets", "set", "org/repo", "API_KEY")
+	assert.Equal(t, code, 0)
+	assert.Equal(t, stderr, "")
+	assert.Equal(t, b.secrets["org/repo/API_KEY"], "s3cret")
+}
+
+func TestSecretsImport(t *testing.T) {
+	b, srv := newFakeBroker(t)
+
+	env := "# comment\nDB_URL=postgres://db\nexport API_KEY=\"a b\"\nFAIL=x\n"
+	code, stdout, stderr := runCommand(env, "-url", srv.URL, "-token", "gsb_test", "secrets", "import", "org/repo", "-")
+	assert.Equal(t, code, 1)
+	assert.Equal(t, stdout, "Set API_KEY\nSet DB_URL\n")
+	assert.Equal(t, strings.Contains(stderr, "FAIL: Invalid secret name"), true)
+	assert.Equal(t, strings.Contains(stderr, "1 of 3 secrets could not be set"), true)
+	assert.Equal(t, b.secrets["org/repo/API_KEY"], "a b")
+	assert.Equal(t, b.secrets["org/repo/DB_URL"], "postgres://db")
+}
+
+func TestReposList(t *testing.T) {
+	_, srv := newFakeBroker(t)
+
+	code, stdout, _ := runCommand("", "-url", srv.URL, "repos", "list")
+	assert.Equal(t, code, 0)
+	assert.Equal(t, stdout, "org/repo-1\norg/repo-2\n")
+}
+
+func TestStoredCredentials(t *testing.T) {
+	b, srv := newFakeBroker(t)
+
+	code, _, stderr := runCommand("v", "-url", srv.URL, "secrets", "set", "org/repo", "API_KEY")
+	assert.Equal(t, code, 1)
+	assert.Equal(t, strings.Contains(stderr, "not logged in"), true)
+
+	if err := saveCredentials(credentials{URL: srv.URL, Token: "gsb_test"}); err != nil {
+		t.Fatal(err)
+	}
+	code, _, _ = runCommand("v", "secrets", "set", "org/repo", "API_KEY")
+	assert.Equal(t, code, 0)
+	assert.Equal(t, b.secrets["org/repo/API_KEY"], "v")
+
+	code, _, _ = runCommand("", "logout")
+	assert.Equal(t, code, 0)
+	creds, _ := loadCredentials()
+	assert.Equal(t, creds.Token, "")
+}
+
+func TestUsage(t *testing.T) {
+	_, srv := newFakeBroker(t)
+
+	for _, args := range [][]string{
+		{},
+		{"secrets", "set", "org/repo"},
+		{"secrets", "list", "repo"},
+		{"secrets", "rotate", "org/repo"},
+	} {
+		code, _, stderr := runCommand("", append([]string{"-url", srv.URL}, args...)...)
+		assert.Equal(t, code, 2)
+		assert.Equal(t, strings.Contains(stderr, "usage: ghsb"), true)
+	}
+}
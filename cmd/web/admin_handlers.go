@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+)
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// handleGetLogLevel reports the current log level.
+func (app *application) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	app.writeLogLevel(w, r)
+}
+
+// handleSetLogLevel changes the log level until the next restart or until a
+// reload brings a different level from the config.
+func (app *application) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	level, err := config.ParseLevel(req.Level)
+	if err != nil || req.Level == "" {
+		app.clientError(w, r, http.StatusBadRequest, "Unknown log level")
+		return
+	}
+
+	old := app.logLevel.Level()
+	app.logLevel.Set(level)
+	// Logged as a warning so the change is visible at any level
+	app.logger.WarnContext(r.Context(), "Log level changed", slog.String("old", old.String()), slog.String("new", level.String()))
+
+	app.writeLogLevel(w, r)
+}
+
+func (app *application) writeLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(logLevelResponse{Level: strings.ToLower(app.logLevel.Level().String())}); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode log level", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+)
+
+func TestHandleLogLevel(t *testing.T) {
+	app := &application{
+		logger:   setupTestLogger(),
+		logLevel: new(slog.LevelVar),
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/log-level", nil)
+		w := httptest.NewRecorder()
+
+		app.handleGetLogLevel(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var res logLevelResponse
+		_ = json.NewDecoder(w.Body).Decode(&res)
+		assert.Equal(t, res.Level, "info")
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		req, _ := http.NewRequest("PUT", "/log-level", strings.NewReader(`{"level": "DEBUG"}`))
+		w := httptest.NewRecorder()
+
+		app.handleSetLogLevel(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, app.logLevel.Level(), slog.LevelDebug)
+
+		var res logLevelResponse
+		_ = json.NewDecoder(w.Body).Decode(&res)
+		assert.Equal(t, res.Level, "debug")
+	})
+
+	for _, body := range []string{`{"level": "verbose"}`, `{}`, `not json`} {
+		t.Run("Reject "+body, func(t *testing.T) {
+			req, _ := http.NewRequest("PUT", "/log-level", strings.NewReader(body))
+			w := httptest.NewRecorder()
+
+			app.handleSetLogLevel(w, req)
+			assert.Equal(t, w.Code, http.StatusBadRequest)
+			assert.Equal(t, app.logLevel.Level(), slog.LevelDebug)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+)
+
+// limitAudits limits how many audit requests each user makes per minute, as
+// every request lists through the PAT and its rate limit is shared with all
+// users. It runs after requireRole.
+func (app *application) limitAudits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		grant, _ := roleFromContext(r.Context())
+		perMinute := app.cfg().AuditRateLimit
+		if perMinute == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		if wait, ok := app.auditLimiter.allow(grant.User.UserID, perMinute, now); !ok {
+			app.logger.WarnContext(r.Context(), "Audit rate limit exceeded", slog.String("user", actorName(grant.User)))
+			app.errorResponse(w, r, &repository.Error{Code: repository.CodeRateLimited, Message: "Too many audit requests", RetryAt: now.Add(wait)})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAuditAccess reports whether the secrets of owner's repositories may be
+// read for an audit. A denial comes with the reason shown to the client. The
+// role is checked by requireRole on the route, not here.
+//
+// It is separate from checkAccess, which stays the only check for writes:
+// auditors don't need to maintain a repository, but they can only read, and
+// only in the broker's organizations.
+func (app *application) checkAuditAccess(owner string) (denied string) {
+	if _, ok := app.cfg().LookupOrg(owner); !ok {
+		return "The repository is not in one of the broker's organizations"
+	}
+	return ""
+}
+
+// handleAuditRepositories handles the GET /api/admin/repos request. It lists
+// all repositories of the configured organizations, grouped per
+// organization, whether or not the user maintains them.
+func (app *application) handleAuditRepositories(w http.ResponseWriter, r *http.Request) {
+	grant, _ := roleFromContext(r.Context())
+	orgNames := app.cfg().GithubOrgs
+
+	var repos []*github.Repository
+	for _, org := range orgNames {
+		orgRepos, err := app.repositories.ListOrgRepositories(r.Context(), app.pat(), org)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "Failed to list repositories", slog.String("error", err.Error()), slog.String("org", org))
+			app.recordAuditEvent(r, grant, "repository.audit", "", audit.OutcomeFailure)
+			app.errorResponse(w, r, err)
+			return
+		}
+		repos = append(repos, orgRepos...)
+	}
+	app.recordAuditEvent(r, grant, "repository.audit", "", audit.OutcomeSuccess)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groupByOrg(orgNames, repos)); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode response", slog.String("error", err.Error()))
+	}
+}
+
+// handleAuditSecrets handles the GET /api/admin/repo/{owner}/{repo}/secrets
+// request. It lists the secrets of any repository in the configured
+// organizations with the PAT. There is no write counterpart.
+func (app *application) handleAuditSecrets(w http.ResponseWriter, r *http.Request) {
+	grant, _ := roleFromContext(r.Context())
+	owner := r.PathValue("owner")
+	repo := r.PathValue("repo")
+
+	if denied := app.checkAuditAccess(owner); denied != "" {
+		app.logger.WarnContext(r.Context(), "User attempted to audit secrets without permission", slog.String("user", actorName(grant.User)), slog.String("repo", owner+"/"+repo))
+		app.recordAuditEvent(r, grant, "secret.audit", owner+"/"+repo, audit.OutcomeDenied)
+		app.clientError(w, r, http.StatusForbidden, denied)
+		return
+	}
+
+	secrets, err := app.repositories.ListSecretMetadata(r.Context(), app.pat(), owner, repo)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to list secrets", slog.String("error", err.Error()))
+		app.recordAuditEvent(r, grant, "secret.audit", owner+"/"+repo, audit.OutcomeFailure)
+		app.errorResponse(w, r, err)
+		return
+	}
+	app.recordAuditEvent(r, grant, "secret.audit", owner+"/"+repo, audit.OutcomeSuccess)
+
+	res := make([]client.SecretMetadata, len(secrets))
+	for i, secret := range secrets {
+		res[i] = client.SecretMetadata{Name: secret.Name, CreatedAt: secret.CreatedAt.Time, UpdatedAt: secret.UpdatedAt.Time}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode secrets", slog.String("error", err.Error()))
+	}
+}
+
+// recordAuditEvent records that an auditor looked at repo (as owner/repo), or
+// at the repository lists if it is empty.
+func (app *application) recordAuditEvent(r *http.Request, grant roleGrant, action, repo, outcome string) {
+	app.audit.Record(r.Context(), audit.Event{
+		Actor:      actorName(grant.User),
+		Action:     action,
+		Repository: repo,
+		Outcome:    outcome,
+		Detail:     "role=" + grant.Role.String(),
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/roles"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
+	"github.com/justinas/alice"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+func TestAuditSecrets(t *testing.T) {
+	var auditBuf bytes.Buffer
+	updated := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	app := &application{
+		logger: setupTestLogger(),
+		config: &config.Config{
+			GithubOrgs:     []string{"org"},
+			AuditorUsers:   []string{"sec"},
+			AuditRateLimit: 3,
+		},
+		repositories: &mockRepositoryService{
+			ListSecretMetadataFunc: func(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error) {
+				return []*github.Secret{{Name: "DEPLOY_KEY", UpdatedAt: github.Timestamp{Time: updated}}}, nil
+			},
+			ListOrgRepositoriesFunc: func(ctx context.Context, client *github.Client, org string) ([]*github.Repository, error) {
+				return []*github.Repository{{Name: github.Ptr("repo-1"), Owner: &github.User{Login: github.Ptr(org)}}}, nil
+			},
+			HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+				t.Error("Expected audits not to check maintainer access")
+				return false, nil
+			},
+		},
+		audit: audit.New(slog.NewJSONHandler(&auditBuf, nil)),
+	}
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = store
+	cookie := func(login string) string {
+		return sessionCookie(store, goth.User{UserID: login + "-id", NickName: login, AccessToken: "valid-token"})
+	}
+	audits := alice.New(app.requireRole(roles.Auditor), app.limitAudits)
+	listSecrets := func(login, owner string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/admin/repo/"+owner+"/repo-1/secrets", nil)
+		req.SetPathValue("owner", owner)
+		req.SetPathValue("repo", "repo-1")
+		req.Header.Set("Cookie", cookie(login))
+		w := httptest.NewRecorder()
+		audits.ThenFunc(app.handleAuditSecrets).ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Auditor", func(t *testing.T) {
+		w := listSecrets("sec", "org")
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var secrets []client.SecretMetadata
+		_ = json.NewDecoder(w.Body).Decode(&secrets)
+		assert.Equal(t, len(secrets), 1)
+		assert.Equal(t, secrets[0].Name, "DEPLOY_KEY")
+		assert.Equal(t, secrets[0].UpdatedAt.Equal(updated), true)
+		assert.Equal(t, strings.Contains(auditBuf.String(), `"action":"secret.audit"`), true)
+		assert.Equal(t, strings.Contains(auditBuf.String(), `"repository":"org/repo-1"`), true)
+	})
+
+	t.Run("Repositories", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/admin/repos", nil)
+		req.Header.Set("Cookie", cookie("sec"))
+		w := httptest.NewRecorder()
+		audits.ThenFunc(app.handleAuditRepositories).ServeHTTP(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var groups []client.OrgRepositories
+		_ = json.NewDecoder(w.Body).Decode(&groups)
+		assert.Equal(t, len(groups), 1)
+		assert.Equal(t, len(groups[0].Repositories), 1)
+	})
+
+	t.Run("Other owner", func(t *testing.T) {
+		w := listSecrets("sec", "elsewhere")
+		assert.Equal(t, w.Code, http.StatusForbidden)
+		assert.Equal(t, strings.Contains(auditBuf.String(), `"outcome":"denied"`), true)
+	})
+
+	t.Run("User", func(t *testing.T) {
+		w := listSecrets("someone", "org")
+		assert.Equal(t, w.Code, http.StatusForbidden)
+	})
+
+	t.Run("Rate limited", func(t *testing.T) {
+		// The subtests above used up the auditor's three requests
+		w := listSecrets("sec", "org")
+		assert.Equal(t, w.Code, http.StatusTooManyRequests)
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("Expected a Retry-After header")
+		}
+	})
+}
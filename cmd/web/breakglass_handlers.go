@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/breakglass"
+	"github.com/RobinMaas95/gh-secret-broker/internal/notify"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+)
+
+// breakGlassEnabled answers requests with 404 when break-glass access is off.
+func (app *application) breakGlassEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if app.breakGlass == nil || !app.cfg().BreakGlass {
+		app.clientError(w, r, http.StatusNotFound, "Break-glass access is not enabled")
+		return false
+	}
+	return true
+}
+
+// handleCreateBreakGlass handles POST /api/break-glass. Any member of the
+// repository's organization can break the glass; the grant is what makes it
+// safe: it is limited to one repository and a short time, notified, audited
+// and reviewed by an admin afterwards.
+func (app *application) handleCreateBreakGlass(w http.ResponseWriter, r *http.Request) {
+	if !app.breakGlassEnabled(w, r) {
+		return
+	}
+	user, ok := app.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req client.BreakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	cfg := app.cfg()
+	owner, _, _ := strings.Cut(req.Repository, "/")
+	org, ok := cfg.LookupOrg(owner)
+	if !ok {
+		app.clientError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("Repository %q is not in one of the configured organizations", req.Repository))
+		return
+	}
+
+	member, err := app.repositories.IsOrgMember(repository.WithUser(r.Context(), user.UserID), app.pat(), org, user.NickName)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+	if !member {
+		app.logger.WarnContext(r.Context(), "Break-glass access denied", slog.String("user", actorName(user)), slog.String("repo", req.Repository))
+		app.audit.Record(r.Context(), audit.Event{Actor: actorName(user), Action: "breakglass.create", Repository: req.Repository, Outcome: audit.OutcomeDenied})
+		app.clientError(w, r, http.StatusForbidden, "Only members of the organization can break the glass")
+		return
+	}
+
+	grant, err := app.breakGlass.Create(user.UserID, user.NickName, breakglass.Request{
+		Repository:    req.Repository,
+		Justification: req.Justification,
+		Ticket:        req.Ticket,
+		Duration:      cfg.BreakGlassDuration,
+		MaxActive:     cfg.BreakGlassMaxActive,
+	})
+	if errors.Is(err, breakglass.ErrValidation) {
+		app.clientError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if errors.Is(err, breakglass.ErrTooMany) {
+		app.logger.WarnContext(r.Context(), "Break-glass access denied", slog.String("user", actorName(user)), slog.String("repo", req.Repository), slog.String("error", err.Error()))
+		app.audit.Record(r.Context(), audit.Event{Actor: actorName(user), Action: "breakglass.create", Repository: req.Repository, Outcome: audit.OutcomeDenied, Detail: "too many active grants"})
+		app.clientError(w, r, http.StatusConflict, fmt.Sprintf("You already have %d active break-glass grants", cfg.BreakGlassMaxActive))
+		return
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Logged as a warning so it is visible at any level
+	app.logger.WarnContext(r.Context(), "Break-glass access granted", slog.String("user", actorName(user)), slog.String("repo", grant.Repository), slog.String("ticket", grant.Ticket), slog.Time("expires_at", grant.ExpiresAt))
+	app.audit.Record(r.Context(), audit.Event{
+		Actor:      actorName(user),
+		Action:     "breakglass.create",
+		Repository: grant.Repository,
+		Outcome:    audit.OutcomeSuccess,
+		Detail:     fmt.Sprintf("break_glass=%s ticket=%q justification=%q expires_at=%s", grant.ID, grant.Ticket, grant.Justification, grant.ExpiresAt.Format(time.RFC3339)),
+	})
+	app.notify(r.Context(), notify.Message{
+		Event:    "break_glass",
+		Priority: notify.PriorityHigh,
+		Text:     fmt.Sprintf("%s broke the glass on %s until %s (%s): %s", actorName(user), grant.Repository, grant.ExpiresAt.Format("15:04 MST"), grant.Ticket, grant.Justification),
+		Fields: map[string]string{
+			"id":         grant.ID,
+			"user":       actorName(user),
+			"repository": grant.Repository,
+			"ticket":     grant.Ticket,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(breakGlassGrant(grant)); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode break-glass grant", slog.String("error", err.Error()))
+	}
+}
+
+// handleListBreakGlass handles GET /api/admin/break-glass. With
+// ?pending=true, only grants that still need a review are listed.
+func (app *application) handleListBreakGlass(w http.ResponseWriter, r *http.Request) {
+	if !app.breakGlassEnabled(w, r) {
+		return
+	}
+
+	grants := app.breakGlass.List(r.URL.Query().Get("pending") == "true")
+	res := make([]client.BreakGlassGrant, len(grants))
+	for i, g := range grants {
+		res[i] = breakGlassGrant(g)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode break-glass grants", slog.String("error", err.Error()))
+	}
+}
+
+// handleReviewBreakGlass handles POST /api/admin/break-glass/{id}/review.
+func (app *application) handleReviewBreakGlass(w http.ResponseWriter, r *http.Request) {
+	if !app.breakGlassEnabled(w, r) {
+		return
+	}
+	reviewer, _ := roleFromContext(r.Context())
+
+	var req client.ReviewBreakGlassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	g, err := app.breakGlass.Review(r.PathValue("id"), reviewer.User.UserID, actorName(reviewer.User), req.Note)
+	switch {
+	case errors.Is(err, breakglass.ErrNotFound):
+		app.clientError(w, r, http.StatusNotFound, "Break-glass grant not found")
+		return
+	case errors.Is(err, breakglass.ErrOwnGrant):
+		app.clientError(w, r, http.StatusForbidden, "Break-glass grants must be reviewed by another admin")
+		return
+	case errors.Is(err, breakglass.ErrReviewed):
+		app.clientError(w, r, http.StatusConflict, "The break-glass grant is already reviewed")
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit.Record(r.Context(), audit.Event{
+		Actor:      actorName(reviewer.User),
+		Action:     "breakglass.review",
+		Repository: g.Repository,
+		Outcome:    audit.OutcomeSuccess,
+		Detail:     fmt.Sprintf("break_glass=%s user=%s note=%q", g.ID, g.Login, g.Review.Note),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(breakGlassGrant(g)); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode break-glass grant", slog.String("error", err.Error()))
+	}
+}
+
+// notify sends a high-priority notification to the configured webhook in
+// the background, so a slow receiver doesn't hold up the request. A failed
+// delivery is logged; the action it reports has happened anyway.
+func (app *application) notify(ctx context.Context, m notify.Message) {
+	hook := notify.Webhook{URL: app.cfg().NotifyWebhookURL}
+	// The request may be over before the delivery is; keep its values
+	// (request ID, trace) for the log but not its cancellation
+	ctx = context.WithoutCancel(ctx)
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		if err := hook.Send(ctx, m); err != nil {
+			app.logger.ErrorContext(ctx, "Failed to send notification", slog.String("event", m.Event), slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// breakGlassGrant returns the wire format of g.
+func breakGlassGrant(g breakglass.Grant) client.BreakGlassGrant {
+	res := client.BreakGlassGrant{
+		ID:            g.ID,
+		Login:         g.Login,
+		Repository:    g.Repository,
+		Justification: g.Justification,
+		Ticket:        g.Ticket,
+		CreatedAt:     g.CreatedAt,
+		ExpiresAt:     g.ExpiresAt,
+	}
+	if g.Review != nil {
+		res.Review = &client.BreakGlassReview{Reviewer: g.Review.Reviewer, Note: g.Review.Note, At: g.Review.At}
+	}
+	return res
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/breakglass"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/notify"
+	"github.com/RobinMaas95/gh-secret-broker/internal/roles"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+func TestBreakGlass(t *testing.T) {
+	var notifications []notify.Message
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m notify.Message
+		_ = json.NewDecoder(r.Body).Decode(&m)
+		notifications = append(notifications, m)
+	}))
+	defer hook.Close()
+
+	var auditBuf bytes.Buffer
+	store, _ := breakglass.NewStore("")
+	app := &application{
+		logger: setupTestLogger(),
+		config: &config.Config{
+			GithubOrgs:          []string{"org"},
+			AdminUsers:          []string{"root", "lead"},
+			BreakGlass:          true,
+			BreakGlassDuration:  time.Hour,
+			BreakGlassMaxActive: 1,
+			NotifyWebhookURL:    hook.URL,
+		},
+		repositories: &mockRepositoryService{
+			IsOrgMemberFunc: func(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+				return login != "outsider", nil
+			},
+			// Nobody maintains the repository
+			HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+				return false, nil
+			},
+		},
+		audit:      audit.New(slog.NewJSONHandler(&auditBuf, nil)),
+		breakGlass: store,
+	}
+
+	cookies := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = cookies
+	cookie := func(login string) string {
+		return sessionCookie(cookies, goth.User{UserID: login + "-id", NickName: login, AccessToken: "valid-token"})
+	}
+	breakGlass := func(login string, req client.BreakGlassRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/api/break-glass", bytes.NewReader(body))
+		r.Header.Set("Cookie", cookie(login))
+		w := httptest.NewRecorder()
+		app.handleCreateBreakGlass(w, r)
+		return w
+	}
+	setSecret := func(login string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/repo/org/repo/secrets/DEPLOY_KEY", strings.NewReader(`{"value":"new"}`))
+		r.SetPathValue("owner", "org")
+		r.SetPathValue("repo", "repo")
+		r.SetPathValue("name", "DEPLOY_KEY")
+		r.Header.Set("Cookie", cookie(login))
+		w := httptest.NewRecorder()
+		app.handleCreateSecret(w, r)
+		return w
+	}
+	valid := client.BreakGlassRequest{Repository: "org/repo", Justification: "Deploy key leaked in a public gist", Ticket: "INC-1234"}
+
+	var grant client.BreakGlassGrant
+	t.Run("Break the glass", func(t *testing.T) {
+		assert.Equal(t, setSecret("oncall").Code, http.StatusForbidden)
+
+		w := breakGlass("oncall", valid)
+		assert.Equal(t, w.Code, http.StatusCreated)
+		_ = json.NewDecoder(w.Body).Decode(&grant)
+		assert.Equal(t, grant.Ticket, "INC-1234")
+		// Notifications are sent in the background
+		app.background.Wait()
+		assert.Equal(t, len(notifications), 1)
+		assert.Equal(t, notifications[0].Priority, notify.PriorityHigh)
+		assert.Equal(t, strings.Contains(auditBuf.String(), `"action":"breakglass.create"`), true)
+
+		assert.Equal(t, setSecret("oncall").Code, http.StatusNoContent)
+		assert.Equal(t, strings.Contains(auditBuf.String(), "break_glass="+grant.ID), true)
+		// The grant is for the user who broke the glass only
+		assert.Equal(t, setSecret("someone").Code, http.StatusForbidden)
+	})
+
+	t.Run("No deletes", func(t *testing.T) {
+		r := httptest.NewRequest("DELETE", "/api/repo/org/repo/secrets/DEPLOY_KEY", nil)
+		r.SetPathValue("owner", "org")
+		r.SetPathValue("repo", "repo")
+		r.SetPathValue("name", "DEPLOY_KEY")
+		r.Header.Set("Cookie", cookie("oncall"))
+		w := httptest.NewRecorder()
+		app.handleDeleteSecret(w, r)
+		assert.Equal(t, w.Code, http.StatusForbidden)
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		short := valid
+		short.Justification = "leak"
+		assert.Equal(t, breakGlass("oncall", short).Code, http.StatusUnprocessableEntity)
+
+		elsewhere := valid
+		elsewhere.Repository = "other-org/repo"
+		assert.Equal(t, breakGlass("oncall", elsewhere).Code, http.StatusUnprocessableEntity)
+
+		assert.Equal(t, breakGlass("outsider", valid).Code, http.StatusForbidden)
+
+		// oncall's grant from above is still active
+		other := valid
+		other.Repository = "org/other"
+		assert.Equal(t, breakGlass("oncall", other).Code, http.StatusConflict)
+	})
+
+	t.Run("Review", func(t *testing.T) {
+		admin := app.requireRole(roles.Admin)(http.HandlerFunc(app.handleReviewBreakGlass))
+		review := func(login string) *httptest.ResponseRecorder {
+			r := httptest.NewRequest("POST", "/api/admin/break-glass/"+grant.ID+"/review", strings.NewReader(`{"note":"Key rotated"}`))
+			r.SetPathValue("id", grant.ID)
+			r.Header.Set("Cookie", cookie(login))
+			w := httptest.NewRecorder()
+			admin.ServeHTTP(w, r)
+			return w
+		}
+
+		assert.Equal(t, review("oncall").Code, http.StatusForbidden)
+		assert.Equal(t, review("root").Code, http.StatusOK)
+		assert.Equal(t, review("lead").Code, http.StatusConflict)
+		assert.Equal(t, strings.Contains(auditBuf.String(), `"action":"breakglass.review"`), true)
+
+		r := httptest.NewRequest("GET", "/api/admin/break-glass?pending=true", nil)
+		w := httptest.NewRecorder()
+		app.handleListBreakGlass(w, r)
+		var pending []client.BreakGlassGrant
+		_ = json.NewDecoder(w.Body).Decode(&pending)
+		assert.Equal(t, len(pending), 0)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		app.config.BreakGlass = false
+		defer func() { app.config.BreakGlass = true }()
+
+		assert.Equal(t, breakGlass("oncall", valid).Code, http.StatusNotFound)
+		// Existing grants stop working as well
+		assert.Equal(t, setSecret("oncall").Code, http.StatusForbidden)
+	})
+}
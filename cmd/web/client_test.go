@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/markbates/goth"
+)
+
+// TestClient runs the public client against the real routes, so the client
+// and the server can't drift apart.
+func TestClient(t *testing.T) {
+	secrets := map[string]string{}
+	mockService := &mockRepositoryService{
+		HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+			return true, nil
+		},
+		UserHasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+			return repo != "locked", nil
+		},
+		CreateOrUpdateSecretFunc: func(ctx context.Context, client *github.Client, owner, repo, name, value string) error {
+			secrets[name] = value
+			return nil
+		},
+		ListSecretsFunc: func(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+			var names []string
+			for name := range secrets {
+				names = append(names, name)
+			}
+			return names, nil
+		},
+	}
+	tokens, _ := apitoken.NewStore("")
+	cfg := &config.Config{SessionSecret: "test-secret", GithubOrgs: []string{"test-org", "other-org"}, LoginProviders: []string{"github"}}
+	app := &application{
+		logger:       setupTestLogger(),
+		config:       cfg,
+		repositories: mockService,
+		tokens:       tokens,
+	}
+	oauthService, err := oauth.NewService(setupTestLogger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The client must get by without the headers only browsers send
+	routes := app.routes(oauthService)
+	var fetchMetadata []string
+	ts := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"Sec-Fetch-Site", "Origin", "Referer"} {
+			if r.Header.Get(h) != "" {
+				fetchMetadata = append(fetchMetadata, h)
+			}
+		}
+		routes.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	withSession(req, goth.User{UserID: "42", NickName: "octocat", AccessToken: "valid-token"})
+	cookie, err := http.ParseSetCookie(req.Header.Get("Cookie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.NewSession(ts.URL, cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	t.Run("Providers", func(t *testing.T) {
+		providers, err := session.Providers(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Equal(t, providers.ProvidersMap["github"], "Github")
+	})
+
+	t.Run("Session writes", func(t *testing.T) {
+		// Only the broker's own pages may change state with a session
+		err := session.SetActiveOrg(ctx, "other-org")
+		assert.Equal(t, client.IsCode(err, client.CodeCSRFFailed), true)
+		orgs, err := session.ListOrgs(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Equal(t, orgs.Active, "")
+	})
+
+	tok, secret, err := tokens.Create("42", "octocat", apitoken.Request{
+		Name:         "ci",
+		Repositories: []string{"test-org/*"},
+		Actions:      []apitoken.Action{apitoken.ActionRead, apitoken.ActionWrite},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withToken := client.New(ts.URL, secret)
+
+	t.Run("Tokens", func(t *testing.T) {
+		list, err := session.ListTokens(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Equal(t, len(list), 1)
+		assert.Equal(t, list[0].ID, tok.ID)
+		assert.Equal(t, list[0].Login, "octocat")
+
+		_, err = withToken.ListTokens(ctx)
+		assert.Equal(t, client.IsCode(err, client.CodeForbidden), true)
+	})
+
+	t.Run("Secrets with a token", func(t *testing.T) {
+		// Bearer-token writes are exempt from the CSRF check
+		fetchMetadata = nil
+		if err := withToken.SetSecret(ctx, "test-org", "repo-1", "API_KEY", "v"); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Equal(t, len(fetchMetadata), 0)
+		names, err := withToken.ListSecrets(ctx, "test-org", "repo-1")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		assert.Equal(t, len(names), 1)
+		assert.Equal(t, secrets["API_KEY"], "v")
+
+		err = withToken.DeleteSecret(ctx, "test-org", "repo-1", "API_KEY")
+		assert.Equal(t, client.IsCode(err, client.CodeForbidden), true)
+
+		err = withToken.SetSecret(ctx, "test-org", "locked", "API_KEY", "v")
+		var e *client.Error
+		if !errors.As(err, &e) || e.Status != http.StatusForbidden || e.RequestID == "" {
+			t.Errorf("Expected a 403 with a request ID, got %v", err)
+		}
+	})
+
+	t.Run("Revoked token", func(t *testing.T) {
+		if _, err := tokens.Revoke("42", tok.ID); err != nil {
+			t.Fatal(err)
+		}
+		_, err := withToken.ListSecrets(ctx, "test-org", "repo-1")
+		assert.Equal(t, client.IsCode(err, client.CodeUnauthorized), true)
+	})
+}
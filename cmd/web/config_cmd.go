@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+)
+
+const configUsage = `usage: web config validate [-config path]`
+
+// runConfigCommand implements the "config" subcommand and returns the exit code.
+// "config validate" loads the configuration exactly like the server would and
+// reports every problem at once.
+func runConfigCommand(args []string, out io.Writer) int {
+	if len(args) == 0 || args[0] != "validate" {
+		_, _ = fmt.Fprintln(out, configUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML config file")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	_, err := config.Load(*configPath)
+	if err == nil {
+		_, _ = fmt.Fprintln(out, "Configuration is valid")
+		return 0
+	}
+
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		_, _ = fmt.Fprintf(out, "Configuration is invalid: %v\n", err)
+		return 1
+	}
+
+	_, _ = fmt.Fprintf(out, "Configuration is invalid (%d problems):\n", len(validationErr.Problems))
+	for _, problem := range validationErr.Problems {
+		_, _ = fmt.Fprintf(out, "  - %s\n", problem)
+	}
+	return 1
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+)
+
+func TestRunConfigCommand(t *testing.T) {
+	for _, key := range []string{"GITHUB_CLIENT_ID", "GITHUB_CLIENT_SECRET", "GITHUB_ORG", "GITHUB_PAT", "SESSION_SECRET", "ENVIRONMENT", "LOG_FORMAT", "CONFIG_FILE"} {
+		t.Setenv(key, "")
+	}
+
+	t.Run("Valid config", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		content := "github_client_id: id\ngithub_client_secret: secret\ngithub_orgs: [org]\ngithub_pat: pat\n"
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		code := runConfigCommand([]string{"validate", "-config", path}, &out)
+
+		assert.Equal(t, code, 0)
+		assert.Equal(t, strings.TrimSpace(out.String()), "Configuration is valid")
+	})
+
+	t.Run("Reports every problem", func(t *testing.T) {
+		t.Setenv("LOG_FORMAT", "xml")
+
+		var out bytes.Buffer
+		code := runConfigCommand([]string{"validate"}, &out)
+
+		assert.Equal(t, code, 1)
+		for _, want := range []string{"LOG_FORMAT", "GITHUB_CLIENT_ID", "GITHUB_CLIENT_SECRET", "GITHUB_ORG", "GITHUB_PAT"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("expected output to mention %s, got:\n%s", want, out.String())
+			}
+		}
+	})
+
+	t.Run("Unknown subcommand", func(t *testing.T) {
+		var out bytes.Buffer
+		code := runConfigCommand([]string{"show"}, &out)
+
+		assert.Equal(t, code, 2)
+	})
+}
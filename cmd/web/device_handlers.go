@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+)
+
+// deviceTokenName names the API tokens issued by device logins.
+const deviceTokenName = "Device login"
+
+// deviceTokenLifetime is how long API tokens of device logins are valid by
+// default, and at most. Longer-lived tokens are created in the UI.
+const deviceTokenLifetime = 24 * time.Hour
+
+// deviceTokenActions are what API tokens of device logins can do by default.
+// Deleting secrets has to be asked for.
+var deviceTokenActions = []apitoken.Action{apitoken.ActionRead, apitoken.ActionWrite}
+
+const (
+	// deviceCodesPerMinute limits the device logins a client IP can start.
+	// Starting one is unauthenticated and costs a GitHub request.
+	deviceCodesPerMinute = 5
+	// maxPendingDeviceLogins bounds the device logins kept at once.
+	maxPendingDeviceLogins = 1000
+)
+
+// deviceLogin is a device login waiting for the user to enter the code.
+type deviceLogin struct {
+	githubCode string
+	expiresAt  time.Time
+	interval   time.Duration
+	lastPoll   time.Time
+	// token is the scope of the API token the login yields, before it is
+	// limited to the organizations the user is a member of.
+	token apitoken.Request
+}
+
+// deviceLogins keeps the pending device logins. Clients get a code of the
+// broker's own, so GitHub's device code never leaves the server and only the
+// broker can complete the login. The zero value is ready to use.
+type deviceLogins struct {
+	mu      sync.Mutex
+	pending map[string]*deviceLogin
+}
+
+// add adds a pending login. It reports false if there are already
+// maxPendingDeviceLogins that haven't expired.
+func (d *deviceLogins) add(code string, login *deviceLogin) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == nil {
+		d.pending = map[string]*deviceLogin{}
+	}
+	d.prune()
+	if len(d.pending) >= maxPendingDeviceLogins {
+		return false
+	}
+	d.pending[code] = login
+	return true
+}
+
+// full reports whether add would fail, so the GitHub request of a new login
+// can be skipped.
+func (d *deviceLogins) full() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune()
+	return len(d.pending) >= maxPendingDeviceLogins
+}
+
+// prune removes the expired logins. d.mu must be held.
+func (d *deviceLogins) prune() {
+	now := time.Now()
+	for c, l := range d.pending {
+		if now.After(l.expiresAt) {
+			delete(d.pending, c)
+		}
+	}
+}
+
+// poll returns the pending login of code. tooFast reports whether the client
+// polled before the interval passed.
+func (d *deviceLogins) poll(code string) (login deviceLogin, tooFast, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.pending[code]
+	if !ok || time.Now().After(l.expiresAt) {
+		delete(d.pending, code)
+		return deviceLogin{}, false, false
+	}
+	now := time.Now()
+	tooFast = now.Sub(l.lastPoll) < l.interval
+	l.lastPoll = now
+	return *l, tooFast, true
+}
+
+// slowDown makes the client of code wait longer, as RFC 8628 requires on
+// slow_down.
+func (d *deviceLogins) slowDown(code string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if l, ok := d.pending[code]; ok {
+		l.interval += 5 * time.Second
+	}
+}
+
+func (d *deviceLogins) remove(code string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, code)
+}
+
+// clientIP returns the IP address of the client of r, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// deviceFlowEnabled answers requests with 404 when device logins are off.
+func (app *application) deviceFlowEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if app.deviceFlow == nil || !app.cfg().GithubDeviceFlow {
+		app.clientError(w, r, http.StatusNotFound, "Device login is not enabled")
+		return false
+	}
+	return true
+}
+
+// handleDeviceCode handles POST /api/device/code. It starts a GitHub device
+// login and returns the code the user confirms on GitHub. As anyone can call
+// it, it is rate limited per client IP and the pending logins are capped.
+//
+// The optional body narrows the API token the login yields; its scope is
+// checked here, so the user doesn't confirm a login that can't succeed.
+func (app *application) handleDeviceCode(w http.ResponseWriter, r *http.Request) {
+	if !app.deviceFlowEnabled(w, r) {
+		return
+	}
+
+	now := time.Now()
+	if wait, ok := app.deviceLimiter.allow(clientIP(r), deviceCodesPerMinute, now); !ok {
+		app.logger.WarnContext(r.Context(), "Device login rate limit exceeded", slog.String("ip", clientIP(r)))
+		app.errorResponse(w, r, &repository.Error{Code: repository.CodeRateLimited, Message: "Too many device logins", RetryAt: now.Add(wait)})
+		return
+	}
+	var req client.DeviceCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	token, rejected := app.deviceTokenRequest(req)
+	if rejected != "" {
+		app.clientError(w, r, http.StatusUnprocessableEntity, rejected)
+		return
+	}
+
+	tooMany := &repository.Error{Code: repository.CodeRateLimited, Message: "Too many pending device logins, try again later", RetryAt: now.Add(time.Minute)}
+	if app.devices.full() {
+		app.logger.WarnContext(r.Context(), "Too many pending device logins")
+		app.errorResponse(w, r, tooMany)
+		return
+	}
+
+	auth, err := app.deviceFlow.Start(r.Context())
+	if err != nil {
+		app.serverError(w, r, fmt.Errorf("starting device login: %w", err))
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	code := hex.EncodeToString(b)
+	if !app.devices.add(code, &deviceLogin{
+		githubCode: auth.DeviceCode,
+		expiresAt:  time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second),
+		interval:   time.Duration(auth.Interval) * time.Second,
+		token:      token,
+	}) {
+		app.logger.WarnContext(r.Context(), "Too many pending device logins")
+		app.errorResponse(w, r, tooMany)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(client.DeviceCode{
+		DeviceCode:      code,
+		UserCode:        auth.UserCode,
+		VerificationURI: auth.VerificationURI,
+		ExpiresIn:       auth.ExpiresIn,
+		Interval:        auth.Interval,
+	}); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode device code", slog.String("error", err.Error()))
+	}
+}
+
+// deviceTokenRequest returns the API token a device login started with req
+// yields, or the reason for rejecting req.
+func (app *application) deviceTokenRequest(req client.DeviceCodeRequest) (apitoken.Request, string) {
+	cfg := app.cfg()
+	token := apitoken.Request{
+		Name:         deviceTokenName,
+		Repositories: req.Repositories,
+		Actions:      apitokenActions(req.Actions),
+		Lifetime:     time.Duration(req.ExpiresInHours) * time.Hour,
+	}
+	if len(token.Repositories) == 0 {
+		for _, org := range cfg.GithubOrgs {
+			token.Repositories = append(token.Repositories, org+"/*")
+		}
+	}
+	if len(token.Actions) == 0 {
+		token.Actions = deviceTokenActions
+	}
+	if token.Lifetime == 0 {
+		token.Lifetime = deviceTokenLifetime
+	}
+
+	if token.Lifetime < 0 || token.Lifetime > deviceTokenLifetime {
+		return token, fmt.Sprintf("Device login tokens are valid for at most %d hours", deviceTokenLifetime/time.Hour)
+	}
+	for _, scope := range token.Repositories {
+		owner, _, _ := strings.Cut(scope, "/")
+		if _, ok := cfg.LookupOrg(owner); !ok {
+			return token, fmt.Sprintf("Repository %q is not in one of the configured organizations", scope)
+		}
+	}
+	if err := token.Validate(); err != nil {
+		return token, err.Error()
+	}
+	return token, ""
+}
+
+// handleDeviceToken handles POST /api/device/token. Once the user confirmed
+// the login on GitHub, it issues an API token. The token has the scope asked
+// for when the login started, limited to the configured organizations the
+// user is a member of, and, like every API token, only works on repositories
+// the user maintains.
+func (app *application) handleDeviceToken(w http.ResponseWriter, r *http.Request) {
+	if !app.deviceFlowEnabled(w, r) {
+		return
+	}
+
+	var req client.DeviceTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	login, tooFast, ok := app.devices.poll(req.DeviceCode)
+	switch {
+	case !ok:
+		problem.Error(w, r, http.StatusBadRequest, client.CodeExpiredToken, "The device code is unknown or expired, start a new login")
+		return
+	case tooFast:
+		app.devices.slowDown(req.DeviceCode)
+		problem.Error(w, r, http.StatusBadRequest, client.CodeSlowDown, "Polling too fast")
+		return
+	}
+
+	accessToken, err := app.deviceFlow.Exchange(r.Context(), login.githubCode)
+	switch {
+	case errors.Is(err, oauth.ErrAuthorizationPending):
+		problem.Error(w, r, http.StatusBadRequest, client.CodeAuthorizationPending, "Waiting for the user to enter the code")
+		return
+	case errors.Is(err, oauth.ErrSlowDown):
+		app.devices.slowDown(req.DeviceCode)
+		problem.Error(w, r, http.StatusBadRequest, client.CodeSlowDown, "Polling too fast")
+		return
+	case errors.Is(err, oauth.ErrExpiredToken):
+		app.devices.remove(req.DeviceCode)
+		problem.Error(w, r, http.StatusBadRequest, client.CodeExpiredToken, "The device code expired, start a new login")
+		return
+	case errors.Is(err, oauth.ErrAccessDenied):
+		app.devices.remove(req.DeviceCode)
+		problem.Error(w, r, http.StatusBadRequest, client.CodeAccessDenied, "The login was denied")
+		return
+	case err != nil:
+		app.serverError(w, r, fmt.Errorf("completing device login: %w", err))
+		return
+	}
+	// The code is used up, whatever happens next
+	app.devices.remove(req.DeviceCode)
+
+	userClient, err := app.getGitHubClient(r.Context(), accessToken)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	ghUser, _, err := userClient.Users.Get(r.Context(), "")
+	if err != nil {
+		app.serverError(w, r, fmt.Errorf("fetching device login user: %w", err))
+		return
+	}
+	userID := fmt.Sprint(ghUser.GetID())
+	ctx := repository.WithUser(r.Context(), userID)
+
+	token := login.token
+	token.Repositories = nil
+	for _, org := range app.cfg().GithubOrgs {
+		var inOrg []string
+		for _, scope := range login.token.Repositories {
+			if owner, _, _ := strings.Cut(scope, "/"); strings.EqualFold(owner, org) {
+				inOrg = append(inOrg, scope)
+			}
+		}
+		if len(inOrg) == 0 {
+			continue
+		}
+		member, err := app.repositories.IsOrgMember(ctx, app.pat(), org, ghUser.GetLogin())
+		if err != nil {
+			app.errorResponse(w, r, err)
+			return
+		}
+		if member {
+			token.Repositories = append(token.Repositories, inOrg...)
+		}
+	}
+	if len(token.Repositories) == 0 {
+		app.logger.WarnContext(r.Context(), "Device login of a user outside the configured organizations", slog.String("user", ghUser.GetLogin()))
+		problem.Error(w, r, http.StatusForbidden, client.CodeAccessDenied, "You are not a member of any of the broker's organizations")
+		return
+	}
+
+	tok, secret, err := app.tokens.Create(userID, ghUser.GetLogin(), token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.recordTokenEvent(r, ghUser.GetLogin(), "token.create", tok)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(client.DeviceToken{Token: secret, ExpiresAt: tok.ExpiresAt}); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode device token", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+)
+
+// fakeDeviceGitHub serves GitHub's device flow and user endpoints. The login
+// completes once confirmed is set. started counts the logins started.
+type fakeDeviceGitHub struct {
+	*httptest.Server
+	interval  int
+	confirmed bool
+	started   int
+}
+
+func newFakeDeviceGitHub(t *testing.T) *fakeDeviceGitHub {
+	f := &fakeDeviceGitHub{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/device/code", func(w http.ResponseWriter, r *http.Request) {
+		f.started++
+		_ = json.NewEncoder(w).Encode(oauth.DeviceAuthorization{
+			DeviceCode:      "github-device-code",
+			UserCode:        "ABCD-1234",
+			VerificationURI: "https://github.com/login/device",
+			ExpiresIn:       900,
+			Interval:        f.interval,
+		})
+	})
+	mux.HandleFunc("POST /login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("device_code") != "github-device-code" {
+			t.Errorf("Expected GitHub's device code, got %q", r.PostForm.Get("device_code"))
+		}
+		if !f.confirmed {
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "user-token"})
+	})
+	mux.HandleFunc("GET /api/v3/user", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(github.User{ID: github.Ptr(int64(42)), Login: github.Ptr("octocat")})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func TestDeviceLogin(t *testing.T) {
+	newApp := func(gh *fakeDeviceGitHub, memberOf ...string) (*application, *bytes.Buffer) {
+		var auditBuf bytes.Buffer
+		tokens, _ := apitoken.NewStore("")
+		deviceFlow := oauth.NewDeviceFlow("client-id", gh.URL)
+		deviceFlow.HTTPClient = gh.Client()
+		return &application{
+			logger: setupTestLogger(),
+			config: &config.Config{
+				GithubOrgs:          []string{"org-a", "org-b"},
+				GithubEnterpriseURL: gh.URL,
+				GithubDeviceFlow:    true,
+			},
+			repositories: &mockRepositoryService{
+				IsOrgMemberFunc: func(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+					if login != "octocat" {
+						t.Errorf("Expected the membership of octocat to be checked, got %s", login)
+					}
+					for _, o := range memberOf {
+						if o == org {
+							return true, nil
+						}
+					}
+					return false, nil
+				},
+			},
+			audit:         audit.New(slog.NewJSONHandler(&auditBuf, nil)),
+			tokens:        tokens,
+			deviceFlow:    deviceFlow,
+			userTransport: http.DefaultTransport,
+		}, &auditBuf
+	}
+
+	// startWith starts a device login asking for the token of req, or the
+	// default one when req is nil.
+	startWith := func(app *application, req *client.DeviceCodeRequest) *httptest.ResponseRecorder {
+		var body io.Reader
+		if req != nil {
+			data, _ := json.Marshal(req)
+			body = bytes.NewReader(data)
+		}
+		w := httptest.NewRecorder()
+		app.handleDeviceCode(w, httptest.NewRequest("POST", "/api/device/code", body))
+		return w
+	}
+	start := func(t *testing.T, app *application) client.DeviceCode {
+		t.Helper()
+		w := startWith(app, nil)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var code client.DeviceCode
+		_ = json.NewDecoder(w.Body).Decode(&code)
+		return code
+	}
+	poll := func(app *application, deviceCode string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body, _ := json.Marshal(client.DeviceTokenRequest{DeviceCode: deviceCode})
+		app.handleDeviceToken(w, httptest.NewRequest("POST", "/api/device/token", bytes.NewReader(body)))
+		return w
+	}
+	problemCode := func(w *httptest.ResponseRecorder) string {
+		var p struct {
+			Code string `json:"code"`
+		}
+		_ = json.NewDecoder(w.Body).Decode(&p)
+		return p.Code
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		gh := newFakeDeviceGitHub(t)
+		app, auditBuf := newApp(gh, "org-b")
+
+		code := start(t, app)
+		assert.Equal(t, code.UserCode, "ABCD-1234")
+		if code.DeviceCode == "github-device-code" {
+			t.Error("Expected GitHub's device code not to be handed out")
+		}
+
+		w := poll(app, code.DeviceCode)
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+		assert.Equal(t, problemCode(w), client.CodeAuthorizationPending)
+
+		gh.confirmed = true
+		w = poll(app, code.DeviceCode)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var res client.DeviceToken
+		_ = json.NewDecoder(w.Body).Decode(&res)
+		tok, err := app.tokens.Authenticate(res.Token)
+		if err != nil {
+			t.Fatalf("Expected a valid API token: %v", err)
+		}
+		assert.Equal(t, tok.UserID, "42")
+		assert.Equal(t, tok.Login, "octocat")
+		assert.Equal(t, strings.Join(tok.Repositories, ","), "org-b/*")
+		// Device tokens can't delete secrets and expire within a day
+		assert.Equal(t, slices.Equal(tok.Actions, []apitoken.Action{apitoken.ActionRead, apitoken.ActionWrite}), true)
+		assert.Equal(t, time.Until(res.ExpiresAt) > 23*time.Hour && time.Until(res.ExpiresAt) <= 24*time.Hour, true)
+		assert.Equal(t, strings.Contains(auditBuf.String(), `"action":"token.create"`), true)
+
+		// The device code can't be used twice
+		w = poll(app, code.DeviceCode)
+		assert.Equal(t, problemCode(w), client.CodeExpiredToken)
+	})
+
+	t.Run("Narrower scope", func(t *testing.T) {
+		gh := newFakeDeviceGitHub(t)
+		gh.confirmed = true
+		app, _ := newApp(gh, "org-b")
+
+		w := startWith(app, &client.DeviceCodeRequest{
+			Repositories:   []string{"org-a/api", "org-b/web"},
+			Actions:        []string{"secrets:read"},
+			ExpiresInHours: 2,
+		})
+		assert.Equal(t, w.Code, http.StatusOK)
+		var code client.DeviceCode
+		_ = json.NewDecoder(w.Body).Decode(&code)
+
+		w = poll(app, code.DeviceCode)
+		assert.Equal(t, w.Code, http.StatusOK)
+		var res client.DeviceToken
+		_ = json.NewDecoder(w.Body).Decode(&res)
+		tok, err := app.tokens.Authenticate(res.Token)
+		if err != nil {
+			t.Fatalf("Expected a valid API token: %v", err)
+		}
+		// Only the repositories in organizations the user is a member of remain
+		assert.Equal(t, strings.Join(tok.Repositories, ","), "org-b/web")
+		assert.Equal(t, slices.Equal(tok.Actions, []apitoken.Action{apitoken.ActionRead}), true)
+		assert.Equal(t, time.Until(res.ExpiresAt) <= 2*time.Hour, true)
+	})
+
+	t.Run("Invalid scope", func(t *testing.T) {
+		tests := []struct {
+			name string
+			req  client.DeviceCodeRequest
+		}{
+			{name: "Too long", req: client.DeviceCodeRequest{ExpiresInHours: 25}},
+			{name: "Other organization", req: client.DeviceCodeRequest{Repositories: []string{"other-org/api"}}},
+			{name: "Unknown action", req: client.DeviceCodeRequest{Actions: []string{"admin"}}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				gh := newFakeDeviceGitHub(t)
+				app, _ := newApp(gh)
+
+				w := startWith(app, &tt.req)
+				assert.Equal(t, w.Code, http.StatusUnprocessableEntity)
+				assert.Equal(t, gh.started, 0)
+			})
+		}
+	})
+
+	t.Run("Not a member", func(t *testing.T) {
+		gh := newFakeDeviceGitHub(t)
+		gh.confirmed = true
+		app, _ := newApp(gh)
+
+		w := poll(app, start(t, app).DeviceCode)
+		assert.Equal(t, w.Code, http.StatusForbidden)
+		assert.Equal(t, problemCode(w), client.CodeAccessDenied)
+		assert.Equal(t, len(app.tokens.List("42")), 0)
+	})
+
+	t.Run("Slow down", func(t *testing.T) {
+		gh := newFakeDeviceGitHub(t)
+		gh.interval = 5
+		app, _ := newApp(gh)
+
+		code := start(t, app)
+		assert.Equal(t, problemCode(poll(app, code.DeviceCode)), client.CodeAuthorizationPending)
+		assert.Equal(t, problemCode(poll(app, code.DeviceCode)), client.CodeSlowDown)
+	})
+
+	t.Run("Unknown code", func(t *testing.T) {
+		app, _ := newApp(newFakeDeviceGitHub(t))
+
+		w := poll(app, "unknown")
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+		assert.Equal(t, problemCode(w), client.CodeExpiredToken)
+	})
+
+	t.Run("Rate limited", func(t *testing.T) {
+		gh := newFakeDeviceGitHub(t)
+		app, _ := newApp(gh)
+
+		for range deviceCodesPerMinute {
+			start(t, app)
+		}
+		w := httptest.NewRecorder()
+		app.handleDeviceCode(w, httptest.NewRequest("POST", "/api/device/code", nil))
+		assert.Equal(t, w.Code, http.StatusTooManyRequests)
+
+		// Other clients can still log in
+		r := httptest.NewRequest("POST", "/api/device/code", nil)
+		r.RemoteAddr = "198.51.100.7:4321"
+		w = httptest.NewRecorder()
+		app.handleDeviceCode(w, r)
+		assert.Equal(t, w.Code, http.StatusOK)
+	})
+
+	t.Run("Too many pending", func(t *testing.T) {
+		gh := newFakeDeviceGitHub(t)
+		app, _ := newApp(gh)
+		for i := range maxPendingDeviceLogins {
+			app.devices.add(fmt.Sprint(i), &deviceLogin{expiresAt: time.Now().Add(time.Minute)})
+		}
+
+		w := httptest.NewRecorder()
+		app.handleDeviceCode(w, httptest.NewRequest("POST", "/api/device/code", nil))
+		assert.Equal(t, w.Code, http.StatusTooManyRequests)
+		assert.Equal(t, gh.started, 0)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		app, _ := newApp(newFakeDeviceGitHub(t))
+		app.config.GithubDeviceFlow = false
+
+		w := httptest.NewRecorder()
+		app.handleDeviceCode(w, httptest.NewRequest("POST", "/api/device/code", nil))
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+)
+
+// repositoryErrorStatus maps the codes of repository errors to HTTP statuses.
+var repositoryErrorStatus = map[repository.Code]int{
+	repository.CodeNotFound:    http.StatusNotFound,
+	repository.CodeForbidden:   http.StatusForbidden,
+	repository.CodeValidation:  http.StatusUnprocessableEntity,
+	repository.CodeUpstream:    http.StatusBadGateway,
+	repository.CodeRateLimited: http.StatusTooManyRequests,
+}
+
+// errorResponse answers a failed repository call with problem details. It is
+// the one place that decides which status a repository error gets. Errors
+// that aren't *repository.Error are internal errors.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var repoErr *repository.Error
+	if !errors.As(err, &repoErr) {
+		app.logger.ErrorContext(r.Context(), "Unexpected error", slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal Server Error")
+		return
+	}
+
+	status, ok := repositoryErrorStatus[repoErr.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	details := problem.Details{Status: status, Code: string(repoErr.Code), Detail: repoErr.Message}
+
+	if repoErr.Code == repository.CodeRateLimited {
+		// Retry-After is in whole seconds; round up so clients don't come back early
+		seconds := max(int(math.Ceil(time.Until(repoErr.RetryAt).Seconds())), 1)
+		retryAt := repoErr.RetryAt.UTC().Truncate(time.Second)
+		details.RetryAt = &retryAt
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	problem.Write(w, r, details)
+}
+
+// clientError answers with a problem for a request the client got wrong.
+func (app *application) clientError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	code := problem.CodeBadRequest
+	switch status {
+	case http.StatusUnauthorized:
+		code = problem.CodeUnauthorized
+	case http.StatusForbidden:
+		code = problem.CodeForbidden
+	case http.StatusNotFound:
+		code = string(repository.CodeNotFound)
+	case http.StatusUnprocessableEntity:
+		code = string(repository.CodeValidation)
+	}
+	problem.Error(w, r, status, code, detail)
+}
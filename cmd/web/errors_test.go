@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+func TestErrorResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"Not found", &repository.Error{Code: repository.CodeNotFound, Message: "gone"}, http.StatusNotFound, "not_found"},
+		{"Forbidden", &repository.Error{Code: repository.CodeForbidden, Message: "no"}, http.StatusForbidden, "forbidden"},
+		{"Validation", &repository.Error{Code: repository.CodeValidation, Message: "bad name"}, http.StatusUnprocessableEntity, "validation_failed"},
+		{"Upstream", &repository.Error{Code: repository.CodeUpstream, Message: "GitHub request failed"}, http.StatusBadGateway, "upstream_error"},
+		{"Rate limited", &repository.Error{Code: repository.CodeRateLimited, Message: "slow down", RetryAt: time.Now().Add(time.Minute)}, http.StatusTooManyRequests, "rate_limited"},
+		{"Unclassified", errors.New("boom"), http.StatusInternalServerError, "internal_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{logger: setupTestLogger()}
+			req := httptest.NewRequest("GET", "/api/repo/o/r/secrets", nil)
+			w := httptest.NewRecorder()
+
+			app.errorResponse(w, req, tt.err)
+
+			assert.Equal(t, w.Code, tt.wantStatus)
+			assert.Equal(t, w.Header().Get("Content-Type"), problem.ContentType)
+
+			var body problem.Details
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode body: %v", err)
+			}
+			assert.Equal(t, body.Code, tt.wantCode)
+			assert.Equal(t, body.Status, tt.wantStatus)
+			if body.Detail == "" {
+				t.Error("expected a detail message")
+			}
+			if tt.wantStatus == http.StatusTooManyRequests && (body.RetryAt == nil || w.Header().Get("Retry-After") == "") {
+				t.Error("expected retry_at and Retry-After for rate limits")
+			}
+			if tt.wantStatus == http.StatusInternalServerError && body.Detail == "boom" {
+				t.Error("internal error details must not be exposed")
+			}
+		})
+	}
+}
+
+func TestHandleListSecrets_RepositoryNotFound(t *testing.T) {
+	mockService := &mockRepositoryService{
+		HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+			return false, &repository.Error{Code: repository.CodeNotFound, Message: "Repository or secret not found"}
+		},
+	}
+	app := &application{
+		logger:       setupTestLogger(),
+		repositories: mockService,
+		config:       &config.Config{GithubOrgs: []string{"test-org"}},
+	}
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = store
+
+	req, _ := http.NewRequest("GET", "/api/repo/TargetOrg/missing/secrets", nil)
+	req.SetPathValue("owner", "TargetOrg")
+	req.SetPathValue("repo", "missing")
+	w := httptest.NewRecorder()
+	session, _ := store.Get(req, "session")
+	session.Values["user"] = goth.User{AccessToken: "valid-token"}
+	_ = session.Save(req, w)
+	req.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+
+	app.handleListSecrets(w, req)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	var body problem.Details
+	_ = json.NewDecoder(w.Body).Decode(&body)
+	assert.Equal(t, body.Code, "not_found")
+}
@@ -7,6 +7,8 @@ import (
 
 	"io/fs"
 
+	"github.com/RobinMaas95/gh-secret-broker/internal/openapi"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
 	"github.com/RobinMaas95/gh-secret-broker/ui"
 	"github.com/justinas/nosurf"
 )
@@ -15,19 +17,46 @@ func ping(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("OK")) //nolint:errcheck // health check endpoint
 }
 
+// healthz is the liveness probe: the process is up and serving requests.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("OK")) //nolint:errcheck // health check endpoint
+}
+
+// handleReadyz is the readiness probe. It reports 503 while GitHub or the
+// session store are unusable, e.g. because the PAT expired. The probe is
+// unauthenticated, so why a check failed is only logged.
+func (app *application) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := app.readiness.Check(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.Healthy() {
+		app.logger.WarnContext(r.Context(), "Readiness check failed", slog.Any("checks", report.Checks))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report.Public()); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode readiness report", slog.String("error", err.Error()))
+	}
+}
+
 func (app *application) handleCsrfToken(w http.ResponseWriter, r *http.Request) {
 	token := nosurf.Token(r)
 	w.Header().Set("Content-Type", "application/json")
-	// We can reuse the same struct or map, keep it simple for now
-	if err := json.NewEncoder(w).Encode(map[string]string{"token": token}); err != nil {
-		app.logger.Error("Failed to encode CSRF token", slog.String("error", err.Error()))
+	if err := json.NewEncoder(w).Encode(client.CSRFToken{Token: token}); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode CSRF token", slog.String("error", err.Error()))
 	}
 }
 
+// handleOpenAPI serves the OpenAPI document of the JSON API.
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(openapi.Spec)
+}
+
 func (app *application) handleSPA(w http.ResponseWriter, r *http.Request) {
 	distFS, err := fs.Sub(ui.Files, "build")
 	if err != nil {
-		app.logger.Error("Could not get static files", slog.String("error", err.Error()))
+		app.logger.ErrorContext(r.Context(), "Could not get static files", slog.String("error", err.Error()))
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
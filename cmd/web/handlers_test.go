@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/health"
 )
 
 func TestPing(t *testing.T) {
@@ -16,3 +22,48 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, res.status, http.StatusOK)
 	assert.Equal(t, res.body, "OK")
 }
+
+func TestHealthz(t *testing.T) {
+	ts := newTestServer(t, http.HandlerFunc(healthz))
+	defer ts.Close()
+
+	res := ts.get(t, "/healthz")
+	assert.Equal(t, res.status, http.StatusOK)
+}
+
+func TestReadyz(t *testing.T) {
+	healthy := health.Check{Name: "ok", Run: func(ctx context.Context) error { return nil }}
+	broken := health.Check{Name: "github_pat", Run: func(ctx context.Context) error { return errors.New("401 Bad credentials") }}
+
+	tests := []struct {
+		name       string
+		checks     []health.Check
+		wantStatus int
+	}{
+		{name: "Ready", checks: []health.Check{healthy}, wantStatus: http.StatusOK},
+		{name: "Not ready", checks: []health.Check{healthy, broken}, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &application{
+				logger:    setupTestLogger(),
+				readiness: health.New(time.Minute, tt.checks...),
+			}
+			ts := newTestServer(t, http.HandlerFunc(app.handleReadyz))
+			defer ts.Close()
+
+			res := ts.get(t, "/readyz")
+			assert.Equal(t, res.status, tt.wantStatus)
+
+			var report health.Report
+			if err := json.Unmarshal([]byte(res.body), &report); err != nil {
+				t.Fatalf("Failed to decode report: %v", err)
+			}
+			assert.Equal(t, len(report.Checks), len(tt.checks))
+			assert.Equal(t, report.Healthy(), tt.wantStatus == http.StatusOK)
+			// Why a check failed isn't published
+			assert.Equal(t, strings.Contains(res.body, "Bad credentials"), false)
+		})
+	}
+}
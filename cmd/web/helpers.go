@@ -2,9 +2,17 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
 
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/breakglass"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
 	"github.com/google/go-github/v80/github"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
@@ -14,47 +22,209 @@ import (
 // requireUser checks if the user is authenticated in the session.
 // If valid, it returns the user and true.
 // If invalid, it writes an Unauthorized error response and returns false.
+// Requests made with an API token are rejected; endpoints that accept them
+// use requirePrincipal.
 func (app *application) requireUser(w http.ResponseWriter, r *http.Request) (goth.User, bool) {
+	if _, ok := tokenFromContext(r.Context()); ok {
+		app.clientError(w, r, http.StatusForbidden, "This endpoint can't be used with an API token")
+		return goth.User{}, false
+	}
+
 	session, err := gothic.Store.Get(r, "session")
 	if err != nil {
-		app.logger.Error("Failed to get session", slog.String("error", err.Error()))
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		app.logger.ErrorContext(r.Context(), "Failed to get session", slog.String("error", err.Error()))
+		app.clientError(w, r, http.StatusUnauthorized, "Not logged in")
 		return goth.User{}, false
 	}
 
 	val, ok := session.Values["user"]
 	if !ok {
-		app.logger.Debug("No user in session")
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		app.logger.DebugContext(r.Context(), "No user in session")
+		app.clientError(w, r, http.StatusUnauthorized, "Not logged in")
 		return goth.User{}, false
 	}
 
 	user, ok := val.(goth.User)
 	if !ok {
-		app.logger.Error("User in session is not goth.User")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "User in session is not goth.User")
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal Server Error")
 		return goth.User{}, false
 	}
 
 	return user, true
 }
 
+// principal is who a request acts for: a user signed in with a session or,
+// for requests made with an API token, the user who created the token.
+type principal struct {
+	User goth.User
+	// Token is set for requests made with an API token. User then only
+	// carries the ID and login, there is no GitHub access token.
+	Token *apitoken.Token
+	// BreakGlass is set by checkAccess when access was granted only by a
+	// break-glass grant.
+	BreakGlass *breakglass.Grant
+}
+
+// linked reports whether p acts as a GitHub login without a GitHub token of
+// its own. That is the case for API tokens and for users who logged in with
+// OIDC; their access is looked up with the PAT.
+func (p principal) linked() bool {
+	return p.Token != nil || p.User.Provider == oauth.OIDCProviderName
+}
+
+// requirePrincipal is requireUser for endpoints that also accept API tokens.
+func (app *application) requirePrincipal(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	if tok, ok := tokenFromContext(r.Context()); ok {
+		return principal{
+			User:  goth.User{UserID: tok.UserID, NickName: tok.Login, Provider: "github"},
+			Token: &tok,
+		}, true
+	}
+
+	user, ok := app.requireUser(w, r)
+	return principal{User: user}, ok
+}
+
+// checkAccess reports whether p may perform action on owner/repo. A denial
+// comes with the reason shown to the client.
+//
+// Session users must maintain the repository, which is checked with their
+// own GitHub token. Requests with an API token must be within its scope, and
+// the token's user must still maintain the repository; as there is no user
+// token, that is looked up with the PAT. The same goes for users who logged
+// in with OIDC, for the GitHub account they are linked to.
+//
+// Session users who don't maintain the repository may still read and write
+// its secrets with an active break-glass grant; p.BreakGlass is set then.
+func (app *application) checkAccess(ctx context.Context, p *principal, owner, repo string, action apitoken.Action) (denied string, err error) {
+	ctx = repository.WithUser(ctx, p.User.UserID)
+
+	if p.Token != nil && !p.Token.Allows(owner, repo, action) {
+		return fmt.Sprintf("The API token does not allow %s on %s/%s", action, owner, repo), nil
+	}
+
+	var hasAccess bool
+	if p.linked() {
+		hasAccess, err = app.repositories.UserHasMaintainerAccess(ctx, app.pat(), owner, repo, p.User.NickName)
+	} else {
+		// We do not work on userGhClient directly, but instead pass it to the repository service.
+		// In tests, we can mock the repository service and inject a mock client or just
+		// return a fixed value.
+		var userGhClient *github.Client
+		userGhClient, err = app.getGitHubClient(ctx, p.User.AccessToken)
+		if err != nil {
+			return "", fmt.Errorf("failed to create GitHub client: %w", err)
+		}
+		hasAccess, err = app.repositories.HasMaintainerAccess(ctx, userGhClient, owner, repo)
+	}
+	if err != nil {
+		return "", err
+	}
+	if !hasAccess {
+		if grant, ok := app.breakGlassGrant(p, owner, repo, action); ok {
+			p.BreakGlass = &grant
+			return "", nil
+		}
+		return "You need maintain or admin access to this repository", nil
+	}
+	return "", nil
+}
+
+// breakGlassGrant returns the active break-glass grant that lets p perform
+// action on owner/repo. Grants are for session users and cover reading and
+// writing secrets, not deleting them.
+func (app *application) breakGlassGrant(p *principal, owner, repo string, action apitoken.Action) (breakglass.Grant, bool) {
+	if app.breakGlass == nil || !app.cfg().BreakGlass || p.Token != nil || action == apitoken.ActionDelete {
+		return breakglass.Grant{}, false
+	}
+	return app.breakGlass.Active(p.User.UserID, owner, repo)
+}
+
+// linkGitHub returns the ID of the GitHub user login. Users who log in with
+// OIDC are linked to that account; organizations can't be linked.
+func (app *application) linkGitHub(ctx context.Context, login string) (string, error) {
+	user, _, err := app.pat().Users.Get(ctx, login)
+	if err != nil {
+		return "", fmt.Errorf("looking up GitHub user %s: %w", login, err)
+	}
+	if user.GetType() != "User" {
+		return "", fmt.Errorf("%s is not a GitHub user", login)
+	}
+	return fmt.Sprint(user.GetID()), nil
+}
+
+// actorName identifies the user in logs and audit events. The GitHub login is
+// preferred because, unlike the email, it is always set.
+func actorName(user goth.User) string {
+	if user.NickName != "" {
+		return user.NickName
+	}
+	if user.Email != "" {
+		return user.Email
+	}
+	return user.UserID
+}
+
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Error("server error", slog.String("error", err.Error()), slog.String("method", r.Method), slog.String("uri", r.URL.RequestURI()))
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	app.logger.ErrorContext(r.Context(), "server error", slog.String("error", err.Error()), slog.String("method", r.Method), slog.String("uri", r.URL.RequestURI()))
+	problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, http.StatusText(http.StatusInternalServerError))
+}
+
+// cfg returns the current configuration. It may be replaced on reload, so
+// handlers should call it once and keep using the returned value.
+func (app *application) cfg() *config.Config {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return app.config
+}
+
+// pat returns the current PAT client. It may be replaced on reload; requests
+// that already obtained a client keep using it until they finish.
+func (app *application) pat() *github.Client {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+	return app.patClient
 }
 
 func (app *application) getGitHubClient(ctx context.Context, token string) (*github.Client, error) {
+	transport := app.userTransport
+	if transport == nil {
+		transport = app.metrics.Transport(nil, "user")
+	}
+	return newGitHubClient(ctx, token, app.cfg().GithubEnterpriseURL, transport)
+}
+
+// newGitHubClient creates a GitHub client authenticating with token.
+// If enterpriseURL is set, the client talks to that GitHub Enterprise instance.
+// transport is used for the underlying HTTP calls.
+func newGitHubClient(ctx context.Context, token, enterpriseURL string, transport http.RoundTripper) (*github.Client, error) {
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: token},
 	)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: transport})
 	tc := oauth2.NewClient(ctx, ts)
 
-	if app.config.GithubEnterpriseURL != "" {
-		baseURL := app.config.GithubEnterpriseURL + "/api/v3/"
-		uploadURL := app.config.GithubEnterpriseURL + "/api/v3/upload/"
+	if enterpriseURL != "" {
+		baseURL := enterpriseURL + "/api/v3/"
+		uploadURL := enterpriseURL + "/api/v3/upload/"
 		client := github.NewClient(tc)
 		return client.WithEnterpriseURLs(baseURL, uploadURL)
 	}
 	return github.NewClient(tc), nil
 }
+
+// parallel calls fn for 0..n-1 with at most limit calls at a time.
+func parallel(limit, n int, fn func(i int)) {
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/notify"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/markbates/goth"
+)
+
+// incidentConcurrency bounds the GitHub requests an incident makes at once,
+// so a large organization doesn't trip GitHub's secondary rate limits.
+const incidentConcurrency = 5
+
+// incidentTimeout bounds how long an incident runs in the background. It is
+// far beyond the server's write timeout, which is why incidents don't run
+// within their request.
+const incidentTimeout = 15 * time.Minute
+
+// incidentRetention is how long a finished incident can still be polled.
+const incidentRetention = time.Hour
+
+// Incident actions.
+const (
+	incidentDelete  = "delete"
+	incidentReplace = "replace"
+)
+
+// Statuses of an incident.
+const (
+	incidentRunning = "running"
+	incidentDone    = "done"
+)
+
+// Outcomes of an incident per repository. Skipped repositories no longer
+// held the secret when the incident ran.
+const (
+	incidentSuccess = "success"
+	incidentFailure = "failure"
+	incidentSkipped = "skipped"
+)
+
+// secretHolder is a repository that holds the secret an incident is about.
+type secretHolder struct {
+	Repository string
+	Secret     *github.Secret
+}
+
+// incidentJobs keeps incidents while they run and for incidentRetention
+// after, so clients can poll them. The zero value is ready to use.
+type incidentJobs struct {
+	mu   sync.Mutex
+	jobs map[string]client.Incident
+}
+
+// put adds or replaces incident. Incidents are stored by value and never
+// changed once put, so get can hand them out without copying their slices.
+func (j *incidentJobs) put(incident client.Incident) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.jobs == nil {
+		j.jobs = map[string]client.Incident{}
+	}
+	now := time.Now()
+	for id, inc := range j.jobs {
+		if inc.FinishedAt != nil && now.Sub(*inc.FinishedAt) > incidentRetention {
+			delete(j.jobs, id)
+		}
+	}
+	j.jobs[incident.ID] = incident
+}
+
+func (j *incidentJobs) get(id string) (client.Incident, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	incident, ok := j.jobs[id]
+	return incident, ok
+}
+
+// findSecret lists every repository of orgs that holds a secret called name,
+// using the PAT. The holders are sorted by name. Organizations and
+// repositories that couldn't be checked are returned as failures rather than
+// ending the search, so one broken repository doesn't hide the others.
+func (app *application) findSecret(ctx context.Context, orgs []string, name string) ([]secretHolder, []client.IncidentResult) {
+	var repos []*github.Repository
+	var failures []client.IncidentResult
+	for _, org := range orgs {
+		orgRepos, err := app.repositories.ListOrgRepositories(ctx, app.pat(), org)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "Failed to list repositories", slog.String("error", err.Error()), slog.String("org", org))
+			failures = append(failures, client.IncidentResult{Repository: org, Outcome: incidentFailure, Error: errorMessage(err)})
+			continue
+		}
+		repos = append(repos, orgRepos...)
+	}
+
+	secrets := make([]*github.Secret, len(repos))
+	errs := make([]error, len(repos))
+	parallel(incidentConcurrency, len(repos), func(i int) {
+		list, err := app.repositories.ListSecretMetadata(ctx, app.pat(), repos[i].GetOwner().GetLogin(), repos[i].GetName())
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		for _, s := range list {
+			if strings.EqualFold(s.Name, name) {
+				secrets[i] = s
+			}
+		}
+	})
+
+	var holders []secretHolder
+	for i, repo := range repos {
+		if errs[i] != nil {
+			app.logger.ErrorContext(ctx, "Failed to list secrets", slog.String("error", errs[i].Error()), slog.String("repo", repo.GetFullName()))
+			failures = append(failures, client.IncidentResult{Repository: repo.GetFullName(), Outcome: incidentFailure, Error: errorMessage(errs[i])})
+		}
+		if secrets[i] != nil {
+			holders = append(holders, secretHolder{Repository: repo.GetFullName(), Secret: secrets[i]})
+		}
+	}
+	slices.SortFunc(holders, func(a, b secretHolder) int { return strings.Compare(a.Repository, b.Repository) })
+	return holders, failures
+}
+
+// errorMessage returns what a client may see of err: the message of a
+// repository error, or nothing more than that something went wrong.
+func errorMessage(err error) string {
+	var repoErr *repository.Error
+	if errors.As(err, &repoErr) {
+		return repoErr.Message
+	}
+	return "Internal Server Error"
+}
+
+// incidentOrgs returns the organizations an incident covers: org, or all
+// configured ones if it is empty.
+func (app *application) incidentOrgs(org string) ([]string, bool) {
+	if org == "" {
+		return app.cfg().GithubOrgs, true
+	}
+	org, ok := app.cfg().LookupOrg(org)
+	return []string{org}, ok
+}
+
+// validateIncident checks the parts of an incident request that preview and
+// run have in common. It returns the reason for rejecting the request.
+func (app *application) validateIncident(req client.IncidentRequest) (orgs []string, rejected string) {
+	if err := repository.ValidateSecretName(req.SecretName); err != nil {
+		return nil, err.Error()
+	}
+	if req.Action != incidentDelete && req.Action != incidentReplace {
+		return nil, fmt.Sprintf("Action must be %q or %q", incidentDelete, incidentReplace)
+	}
+	orgs, ok := app.incidentOrgs(req.Org)
+	if !ok {
+		return nil, fmt.Sprintf("Unknown organization %q", req.Org)
+	}
+	return orgs, ""
+}
+
+// startIncident runs fn in the background and answers with the incident for
+// the client to poll. fn gets a context detached from the request, so the
+// incident completes even if the client goes away, but bounded by
+// incidentTimeout.
+func (app *application) startIncident(w http.ResponseWriter, r *http.Request, incident client.Incident, fn func(ctx context.Context, incident *client.Incident)) {
+	incident.ID = newRequestID()
+	incident.Status = incidentRunning
+	incident.StartedAt = time.Now()
+	incident.Repositories = []client.IncidentRepository{}
+	incident.Results = []client.IncidentResult{}
+	app.incidents.put(incident)
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), incidentTimeout)
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		defer cancel()
+		done := incident
+		fn(ctx, &done)
+		finished := time.Now()
+		done.Status, done.FinishedAt = incidentDone, &finished
+		app.incidents.put(done)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/admin/incidents/"+incident.ID)
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(incident); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode incident", slog.String("error", err.Error()))
+	}
+}
+
+// handlePreviewIncident handles POST /api/admin/incidents/preview. It starts
+// looking up the repositories an incident would change, without changing
+// anything.
+func (app *application) handlePreviewIncident(w http.ResponseWriter, r *http.Request) {
+	var req client.IncidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	orgs, rejected := app.validateIncident(req)
+	if rejected != "" {
+		app.clientError(w, r, http.StatusUnprocessableEntity, rejected)
+		return
+	}
+
+	incident := client.Incident{SecretName: req.SecretName, Action: req.Action, Preview: true}
+	app.startIncident(w, r, incident, func(ctx context.Context, incident *client.Incident) {
+		holders, failures := app.findSecret(ctx, orgs, req.SecretName)
+		for _, h := range holders {
+			incident.Repositories = append(incident.Repositories, client.IncidentRepository{Repository: h.Repository, UpdatedAt: h.Secret.UpdatedAt.Time})
+		}
+		incident.Results = append(incident.Results, failures...)
+	})
+}
+
+// handleRunIncident handles POST /api/admin/incidents. It starts deleting or
+// replacing the secret in the repositories confirmed from the preview. They
+// are looked up again, so repositories that no longer hold the secret are
+// skipped rather than getting a new one, and those that can't be checked
+// fail.
+//
+// Every change is audited as usual, with the incident's ID in its detail;
+// the incident itself gets one record listing the outcome per repository.
+func (app *application) handleRunIncident(w http.ResponseWriter, r *http.Request) {
+	grant, _ := roleFromContext(r.Context())
+
+	var req client.IncidentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	orgs, rejected := app.validateIncident(req)
+	if rejected == "" && len(req.Repositories) == 0 {
+		rejected = "Repositories must list the repositories confirmed from the preview"
+	}
+	if rejected == "" && req.Action == incidentReplace && req.Value == "" {
+		rejected = "Value is required to replace the secret"
+	}
+	if rejected != "" {
+		app.clientError(w, r, http.StatusUnprocessableEntity, rejected)
+		return
+	}
+	// Repository names are case-insensitive; each repository is changed once
+	var repos []string
+	for _, repo := range req.Repositories {
+		if repo = strings.ToLower(repo); !slices.Contains(repos, repo) {
+			repos = append(repos, repo)
+		}
+	}
+	req.Repositories = repos
+
+	incident := client.Incident{SecretName: req.SecretName, Action: req.Action}
+	app.startIncident(w, r, incident, func(ctx context.Context, incident *client.Incident) {
+		holders, failures := app.findSecret(ctx, orgs, req.SecretName)
+		for _, h := range holders {
+			incident.Repositories = append(incident.Repositories, client.IncidentRepository{Repository: h.Repository, UpdatedAt: h.Secret.UpdatedAt.Time})
+		}
+
+		results := make([]client.IncidentResult, len(req.Repositories))
+		parallel(incidentConcurrency, len(req.Repositories), func(i int) {
+			result := &results[i]
+			result.Repository = req.Repositories[i]
+			h := slices.IndexFunc(holders, func(h secretHolder) bool { return strings.EqualFold(h.Repository, result.Repository) })
+			if h < 0 {
+				result.Outcome = incidentSkipped
+				// A repository that couldn't be checked may still hold the secret
+				owner, _, _ := strings.Cut(result.Repository, "/")
+				f := slices.IndexFunc(failures, func(f client.IncidentResult) bool {
+					return strings.EqualFold(f.Repository, result.Repository) || strings.EqualFold(f.Repository, owner)
+				})
+				if f >= 0 {
+					result.Outcome, result.Error = incidentFailure, failures[f].Error
+				}
+				return
+			}
+			holder := holders[h]
+			owner, repo, _ := strings.Cut(holder.Repository, "/")
+
+			action := "secret.delete"
+			var err error
+			if req.Action == incidentDelete {
+				err = app.repositories.DeleteSecret(ctx, app.pat(), owner, repo, holder.Secret.Name)
+			} else {
+				action = "secret.create"
+				err = app.repositories.CreateOrUpdateSecret(ctx, app.pat(), owner, repo, holder.Secret.Name, req.Value)
+			}
+
+			result.Outcome = incidentSuccess
+			outcome := audit.OutcomeSuccess
+			if err != nil {
+				app.logger.ErrorContext(ctx, "Incident change failed", slog.String("error", err.Error()), slog.String("incident", incident.ID), slog.String("repo", holder.Repository))
+				result.Outcome, result.Error = incidentFailure, errorMessage(err)
+				outcome = audit.OutcomeFailure
+			} else if req.Action == incidentDelete {
+				app.metrics.SecretDeleted()
+			} else {
+				app.metrics.SecretCreated()
+			}
+			app.audit.Record(ctx, audit.Event{
+				Actor:      actorName(grant.User),
+				Action:     action,
+				Repository: holder.Repository,
+				Secret:     holder.Secret.Name,
+				Outcome:    outcome,
+				Detail:     "incident=" + incident.ID,
+			})
+		})
+		incident.Results = results
+
+		app.recordIncident(ctx, grant.User, *incident)
+	})
+}
+
+// handleGetIncident handles GET /api/admin/incidents/{id}. Clients poll it
+// until the incident is done.
+func (app *application) handleGetIncident(w http.ResponseWriter, r *http.Request) {
+	incident, ok := app.incidents.get(r.PathValue("id"))
+	if !ok {
+		app.clientError(w, r, http.StatusNotFound, "Incident not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(incident); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode incident", slog.String("error", err.Error()))
+	}
+}
+
+// recordIncident writes the incident's audit record, logs it and notifies
+// about it. The outcome is a failure if any repository failed.
+func (app *application) recordIncident(ctx context.Context, user goth.User, incident client.Incident) {
+	outcome := audit.OutcomeSuccess
+	counts := map[string]int{}
+	results := make([]string, len(incident.Results))
+	for i, res := range incident.Results {
+		counts[res.Outcome]++
+		results[i] = res.Repository + ":" + res.Outcome
+		if res.Outcome == incidentFailure {
+			outcome = audit.OutcomeFailure
+		}
+	}
+
+	app.audit.Record(ctx, audit.Event{
+		Actor:   actorName(user),
+		Action:  "incident." + incident.Action,
+		Secret:  incident.SecretName,
+		Outcome: outcome,
+		Detail:  fmt.Sprintf("incident=%s repositories=%s", incident.ID, strings.Join(results, ",")),
+	})
+	// Logged as a warning so it is visible at any level
+	app.logger.WarnContext(ctx, "Incident action ran", slog.String("user", actorName(user)), slog.String("incident", incident.ID), slog.String("action", incident.Action), slog.String("secret_name", incident.SecretName), slog.Int("succeeded", counts[incidentSuccess]), slog.Int("failed", counts[incidentFailure]), slog.Int("skipped", counts[incidentSkipped]))
+	app.notify(ctx, notify.Message{
+		Event:    "incident",
+		Priority: notify.PriorityHigh,
+		Text:     fmt.Sprintf("%s ran %s of secret %s: %d succeeded, %d failed, %d skipped", actorName(user), incident.Action, incident.SecretName, counts[incidentSuccess], counts[incidentFailure], counts[incidentSkipped]),
+		Fields: map[string]string{
+			"id":     incident.ID,
+			"user":   actorName(user),
+			"action": incident.Action,
+			"secret": incident.SecretName,
+		},
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/internal/roles"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+func TestIncidents(t *testing.T) {
+	var mu sync.Mutex
+	var changed []string
+	change := func(owner, repo, name string) error {
+		if repo == "broken" {
+			return &repository.Error{Code: repository.CodeUpstream, Message: "GitHub is down"}
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		changed = append(changed, owner+"/"+repo+"/"+name)
+		return nil
+	}
+
+	var auditBuf, logBuf bytes.Buffer
+	app := &application{
+		logger: slog.New(logging.NewRedactHandler(slog.NewTextHandler(&logBuf, nil))),
+		config: &config.Config{
+			GithubOrgs: []string{"org"},
+			AdminUsers: []string{"root"},
+		},
+		repositories: &mockRepositoryService{
+			ListOrgRepositoriesFunc: func(ctx context.Context, client *github.Client, org string) ([]*github.Repository, error) {
+				var repos []*github.Repository
+				for _, name := range []string{"api", "broken", "web", "docs", "locked"} {
+					repos = append(repos, &github.Repository{
+						Name:     github.Ptr(name),
+						FullName: github.Ptr(org + "/" + name),
+						Owner:    &github.User{Login: github.Ptr(org)},
+					})
+				}
+				return repos, nil
+			},
+			ListSecretMetadataFunc: func(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error) {
+				switch repo {
+				case "docs":
+					return []*github.Secret{{Name: "OTHER"}}, nil
+				case "locked":
+					return nil, &repository.Error{Code: repository.CodeForbidden, Message: "Access denied"}
+				}
+				return []*github.Secret{{Name: "OTHER"}, {Name: "LEAKED_KEY"}}, nil
+			},
+			DeleteSecretFunc: func(ctx context.Context, client *github.Client, owner, repo, name string) error {
+				return change(owner, repo, name)
+			},
+			CreateOrUpdateSecretFunc: func(ctx context.Context, client *github.Client, owner, repo, name, value string) error {
+				return change(owner, repo, name)
+			},
+		},
+		audit: audit.New(slog.NewJSONHandler(&auditBuf, nil)),
+	}
+
+	cookies := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = cookies
+	cookie := func(login string) string {
+		return sessionCookie(cookies, goth.User{UserID: login + "-id", NickName: login, AccessToken: "valid-token"})
+	}
+	post := func(h http.HandlerFunc, login string, req client.IncidentRequest) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest("POST", "/api/admin/incidents", bytes.NewReader(body))
+		r.Header.Set("Cookie", cookie(login))
+		w := httptest.NewRecorder()
+		app.requireRole(roles.Admin)(h).ServeHTTP(w, r)
+		return w
+	}
+	// poll waits for the incident started by w and returns it as the client
+	// sees it.
+	poll := func(t *testing.T, w *httptest.ResponseRecorder) client.Incident {
+		t.Helper()
+		assert.Equal(t, w.Code, http.StatusAccepted)
+		var started client.Incident
+		_ = json.NewDecoder(w.Body).Decode(&started)
+		assert.Equal(t, started.Status, "running")
+		assert.Equal(t, w.Header().Get("Location"), "/api/admin/incidents/"+started.ID)
+		app.background.Wait()
+
+		r := httptest.NewRequest("GET", "/api/admin/incidents/"+started.ID, nil)
+		r.SetPathValue("id", started.ID)
+		r.Header.Set("Cookie", cookie("root"))
+		res := httptest.NewRecorder()
+		app.requireRole(roles.Admin)(http.HandlerFunc(app.handleGetIncident)).ServeHTTP(res, r)
+		assert.Equal(t, res.Code, http.StatusOK)
+		var incident client.Incident
+		_ = json.NewDecoder(res.Body).Decode(&incident)
+		assert.Equal(t, incident.Status, "done")
+		return incident
+	}
+
+	t.Run("Preview", func(t *testing.T) {
+		preview := poll(t, post(app.handlePreviewIncident, "root", client.IncidentRequest{SecretName: "leaked_key", Action: "delete"}))
+		assert.Equal(t, preview.Preview, true)
+		assert.Equal(t, len(preview.Repositories), 3)
+		assert.Equal(t, preview.Repositories[0].Repository, "org/api")
+		assert.Equal(t, preview.Repositories[2].Repository, "org/web")
+		// A repository that couldn't be checked doesn't end the search
+		assert.Equal(t, len(preview.Results), 1)
+		assert.Equal(t, preview.Results[0].Repository, "org/locked")
+		assert.Equal(t, preview.Results[0].Error, "Access denied")
+		assert.Equal(t, len(changed), 0)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		incident := poll(t, post(app.handleRunIncident, "root", client.IncidentRequest{
+			SecretName:   "LEAKED_KEY",
+			Action:       "delete",
+			Repositories: []string{"org/api", "org/broken", "Org/API", "org/docs", "org/locked", "org/api"},
+		}))
+		outcomes := make([]string, len(incident.Results))
+		for i, res := range incident.Results {
+			outcomes[i] = res.Repository + ":" + res.Outcome
+		}
+		assert.Equal(t, strings.Join(outcomes, ","), "org/api:success,org/broken:failure,org/docs:skipped,org/locked:failure")
+		assert.Equal(t, incident.Results[1].Error, "GitHub is down")
+		assert.Equal(t, incident.Results[3].Error, "Access denied")
+		// Only confirmed repositories are changed, each once
+		assert.Equal(t, slices.Equal(changed, []string{"org/api/LEAKED_KEY"}), true)
+
+		log := auditBuf.String()
+		assert.Equal(t, strings.Count(log, "incident="+incident.ID), 3)
+		assert.Equal(t, strings.Contains(log, `"action":"incident.delete"`), true)
+		assert.Equal(t, strings.Contains(log, "repositories=org/api:success,org/broken:failure,org/docs:skipped,org/locked:failure"), true)
+		// The leaked secret's name must be visible in the logs
+		assert.Equal(t, strings.Contains(logBuf.String(), "secret_name=LEAKED_KEY"), true)
+	})
+
+	t.Run("Unknown incident", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/admin/incidents/nope", nil)
+		r.SetPathValue("id", "nope")
+		w := httptest.NewRecorder()
+		app.handleGetIncident(w, r)
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		tests := []struct {
+			name string
+			req  client.IncidentRequest
+		}{
+			{name: "Invalid name", req: client.IncidentRequest{SecretName: "GITHUB_TOKEN", Action: "delete", Repositories: []string{"org/api"}}},
+			{name: "Unknown action", req: client.IncidentRequest{SecretName: "LEAKED_KEY", Action: "rotate", Repositories: []string{"org/api"}}},
+			{name: "Unknown org", req: client.IncidentRequest{SecretName: "LEAKED_KEY", Action: "delete", Org: "other-org", Repositories: []string{"org/api"}}},
+			{name: "Nothing confirmed", req: client.IncidentRequest{SecretName: "LEAKED_KEY", Action: "delete"}},
+			{name: "Replace without value", req: client.IncidentRequest{SecretName: "LEAKED_KEY", Action: "replace", Repositories: []string{"org/api"}}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Equal(t, post(app.handleRunIncident, "root", tt.req).Code, http.StatusUnprocessableEntity)
+			})
+		}
+	})
+
+	t.Run("Admins only", func(t *testing.T) {
+		w := post(app.handleRunIncident, "someone", client.IncidentRequest{SecretName: "LEAKED_KEY", Action: "replace", Value: "new", Repositories: []string{"org/web"}})
+		assert.Equal(t, w.Code, http.StatusForbidden)
+		assert.Equal(t, len(changed), 1)
+	})
+}
@@ -11,53 +11,100 @@ import (
 	"os"
 	"os/signal"
 	"slices"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/breakglass"
 	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/health"
+	"github.com/RobinMaas95/gh-secret-broker/internal/httpcache"
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+	"github.com/RobinMaas95/gh-secret-broker/internal/metrics"
 	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
 	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/internal/tlsutil"
+	"github.com/RobinMaas95/gh-secret-broker/internal/tracing"
 	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
-	"golang.org/x/oauth2"
+	"github.com/markbates/goth/gothic"
 )
 
 type application struct {
 	logger       *slog.Logger
+	logLevel     *slog.LevelVar
 	debugMode    bool
 	repositories repository.RepositoryService
-	config       *config.Config
-	patClient    *github.Client
+	metrics      *metrics.Metrics
+	audit        *audit.Logger
+	readiness    *health.Checker
+	tokens       *apitoken.Store
+	deviceFlow   *oauth.DeviceFlow
+	devices      deviceLogins
+	// deviceLimiter limits the device logins started per client IP
+	deviceLimiter rateLimiter
+	// auditLimiter limits the /api/admin audit requests per user
+	auditLimiter rateLimiter
+	breakGlass   *breakglass.Store
+	incidents    incidentJobs
+	// background tracks work that outlives its request, such as
+	// notifications, so shutdown can wait for it.
+	background sync.WaitGroup
+	// GitHub clients are created per request (user) or on reload (PAT);
+	// their transports are shared so the ETag cache survives.
+	patTransport  http.RoundTripper
+	userTransport http.RoundTripper
+
+	// mu guards config and patClient, which are swapped on SIGHUP.
+	// Use app.cfg() and app.pat() to read them.
+	mu        sync.RWMutex
+	config    *config.Config
+	patClient *github.Client
 }
 
-func setupLogger(logFormat string) slog.Handler {
+const (
+	// readinessCacheTTL is how long a /readyz result is reused.
+	readinessCacheTTL = 30 * time.Second
+	// selfCheckTimeout bounds the PAT self-check at startup.
+	selfCheckTimeout = 15 * time.Second
+	// deviceFlowTimeout bounds each call to GitHub's device flow endpoints.
+	deviceFlowTimeout = 5 * time.Second
+)
+
+func setupLogger(logFormat string, level slog.Leveler) slog.Handler {
 	opts := &slog.HandlerOptions{
-		Level: slog.LevelDebug,
+		Level: level,
 	}
+	var h slog.Handler
 	switch logFormat {
 	case "json":
-		return slog.NewJSONHandler(os.Stdout, opts)
+		h = slog.NewJSONHandler(os.Stdout, opts)
 	case "text":
 		opts.AddSource = true
-		return slog.NewTextHandler(os.Stdout, opts)
+		h = slog.NewTextHandler(os.Stdout, opts)
 	default:
 		// Should never be reached because we validate the user input
 		opts.AddSource = true
-		return slog.NewTextHandler(os.Stdout, opts)
+		h = slog.NewTextHandler(os.Stdout, opts)
 	}
+	return logging.NewRedactHandler(h)
 }
 
 func main() {
 	_ = godotenv.Load() // Load .env file if it exists
 
-	logFormat := "text"
-	cfg, err := config.Load()
-	if err != nil {
-		fmt.Println(err)
-		fmt.Println("Could not load config")
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:], os.Stdout))
 	}
-	flag.StringVar(&cfg.Addr, "addr", ":4000", "HTTP network address")
+
+	// Flags have the highest precedence, so they are parsed first but only
+	// applied after the config file and the environment have been loaded.
+	var addr, logFormat string
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "Path to a YAML config file")
+	flag.StringVar(&addr, "addr", ":4000", "HTTP network address")
 	flag.Func("log-format", "Output format of the logs", func(flagValue string) error {
 		if slices.Contains([]string{"text", "json"}, flagValue) {
 			logFormat = flagValue
@@ -66,57 +113,147 @@ func main() {
 		return errors.New(`must be one of "text" or "json"`)
 	})
 	flag.Parse() // Need to be called, so that the flag values are filled with passed values and not defaults
-	fmt.Printf("The log format is: %s\n", logFormat)
 
-	if cfg.BaseURL == "" {
-		_, port, err := net.SplitHostPort(cfg.Addr)
-		if err != nil {
-			// If SplitHostPort fails (e.g. ":4000"), try to see if it's just a port or fallback
-			if len(cfg.Addr) > 0 && cfg.Addr[0] == ':' {
-				port = cfg.Addr[1:]
-			} else {
-				// Fallback: assume the whole thing is a port if it parses as int, otherwise default to 4000
-				port = "4000" // Safe default
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("Could not load config")
+		os.Exit(1)
+	}
+	// applyFlags applies the flags that were set on the command line and the
+	// values derived from them. It is also used when reloading the config.
+	applyFlags := func(cfg *config.Config) {
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "addr":
+				cfg.Addr = addr
+			case "log-format":
+				cfg.LogFormat = logFormat
 			}
+		})
+		if cfg.BaseURL == "" {
+			cfg.BaseURL = defaultBaseURL(cfg.Addr, cfg.TLSEnabled())
 		}
-		cfg.BaseURL = "http://localhost:" + port
 	}
+	applyFlags(cfg)
+	fmt.Printf("The log format is: %s\n", cfg.LogFormat)
 
-	logger := slog.New(setupLogger(logFormat))
+	// The level can be changed at runtime via the admin listener or a reload.
+	// Log lines of a request carry its request ID via the context.
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.Level())
+	logger := slog.New(logging.NewContextHandler(setupLogger(cfg.LogFormat, logLevel)))
 	slog.SetDefault(logger)
 
+	// Audit events go to their own file if configured, otherwise they are
+	// part of the application log. They are never filtered by the log level.
+	auditHandler := setupLogger(cfg.LogFormat, slog.LevelInfo)
+	if cfg.AuditLogFile != "" {
+		f, err := os.OpenFile(cfg.AuditLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			logger.Error("Failed to open audit log file", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer func() { _ = f.Close() }()
+		auditHandler = logging.NewRedactHandler(slog.NewJSONHandler(f, nil))
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.OTLPEndpoint)
+	if err != nil {
+		logger.Error("Failed to set up tracing", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
 	// Initialize PAT Client
 	// The pat client is used to access the GitHub API
 	// on behalf of the application because the user's token is
 	// not powerful enough to access secrets.
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: cfg.GithubPAT},
-	)
-	tc := oauth2.NewClient(context.Background(), ts)
-	var patClient *github.Client
-
-	if cfg.GithubEnterpriseURL != "" {
-		baseURL := cfg.GithubEnterpriseURL + "/api/v3/"
-		uploadURL := cfg.GithubEnterpriseURL + "/api/v3/upload/"
-		var err error
-		patClient, err = github.NewClient(tc).WithEnterpriseURLs(baseURL, uploadURL)
-		if err != nil {
-			logger.Error("Failed to create enterprise client", slog.String("error", err.Error()))
+	//
+	// GET responses are revalidated with ETags; GitHub answers unchanged
+	// resources with a 304 that doesn't count against the rate limit.
+	appMetrics := metrics.New()
+	patTransport := httpcache.NewTransport(appMetrics.Transport(nil, "pat"), httpcache.DefaultMaxEntries)
+	userTransport := httpcache.NewTransport(appMetrics.Transport(nil, "user"), httpcache.DefaultMaxEntries)
+	patClient, err := newGitHubClient(context.Background(), cfg.GithubPAT, cfg.GithubEnterpriseURL, patTransport)
+	if err != nil {
+		logger.Error("Failed to create enterprise client", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+
+	// Catch a wrong or underpowered PAT now rather than at the first secret
+	// write. Development setups may run without GitHub access, so only
+	// production refuses to start.
+	selfCheckCtx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	report := health.SelfCheck(selfCheckCtx, patClient, cfg.GithubOrgs)
+	cancel()
+	report.Write(os.Stdout)
+	if !report.OK() {
+		if cfg.IsProduction() {
+			logger.Error("PAT self-check failed, refusing to start")
 			os.Exit(1)
 		}
-	} else {
-		patClient = github.NewClient(tc)
+		logger.Warn("PAT self-check failed, secret operations will not work")
+	}
+
+	var repositories repository.RepositoryService = repository.NewService()
+	if cfg.CacheTTL > 0 {
+		repositories = repository.NewCachedService(repositories, cfg.CacheTTL)
+	}
+
+	// Without a file, tokens work until the next restart
+	tokens, err := apitoken.NewStore(cfg.APITokenFile)
+	if err != nil {
+		logger.Error("Failed to load API tokens", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	if cfg.APITokenFile == "" {
+		logger.Warn("API_TOKEN_FILE is not set, API tokens are lost on restart")
+	}
+
+	breakGlass, err := breakglass.NewStore(cfg.BreakGlassFile)
+	if err != nil {
+		logger.Error("Failed to load break-glass grants", slog.String("error", err.Error()))
+		os.Exit(1)
 	}
+	if cfg.BreakGlass && cfg.BreakGlassFile == "" {
+		logger.Warn("BREAK_GLASS_FILE is not set, unreviewed break-glass grants are lost on restart")
+	}
+	if cfg.BreakGlass && cfg.NotifyWebhookURL == "" {
+		logger.Warn("NOTIFY_WEBHOOK_URL is not set, break-glass access is only logged and audited")
+	}
+
+	deviceFlow := oauth.NewDeviceFlow(cfg.GithubClientID, cfg.GithubEnterpriseURL)
+	deviceFlow.HTTPClient = &http.Client{Transport: appMetrics.Transport(nil, "device"), Timeout: deviceFlowTimeout}
 
 	app := &application{
-		logger:       logger,
-		debugMode:    false,
-		repositories: repository.NewService(),
-		config:       cfg,
-		patClient:    patClient,
+		logger:        logger,
+		logLevel:      logLevel,
+		debugMode:     false,
+		repositories:  repositories,
+		metrics:       appMetrics,
+		audit:         audit.New(auditHandler),
+		tokens:        tokens,
+		deviceFlow:    deviceFlow,
+		breakGlass:    breakGlass,
+		config:        cfg,
+		patClient:     patClient,
+		patTransport:  patTransport,
+		userTransport: userTransport,
 	}
 
-	oauthService := oauth.NewService(logger, cfg)
+	oauthService, err := oauth.NewService(logger, cfg)
+	if err != nil {
+		logger.Error("Failed to set up login providers", slog.String("error", err.Error()))
+		os.Exit(1)
+	}
+	oauthService.LinkGitHub = app.linkGitHub
+
+	// Readiness results are cached so frequent probes don't use up the PAT's
+	// rate limit.
+	app.readiness = health.New(readinessCacheTTL,
+		health.GitHubPAT(app.pat),
+		health.SessionStore(func() sessions.Store { return gothic.Store }),
+	)
 
 	srv := &http.Server{
 		Addr:         cfg.Addr,
@@ -127,6 +264,60 @@ func main() {
 		WriteTimeout: 10 * time.Second,
 	}
 
+	if cfg.TLSEnabled() {
+		// The certificate is reloaded when the files change, so rotating it
+		// does not require a restart.
+		reloader, err := tlsutil.NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, logger)
+		if err != nil {
+			logger.Error("Failed to load TLS certificate", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		srv.TLSConfig, err = tlsutil.ServerConfig(reloader, cfg.TLSClientCAFile, cfg.TLSClientAuth)
+		if err != nil {
+			logger.Error("Failed to configure TLS", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
+	// The admin listener serves operational endpoints such as /metrics.
+	// It is separate from the public listener so it can be kept internal.
+	var adminSrv *http.Server
+	if cfg.AdminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:         cfg.AdminAddr,
+			Handler:      app.adminRoutes(),
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		go func() {
+			logger.Info("Starting admin server", slog.String("addr", adminSrv.Addr))
+			if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Admin server error", slog.String("error", err.Error()))
+			}
+		}()
+	}
+
+	// Configuration reload on SIGHUP
+	go func() {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		for range sighup {
+			logger.Info("Reloading configuration")
+			err := app.reloadConfig(func() (*config.Config, error) {
+				cfg, err := config.Load(*configPath)
+				if err == nil {
+					applyFlags(cfg)
+				}
+				return cfg, err
+			})
+			if err != nil {
+				logger.Error("Configuration reload failed, keeping current configuration", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
 	// Graceful shutdown setup
 	shutdownComplete := make(chan struct{})
 	go func() {
@@ -143,11 +334,27 @@ func main() {
 		if err := srv.Shutdown(ctx); err != nil {
 			logger.Error("Server shutdown error", slog.String("error", err.Error()))
 		}
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(ctx); err != nil {
+				logger.Error("Admin server shutdown error", slog.String("error", err.Error()))
+			}
+		}
+		app.waitBackground(ctx)
+		// Flush spans of the requests that just finished
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("Tracing shutdown error", slog.String("error", err.Error()))
+		}
 		close(shutdownComplete)
 	}()
 
-	logger.Info("Starting server", slog.String("addr", srv.Addr))
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	logger.Info("Starting server", slog.String("addr", srv.Addr), slog.Bool("tls", cfg.TLSEnabled()))
+	if cfg.TLSEnabled() {
+		// Certificates come from srv.TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Error("Server error", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
@@ -155,3 +362,37 @@ func main() {
 	<-shutdownComplete
 	logger.Info("Server stopped gracefully")
 }
+
+// waitBackground waits for the application's background work until ctx is
+// done.
+func (app *application) waitBackground(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		app.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		app.logger.Warn("Background work did not finish before shutdown")
+	}
+}
+
+// defaultBaseURL derives the public base URL from the listen address
+// when BASE_URL is not configured.
+func defaultBaseURL(addr string, tlsEnabled bool) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// If SplitHostPort fails (e.g. ":4000"), try to see if it's just a port or fallback
+		if len(addr) > 0 && addr[0] == ':' {
+			port = addr[1:]
+		} else {
+			// Fallback: assume the whole thing is a port if it parses as int, otherwise default to 4000
+			port = "4000" // Safe default
+		}
+	}
+	if tlsEnabled {
+		return "https://localhost:" + port
+	}
+	return "http://localhost:" + port
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/manifest"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+)
+
+// handleRequiredSecrets handles GET /api/repo/{owner}/{repo}/required-secrets.
+// It lists the secrets the repository is required to have and which of them
+// are missing.
+//
+// A template assigned in the config takes precedence over the repository's
+// manifest file, so admins can override what a repository declares. A broken
+// manifest is reported in the response rather than failing the request, so
+// the UI can show what to fix.
+func (app *application) handleRequiredSecrets(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+	owner := r.PathValue("owner")
+	repo := r.PathValue("repo")
+
+	denied, err := app.checkAccess(r.Context(), &p, owner, repo, apitoken.ActionRead)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to check permissions", slog.String("error", err.Error()))
+		app.errorResponse(w, r, err)
+		return
+	}
+	if denied != "" {
+		app.logger.WarnContext(r.Context(), "User attempted to access secrets without permission", slog.String("user", actorName(p.User)), slog.String("repo", owner+"/"+repo))
+		app.clientError(w, r, http.StatusForbidden, denied)
+		return
+	}
+
+	cfg := app.cfg()
+	res := client.RequiredSecrets{Required: []string{}, Missing: []string{}}
+	var m manifest.Manifest
+	if template, ok := cfg.RepositoryTemplate(owner, repo); ok {
+		m, res.Source = manifest.Manifest{Template: template}, "config"
+	} else if cfg.SecretManifestPath != "" {
+		data, err := app.repositories.GetFileContent(r.Context(), app.pat(), owner, repo, cfg.SecretManifestPath)
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			// The repository doesn't declare any secrets
+		case err != nil:
+			app.logger.ErrorContext(r.Context(), "Failed to read secret manifest", slog.String("error", err.Error()), slog.String("repo", owner+"/"+repo))
+			app.errorResponse(w, r, err)
+			return
+		default:
+			res.Source, res.Path = "file", cfg.SecretManifestPath
+			if m, err = manifest.Parse(data); err != nil {
+				res.Problem = err.Error()
+			}
+		}
+	}
+	res.Template = m.Template
+
+	required, err := m.Required(cfg.SecretTemplates)
+	if err != nil {
+		res.Problem = err.Error()
+	}
+	if len(required) > 0 {
+		secrets, err := app.repositories.ListSecrets(r.Context(), app.pat(), owner, repo)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "Failed to list secrets", slog.String("error", err.Error()))
+			app.errorResponse(w, r, err)
+			return
+		}
+		res.Required, res.Missing = required, manifest.Missing(required, secrets)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode required secrets", slog.String("error", err.Error()))
+	}
+}
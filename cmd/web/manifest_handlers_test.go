@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+func TestHandleRequiredSecrets(t *testing.T) {
+	manifests := map[string]string{
+		"api":    "template: deployable\nsecrets: [STRIPE_KEY]\n",
+		"broken": "template: library\n",
+	}
+	app := &application{
+		logger: setupTestLogger(),
+		config: &config.Config{
+			GithubOrgs:          []string{"org"},
+			SecretTemplates:     map[string][]string{"deployable": {"DEPLOY_KEY", "SENTRY_DSN", "REGISTRY_TOKEN"}},
+			RepositoryTemplates: map[string]string{"org/web": "deployable"},
+			SecretManifestPath:  ".github/secret-broker.yml",
+		},
+		repositories: &mockRepositoryService{
+			HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+				return true, nil
+			},
+			GetFileContentFunc: func(ctx context.Context, client *github.Client, owner, repo, path string) ([]byte, error) {
+				if m, ok := manifests[repo]; ok && path == ".github/secret-broker.yml" {
+					return []byte(m), nil
+				}
+				return nil, &repository.Error{Code: repository.CodeNotFound, Message: "Repository or secret not found"}
+			},
+			ListSecretsFunc: func(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+				return []string{"DEPLOY_KEY", "OTHER"}, nil
+			},
+		},
+	}
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = store
+	cookie := sessionCookie(store, goth.User{UserID: "1", NickName: "dev", AccessToken: "valid-token"})
+
+	tests := []struct {
+		repo     string
+		source   string
+		template string
+		required []string
+		missing  []string
+		problem  bool
+	}{
+		{repo: "web", source: "config", template: "deployable", required: []string{"DEPLOY_KEY", "REGISTRY_TOKEN", "SENTRY_DSN"}, missing: []string{"REGISTRY_TOKEN", "SENTRY_DSN"}},
+		{repo: "api", source: "file", template: "deployable", required: []string{"DEPLOY_KEY", "REGISTRY_TOKEN", "SENTRY_DSN", "STRIPE_KEY"}, missing: []string{"REGISTRY_TOKEN", "SENTRY_DSN", "STRIPE_KEY"}},
+		{repo: "broken", source: "file", template: "library", required: []string{}, missing: []string{}, problem: true},
+		{repo: "docs", required: []string{}, missing: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/repo/org/"+tt.repo+"/required-secrets", nil)
+			req.SetPathValue("owner", "org")
+			req.SetPathValue("repo", tt.repo)
+			req.Header.Set("Cookie", cookie)
+			w := httptest.NewRecorder()
+			app.handleRequiredSecrets(w, req)
+			assert.Equal(t, w.Code, http.StatusOK)
+
+			var res client.RequiredSecrets
+			_ = json.NewDecoder(w.Body).Decode(&res)
+			assert.Equal(t, res.Source, tt.source)
+			assert.Equal(t, res.Template, tt.template)
+			assert.Equal(t, slices.Equal(res.Required, tt.required), true)
+			assert.Equal(t, slices.Equal(res.Missing, tt.missing), true)
+			assert.Equal(t, res.Problem != "", tt.problem)
+		})
+	}
+}
@@ -1,12 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+	"github.com/RobinMaas95/gh-secret-broker/internal/openapi"
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/internal/roles"
 	"github.com/justinas/nosurf"
+	"github.com/markbates/goth"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerName identifies the spans created by the HTTP layer.
+const tracerName = "github.com/RobinMaas95/gh-secret-broker/cmd/web"
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -36,10 +60,290 @@ func preventCSRFFactory(isProduction bool) func(http.Handler) http.Handler {
 			Secure:   isProduction, // Only send over HTTPS in production
 			SameSite: http.SameSiteLaxMode,
 		})
+		// A browser never adds an API token on its own, so requests carrying
+		// one can't be forged cross-site
+		csrfHandler.ExemptFunc(func(r *http.Request) bool {
+			_, ok := tokenFromContext(r.Context())
+			return ok
+		})
+		csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeCSRFFailed, "Missing or invalid CSRF token")
+		}))
 		return csrfHandler
 	}
 }
 
+type tokenKey struct{}
+
+// tokenFromContext returns the API token the request was authenticated with.
+func tokenFromContext(ctx context.Context) (apitoken.Token, bool) {
+	tok, ok := ctx.Value(tokenKey{}).(apitoken.Token)
+	return tok, ok
+}
+
+// authenticateToken resolves an "Authorization: Bearer" API token and stores
+// it in the request context, from where requirePrincipal picks it up.
+// Requests without a bearer token are left to the session; requests with an
+// unknown, revoked or expired one are rejected.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, secret, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tok, err := app.tokens.Authenticate(strings.TrimSpace(secret))
+		if err != nil {
+			app.logger.WarnContext(r.Context(), "Rejected API token", slog.String("error", err.Error()))
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			app.clientError(w, r, http.StatusUnauthorized, "Invalid or expired API token")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, tok)))
+	})
+}
+
+// requireAdminToken rejects requests to the admin listener that don't carry
+// admin_token as a bearer token. It lets everything through while no token
+// is configured.
+func (app *application) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := app.cfg().AdminToken
+		if want == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scheme, got, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(want)) != 1 {
+			app.logger.WarnContext(r.Context(), "Rejected admin request", slog.String("path", r.URL.Path))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			app.clientError(w, r, http.StatusUnauthorized, "Invalid or missing admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type roleKey struct{}
+
+// roleGrant is the session user requireRole let through, with their role.
+type roleGrant struct {
+	User goth.User
+	Role roles.Role
+}
+
+// roleFromContext returns the user and role requireRole resolved for the
+// request.
+func roleFromContext(ctx context.Context) (roleGrant, bool) {
+	grant, ok := ctx.Value(roleKey{}).(roleGrant)
+	return grant, ok
+}
+
+// requireRole only lets session users with at least role min through. API
+// tokens are rejected: they are scoped to repositories, not to the broker's
+// administration.
+func (app *application) requireRole(min roles.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := app.requireUser(w, r)
+			if !ok {
+				return
+			}
+			role, err := app.userRole(r.Context(), user)
+			if err != nil {
+				app.errorResponse(w, r, err)
+				return
+			}
+			if role < min {
+				app.logger.WarnContext(r.Context(), "Role too low", slog.String("user", actorName(user)), slog.String("role", role.String()), slog.String("required", min.String()))
+				app.clientError(w, r, http.StatusForbidden, fmt.Sprintf("This requires the %s role", min))
+				return
+			}
+
+			grant := roleGrant{User: user, Role: role}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roleKey{}, grant)))
+		})
+	}
+}
+
+// responseRecorder wraps an http.ResponseWriter to remember the status code
+// and the number of body bytes that were sent, so that middleware can inspect
+// them after the handler ran.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if rr.status == 0 {
+		rr.status = code
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// statusCode returns the status that was sent. Handlers that never write
+// anything implicitly send 200.
+func (rr *responseRecorder) statusCode() int {
+	if rr.status == 0 {
+		return http.StatusOK
+	}
+	return rr.status
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// instrument records request counts and latencies. It is a factory because it
+// needs the mux to label requests by the matched route pattern instead of the
+// raw path, which would make the label cardinality unbounded.
+func (app *application) instrument(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			_, route := mux.Handler(r)
+			rec := &responseRecorder{ResponseWriter: w}
+
+			defer func() {
+				app.metrics.ObserveRequest(route, r.Method, rec.statusCode(), time.Since(start))
+			}()
+
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// maxRequestBody bounds the request bodies validateRequest reads. Secrets are
+// limited to 48 KB by GitHub.
+const maxRequestBody = 1 << 20
+
+// validateRequest checks the JSON bodies of API requests against the OpenAPI
+// document before they reach the handler. Like instrument, it takes the mux to
+// find the matched route pattern. Routes the document doesn't describe, such
+// as the OAuth flow and the webhook, pass unchecked.
+func (app *application) validateRequest(mux *http.ServeMux) func(http.Handler) http.Handler {
+	doc := openapi.MustLoad()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := mux.Handler(r)
+			op := doc.Operation(route)
+			if op == nil || op.RequestBody == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+			if err != nil {
+				app.clientError(w, r, http.StatusRequestEntityTooLarge, "Request body is too large")
+				return
+			}
+			var verr *openapi.ValidationError
+			switch err := op.ValidateBody(body); {
+			case errors.As(err, &verr):
+				app.clientError(w, r, http.StatusUnprocessableEntity, "Invalid request: "+verr.Message)
+				return
+			case err != nil:
+				app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trace starts a server span for every request that covers the rest of the
+// middleware chain and the handler. An incoming W3C traceparent header is
+// honoured, so the span joins the caller's trace. Like instrument, it takes the
+// mux to name the span after the matched route pattern.
+func (app *application) trace(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+			_, route := mux.Handler(r)
+			name := route
+			if name == "" {
+				name = r.Method
+			}
+
+			ctx, span := otel.Tracer(tracerName).Start(ctx, name,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("http.route", route),
+					attribute.String("url.path", r.URL.Path),
+				),
+			)
+			defer span.End()
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			status := rec.statusCode()
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+		})
+	}
+}
+
+// requestIDHeader carries the request ID. An ID sent by a proxy or client is
+// reused so a request can be followed across systems, a new one is generated
+// otherwise. It is echoed in the response.
+const requestIDHeader = "X-Request-ID"
+
+// requestID stores the request ID in the request context, from where it is
+// added to every log line and audit event of the request.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts the IDs common proxies generate (UUIDs, hex, base64url)
+// and rejects anything that could be abused for log injection.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b) // never returns an error
+	return hex.EncodeToString(b)
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
@@ -47,11 +351,21 @@ func (app *application) logRequest(next http.Handler) http.Handler {
 			proto  = r.Proto
 			method = r.Method
 			uri    = r.URL.RequestURI()
+			start  = time.Now()
 		)
 
-		app.logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+		app.logger.InfoContext(r.Context(), "received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
 
-		next.ServeHTTP(w, r)
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		app.logger.InfoContext(r.Context(), "request completed",
+			"method", method,
+			"uri", uri,
+			"status", rec.statusCode(),
+			"bytes", rec.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
 	})
 }
 
@@ -63,6 +377,10 @@ func (app *application) commonHeaders(next http.Handler) http.Handler {
 		w.Header().Set("X-Frame-Options", "deny")
 		w.Header().Set("X-XSS-Protection", "0")
 		w.Header().Set("Server", "Go")
+		if app.cfg().TLSEnabled() {
+			// Only sent when we terminate TLS ourselves, browsers ignore it over plain HTTP anyway
+			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -9,7 +9,15 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+	"github.com/RobinMaas95/gh-secret-broker/internal/metrics"
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestRecoverPanic(t *testing.T) {
@@ -41,6 +49,7 @@ func TestLogRequest(t *testing.T) {
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("hello"))
 	})
 
 	wrapped := app.logRequest(handler)
@@ -59,11 +68,68 @@ func TestLogRequest(t *testing.T) {
 	if !strings.Contains(logOutput, "/") {
 		t.Error("Expected '/' in logs")
 	}
+	if !strings.Contains(logOutput, "request completed") {
+		t.Error("Expected 'request completed' in logs")
+	}
+	if !strings.Contains(logOutput, "status=200") || !strings.Contains(logOutput, "bytes=5") {
+		t.Errorf("Expected status and size in logs, got %q", logOutput)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(logging.NewContextHandler(slog.NewTextHandler(&buf, nil)))
+	app := &application{logger: logger}
+
+	var seen string
+	handler := app.requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+		app.logger.InfoContext(r.Context(), "inside handler")
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"Valid incoming ID", "abc-123.DEF_456", true},
+		{"No incoming ID", "", false},
+		{"Invalid incoming ID", "bad id\nwith newline", false},
+		{"Too long incoming ID", strings.Repeat("a", 129), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set("X-Request-ID", tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if seen == "" {
+				t.Fatal("Expected a request ID in the context")
+			}
+			if tt.keep && seen != tt.incoming {
+				t.Errorf("Expected incoming ID %q, got %q", tt.incoming, seen)
+			}
+			if !tt.keep && seen == tt.incoming {
+				t.Errorf("Expected incoming ID %q to be replaced", tt.incoming)
+			}
+			if got := w.Header().Get("X-Request-ID"); got != seen {
+				t.Errorf("Expected response header %q, got %q", seen, got)
+			}
+			if !strings.Contains(buf.String(), "request_id="+seen) {
+				t.Errorf("Expected request_id in log line, got %q", buf.String())
+			}
+		})
+	}
 }
 
 func TestCommonHeaders(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	app := &application{logger: logger}
+	app := &application{logger: logger, config: &config.Config{}}
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -91,6 +157,26 @@ func TestCommonHeaders(t *testing.T) {
 	if headers.Get("Server") != "Go" {
 		t.Error("Expected Server: Go")
 	}
+	if headers.Get("Strict-Transport-Security") != "" {
+		t.Error("Expected no Strict-Transport-Security header without TLS")
+	}
+}
+
+func TestCommonHeadersHSTS(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{logger: logger, config: &config.Config{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key"}}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	app.commonHeaders(handler).ServeHTTP(w, req)
+
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=63072000; includeSubDomains" {
+		t.Errorf("Expected Strict-Transport-Security header, got %q", got)
+	}
 }
 
 func TestPreventCSRF(t *testing.T) {
@@ -123,3 +209,72 @@ func TestPreventCSRF(t *testing.T) {
 		}
 	}
 }
+
+func TestInstrument(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{logger: logger, metrics: metrics.New()}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	wrapped := app.instrument(mux)(mux)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items/42", nil)
+	wrapped.ServeHTTP(httptest.NewRecorder(), req)
+
+	w := httptest.NewRecorder()
+	app.metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `gh_secret_broker_http_requests_total{method="GET",route="GET /api/items/{id}",status="418"} 1`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("Expected metrics to contain %q", want)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &application{logger: logger}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// Spans started by handlers must be children of the server span
+		_, span := otel.Tracer("test").Start(r.Context(), "child")
+		span.End()
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/items/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	app.trace(mux)(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	child, server := spans[0], spans[1]
+
+	if server.Name != "GET /api/items/{id}" {
+		t.Errorf("Expected span named after the route, got %q", server.Name)
+	}
+	if server.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected incoming trace ID to be continued, got %s", server.SpanContext.TraceID())
+	}
+	if server.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected remote parent span, got %s", server.Parent.SpanID())
+	}
+	if child.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Error("Expected handler span to be a child of the server span")
+	}
+	if server.Status.Code != codes.Error {
+		t.Errorf("Expected error status for 502, got %v", server.Status.Code)
+	}
+}
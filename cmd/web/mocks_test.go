@@ -6,19 +6,28 @@ import (
 	"github.com/google/go-github/v80/github"
 )
 
-// mockRepositoryService mocks the repository.RepositoryService interface
+// mockRepositoryService mocks the repository.RepositoryService interface.
+// Like the real service, the functions should fail with *repository.Error
+// values so handlers map them to the right status.
 type mockRepositoryService struct {
-	ListMaintainableRepositoriesFunc func(ctx context.Context, client *github.Client, orgName string) ([]*github.Repository, error)
+	ListMaintainableRepositoriesFunc func(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error)
+	ListOrgRepositoriesFunc          func(ctx context.Context, client *github.Client, org string) ([]*github.Repository, error)
 	ListSecretsFunc                  func(ctx context.Context, client *github.Client, owner, repo string) ([]string, error)
+	ListSecretMetadataFunc           func(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error)
+	GetFileContentFunc               func(ctx context.Context, client *github.Client, owner, repo, path string) ([]byte, error)
 	DeleteSecretFunc                 func(ctx context.Context, client *github.Client, owner, repo, name string) error
 	CreateOrUpdateSecretFunc         func(ctx context.Context, client *github.Client, owner, repo, name, value string) error
 	HasMaintainerAccessFunc          func(ctx context.Context, client *github.Client, owner, repo string) (bool, error)
+	UserHasMaintainerAccessFunc      func(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error)
+	IsOrgMemberFunc                  func(ctx context.Context, client *github.Client, org, login string) (bool, error)
+	IsOrgOwnerFunc                   func(ctx context.Context, client *github.Client, org, login string) (bool, error)
+	IsTeamMemberFunc                 func(ctx context.Context, client *github.Client, org, team, login string) (bool, error)
 }
 
 // Our interfaces only check if a function is set, if so they call it, otherwise they return nil.
-func (m *mockRepositoryService) ListMaintainableRepositories(ctx context.Context, client *github.Client, orgName string) ([]*github.Repository, error) {
+func (m *mockRepositoryService) ListMaintainableRepositories(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
 	if m.ListMaintainableRepositoriesFunc != nil {
-		return m.ListMaintainableRepositoriesFunc(ctx, client, orgName)
+		return m.ListMaintainableRepositoriesFunc(ctx, client, orgNames...)
 	}
 	return nil, nil
 }
@@ -30,6 +39,27 @@ func (m *mockRepositoryService) ListSecrets(ctx context.Context, client *github.
 	return nil, nil
 }
 
+func (m *mockRepositoryService) ListOrgRepositories(ctx context.Context, client *github.Client, org string) ([]*github.Repository, error) {
+	if m.ListOrgRepositoriesFunc != nil {
+		return m.ListOrgRepositoriesFunc(ctx, client, org)
+	}
+	return nil, nil
+}
+
+func (m *mockRepositoryService) ListSecretMetadata(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error) {
+	if m.ListSecretMetadataFunc != nil {
+		return m.ListSecretMetadataFunc(ctx, client, owner, repo)
+	}
+	return nil, nil
+}
+
+func (m *mockRepositoryService) GetFileContent(ctx context.Context, client *github.Client, owner, repo, path string) ([]byte, error) {
+	if m.GetFileContentFunc != nil {
+		return m.GetFileContentFunc(ctx, client, owner, repo, path)
+	}
+	return nil, nil
+}
+
 func (m *mockRepositoryService) DeleteSecret(ctx context.Context, client *github.Client, owner, repo, name string) error {
 	if m.DeleteSecretFunc != nil {
 		return m.DeleteSecretFunc(ctx, client, owner, repo, name)
@@ -50,3 +80,31 @@ func (m *mockRepositoryService) HasMaintainerAccess(ctx context.Context, client
 	}
 	return false, nil
 }
+
+func (m *mockRepositoryService) UserHasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+	if m.UserHasMaintainerAccessFunc != nil {
+		return m.UserHasMaintainerAccessFunc(ctx, client, owner, repo, login)
+	}
+	return false, nil
+}
+
+func (m *mockRepositoryService) IsOrgMember(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+	if m.IsOrgMemberFunc != nil {
+		return m.IsOrgMemberFunc(ctx, client, org, login)
+	}
+	return false, nil
+}
+
+func (m *mockRepositoryService) IsOrgOwner(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+	if m.IsOrgOwnerFunc != nil {
+		return m.IsOrgOwnerFunc(ctx, client, org, login)
+	}
+	return false, nil
+}
+
+func (m *mockRepositoryService) IsTeamMember(ctx context.Context, client *github.Client, org, team, login string) (bool, error) {
+	if m.IsTeamMemberFunc != nil {
+		return m.IsTeamMemberFunc(ctx, client, org, team, login)
+	}
+	return false, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/markbates/goth/gothic"
+)
+
+// activeOrgSessionKey is the session key holding the organization the user
+// has switched to. An empty or missing value means "all organizations".
+const activeOrgSessionKey = "active_org"
+
+// activeOrg returns the organization selected in the user's session.
+// Organizations that are no longer configured are ignored.
+func (app *application) activeOrg(r *http.Request) string {
+	session, err := gothic.Store.Get(r, "session")
+	if err != nil {
+		return ""
+	}
+
+	org, _ := session.Values[activeOrgSessionKey].(string)
+	org, _ = app.cfg().LookupOrg(org)
+	return org
+}
+
+// handleListOrgs handles the GET /api/orgs request.
+// It returns the configured organizations and the one that is currently active.
+func (app *application) handleListOrgs(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.requireUser(w, r); !ok {
+		return
+	}
+
+	orgs := app.cfg().GithubOrgs
+	if orgs == nil {
+		orgs = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(client.Orgs{Orgs: orgs, Active: app.activeOrg(r)}); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode orgs", slog.String("error", err.Error()))
+	}
+}
+
+// handleSetActiveOrg handles the PUT /api/orgs/active request.
+// It stores the selected organization in the session. An empty org resets
+// the selection so that all organizations are shown again.
+func (app *application) handleSetActiveOrg(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.requireUser(w, r); !ok {
+		return
+	}
+
+	var req client.SetActiveOrgRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	org := ""
+	if req.Org != "" {
+		var ok bool
+		if org, ok = app.cfg().LookupOrg(req.Org); !ok {
+			app.clientError(w, r, http.StatusBadRequest, "Unknown organization")
+			return
+		}
+	}
+
+	session, err := gothic.Store.Get(r, "session")
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to get session", slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal Server Error")
+		return
+	}
+
+	if org == "" {
+		delete(session.Values, activeOrgSessionKey)
+	} else {
+		session.Values[activeOrgSessionKey] = org
+	}
+	if err := session.Save(r, w); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to save session", slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal Server Error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+func TestHandleOrgs(t *testing.T) {
+	app := &application{
+		logger:       setupTestLogger(),
+		repositories: &mockRepositoryService{},
+		config:       &config.Config{GithubOrgs: []string{"org-a", "org-b"}},
+	}
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = store
+
+	req, _ := http.NewRequest("GET", "/api/orgs", nil)
+	w := httptest.NewRecorder()
+	session, _ := store.Get(req, "session")
+	session.Values["user"] = goth.User{AccessToken: "valid-token"}
+	_ = session.Save(req, w)
+	cookie := w.Header().Get("Set-Cookie")
+
+	t.Run("List without active org", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/orgs", nil)
+		req.Header.Set("Cookie", cookie)
+		w := httptest.NewRecorder()
+
+		app.handleListOrgs(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var res client.Orgs
+		_ = json.NewDecoder(w.Body).Decode(&res)
+		assert.Equal(t, len(res.Orgs), 2)
+		assert.Equal(t, res.Active, "")
+	})
+
+	t.Run("Switch active org", func(t *testing.T) {
+		req, _ := http.NewRequest("PUT", "/api/orgs/active", strings.NewReader(`{"org": "ORG-B"}`))
+		req.Header.Set("Cookie", cookie)
+		w := httptest.NewRecorder()
+
+		app.handleSetActiveOrg(w, req)
+		assert.Equal(t, w.Code, http.StatusNoContent)
+
+		req, _ = http.NewRequest("GET", "/api/orgs", nil)
+		req.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+		w = httptest.NewRecorder()
+
+		app.handleListOrgs(w, req)
+		var res client.Orgs
+		_ = json.NewDecoder(w.Body).Decode(&res)
+		assert.Equal(t, res.Active, "org-b")
+	})
+
+	t.Run("Switch to unknown org", func(t *testing.T) {
+		req, _ := http.NewRequest("PUT", "/api/orgs/active", strings.NewReader(`{"org": "other-org"}`))
+		req.Header.Set("Cookie", cookie)
+		w := httptest.NewRecorder()
+
+		app.handleSetActiveOrg(w, req)
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+	})
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/orgs", nil)
+		w := httptest.NewRecorder()
+
+		app.handleListOrgs(w, req)
+		assert.Equal(t, w.Code, http.StatusUnauthorized)
+	})
+}
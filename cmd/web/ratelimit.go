@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is how many buckets rateLimiter keeps before it forgets the
+// ones that have refilled; a full bucket is the same as no bucket.
+const maxIdleBuckets = 1000
+
+// rateLimiter is a token bucket per key, e.g. per user. The zero value is
+// ready to use.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from the bucket of key, which holds perMinute tokens
+// and refills at that rate. If the bucket is empty, it returns how long
+// until the next token.
+func (l *rateLimiter) allow(key string, perMinute int, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	capacity := float64(perMinute)
+	refill := func(b *bucket) {
+		b.tokens = min(capacity, b.tokens+now.Sub(b.last).Minutes()*capacity)
+		b.last = now
+	}
+
+	if l.buckets == nil {
+		l.buckets = map[string]*bucket{}
+	}
+	if len(l.buckets) >= maxIdleBuckets {
+		for k, b := range l.buckets {
+			if refill(b); b.tokens >= capacity {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+	refill(b)
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / capacity * float64(time.Minute)), false
+	}
+	b.tokens--
+	return 0, true
+}
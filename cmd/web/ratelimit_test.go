@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var l rateLimiter
+	now := time.Now()
+
+	for range 2 {
+		_, ok := l.allow("a", 2, now)
+		assert.Equal(t, ok, true)
+	}
+	wait, ok := l.allow("a", 2, now)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, wait, 30*time.Second)
+
+	// Other keys have their own bucket
+	_, ok = l.allow("b", 2, now)
+	assert.Equal(t, ok, true)
+
+	// Half a minute refills one token
+	_, ok = l.allow("a", 2, now.Add(30*time.Second))
+	assert.Equal(t, ok, true)
+	_, ok = l.allow("a", 2, now.Add(30*time.Second))
+	assert.Equal(t, ok, false)
+}
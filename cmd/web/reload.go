@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+)
+
+// restartOnlyFields are the config keys that are baked into the listener,
+// the session store, the OAuth provider or the device flow at startup.
+// Changes to them are reported on reload but only take effect after a
+// restart.
+var restartOnlyFields = []string{
+	"addr",
+	"admin_addr",
+	"base_url",
+	"environment",
+	"log_format",
+	"cache_ttl",
+	"audit_log_file",
+	"api_token_file",
+	"break_glass_file",
+	"session_secret",
+	"github_client_id",
+	"github_client_secret",
+	"github_enterprise_url",
+	"login_providers",
+	"oidc_issuer",
+	"oidc_client_id",
+	"oidc_client_secret",
+	"oidc_name",
+	"oidc_github_claim",
+	"otlp_endpoint",
+	"tls_cert_file",
+	"tls_key_file",
+	"tls_client_ca_file",
+	"tls_client_auth",
+}
+
+// reloadConfig loads a new configuration with load and swaps it in together
+// with a freshly built PAT client. Requests that are already running keep
+// the client they obtained from app.pat(). If anything fails, the current
+// configuration stays in place.
+func (app *application) reloadConfig(load func() (*config.Config, error)) error {
+	next, err := load()
+	if err != nil {
+		return err
+	}
+
+	current := app.cfg()
+
+	// Changes are taken before pinning, so restart-only fields are reported
+	changes := config.Diff(current, next)
+	// In development the session secret is generated on every load
+	if !current.IsProduction() {
+		changes = slices.DeleteFunc(changes, func(c config.Change) bool { return c.Field == "session_secret" })
+	}
+	if err := next.Pin(current, restartOnlyFields); err != nil {
+		return fmt.Errorf("failed to keep restart-only fields: %w", err)
+	}
+
+	transport := app.patTransport
+	if transport == nil {
+		transport = app.metrics.Transport(nil, "pat")
+	}
+	patClient, err := newGitHubClient(context.Background(), next.GithubPAT, next.GithubEnterpriseURL, transport)
+	if err != nil {
+		return fmt.Errorf("failed to create PAT client: %w", err)
+	}
+
+	app.mu.Lock()
+	app.config = next
+	app.patClient = patClient
+	app.mu.Unlock()
+
+	// A changed level in the config wins over one set via the admin endpoint
+	if next.LogLevel != current.LogLevel && app.logLevel != nil {
+		app.logLevel.Set(next.Level())
+	}
+
+	if len(changes) == 0 {
+		app.logger.Info("Configuration reloaded, nothing changed")
+		return nil
+	}
+	for _, change := range changes {
+		if slices.Contains(restartOnlyFields, change.Field) {
+			app.logger.Warn("Configuration change requires a restart", slog.String("field", change.Field))
+			continue
+		}
+		app.logger.Info("Configuration changed", slog.String("field", change.Field), slog.String("old", change.Old), slog.String("new", change.New))
+	}
+	return nil
+}
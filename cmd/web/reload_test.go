@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/google/go-github/v80/github"
+)
+
+func TestReloadConfig(t *testing.T) {
+	t.Run("Swaps config and PAT client", func(t *testing.T) {
+		var buf bytes.Buffer
+		oldClient := github.NewClient(nil)
+		app := &application{
+			logger: slog.New(slog.NewTextHandler(&buf, nil)),
+			config: &config.Config{
+				Addr:       ":4000",
+				GithubOrgs: []string{"org-a"},
+				GithubPAT:  "old-pat-value",
+			},
+			patClient: oldClient,
+		}
+
+		// A request that is in flight holds on to the old client
+		inFlight := app.pat()
+
+		err := app.reloadConfig(func() (*config.Config, error) {
+			return &config.Config{
+				Addr:       ":9999",
+				GithubOrgs: []string{"org-a", "org-b"},
+				GithubPAT:  "new-pat-value",
+			}, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if app.pat() == oldClient {
+			t.Error("expected PAT client to be replaced")
+		}
+		if inFlight != oldClient {
+			t.Error("in-flight request must keep the old client")
+		}
+		assert.Equal(t, app.cfg().GithubPAT, "new-pat-value")
+		assert.Equal(t, len(app.cfg().GithubOrgs), 2)
+		// Restart-only fields keep their current value
+		assert.Equal(t, app.cfg().Addr, ":4000")
+
+		logs := buf.String()
+		if !strings.Contains(logs, "github_orgs") || !strings.Contains(logs, "github_pat") {
+			t.Errorf("expected changed fields in logs, got:\n%s", logs)
+		}
+		if strings.Contains(logs, "pat-value") {
+			t.Errorf("secret values must not be logged, got:\n%s", logs)
+		}
+		if !strings.Contains(logs, "requires a restart") {
+			t.Errorf("expected restart warning for addr, got:\n%s", logs)
+		}
+	})
+
+	t.Run("Keeps every restart-only field", func(t *testing.T) {
+		var buf bytes.Buffer
+		current := &config.Config{Environment: "production"}
+		app := &application{
+			logger: slog.New(slog.NewTextHandler(&buf, nil)),
+			config: current,
+		}
+
+		next := &config.Config{Environment: "production"}
+		v := reflect.ValueOf(next).Elem()
+		set := 0
+		for i := range v.NumField() {
+			if !slices.Contains(restartOnlyFields, v.Type().Field(i).Tag.Get("yaml")) {
+				continue
+			}
+			switch f := v.Field(i); f.Kind() {
+			case reflect.String:
+				f.SetString("changed")
+			case reflect.Slice:
+				f.Set(reflect.ValueOf([]string{"changed"}))
+			case reflect.Int64:
+				f.SetInt(int64(time.Minute))
+			default:
+				t.Fatalf("unexpected kind %s", f.Kind())
+			}
+			set++
+		}
+		assert.Equal(t, set, len(restartOnlyFields))
+
+		err := app.reloadConfig(func() (*config.Config, error) { return next, nil })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if changes := config.Diff(current, app.cfg()); len(changes) != 0 {
+			t.Errorf("restart-only fields changed on reload: %v", changes)
+		}
+		logs := buf.String()
+		assert.Equal(t, strings.Count(logs, "requires a restart"), len(restartOnlyFields))
+		assert.Equal(t, strings.Contains(logs, "Configuration changed"), false)
+	})
+
+	t.Run("Keeps the GitHub host", func(t *testing.T) {
+		// The OAuth provider and the device flow keep talking to the host
+		// they started with, so the PAT client must too
+		app := &application{
+			logger: setupTestLogger(),
+			config: &config.Config{GithubEnterpriseURL: "https://ghe.example.com"},
+		}
+
+		err := app.reloadConfig(func() (*config.Config, error) {
+			return &config.Config{GithubEnterpriseURL: "https://other.example.com"}, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, app.cfg().GithubEnterpriseURL, "https://ghe.example.com")
+		assert.Equal(t, app.pat().BaseURL.Host, "ghe.example.com")
+	})
+
+	t.Run("Applies changed log level", func(t *testing.T) {
+		app := &application{
+			logger:   setupTestLogger(),
+			logLevel: new(slog.LevelVar),
+			config:   &config.Config{LogLevel: "info"},
+		}
+
+		err := app.reloadConfig(func() (*config.Config, error) {
+			return &config.Config{LogLevel: "debug"}, nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assert.Equal(t, app.logLevel.Level(), slog.LevelDebug)
+	})
+
+	t.Run("Keeps current config on error", func(t *testing.T) {
+		current := &config.Config{GithubPAT: "pat"}
+		app := &application{
+			logger: setupTestLogger(),
+			config: current,
+		}
+
+		err := app.reloadConfig(func() (*config.Config, error) {
+			return nil, errors.New("broken config")
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if app.cfg() != current {
+			t.Error("expected current config to be kept")
+		}
+	})
+}
@@ -1,47 +1,164 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+)
+
+const (
+	// linkedCheckConcurrency bounds the permission checks of
+	// linkedRepositories that run at once, so they don't trip GitHub's
+	// secondary rate limits.
+	linkedCheckConcurrency = 5
+	// linkedCheckBudget bounds the time linkedRepositories spends checking
+	// permissions, well within the server's write timeout.
+	linkedCheckBudget = 5 * time.Second
 )
 
-// handleListRepositories handles the GET /api/repositories request.
+// handleListRepositories handles the GET /api/user/repos request.
 // It retrieves the list of repositories where the user has maintain/admin access
-// in the organization configured in GITHUB_ORG.
+// in the organizations configured in GITHUB_ORG, grouped per organization.
+// If the session has an active organization, only that organization is listed.
+// Requests with an API token list the repositories within the token's scope.
+// Users without a GitHub token of their own are looked up with the PAT.
 func (app *application) handleListRepositories(w http.ResponseWriter, r *http.Request) {
-	// Get Session & Verify User
-	user, ok := app.requireUser(w, r)
+	// Get Session or API Token & Verify User
+	p, ok := app.requirePrincipal(w, r)
 	if !ok {
 		return
 	}
 
-	// Create GitHub Client using User's Token
-	githubClient, err := app.getGitHubClient(r.Context(), user.AccessToken)
-	if err != nil {
-		app.logger.Error("Failed to create GitHub client", slog.String("error", err.Error()))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	// Retrieve Repositories via Service
+	orgNames := app.cfg().GithubOrgs
+	if len(orgNames) == 0 {
+		app.logger.ErrorContext(r.Context(), "GITHUB_ORG is not configured")
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Configuration Error: GITHUB_ORG not set")
 		return
 	}
 
-	// Retrieve Repositories via Service
-	orgName := app.config.GithubOrg
-	if orgName == "" {
-		app.logger.Error("GITHUB_ORG is not configured")
-		http.Error(w, "Configuration Error: GITHUB_ORG not set", http.StatusInternalServerError)
-		return
+	if p.Token == nil {
+		if activeOrg := app.activeOrg(r); activeOrg != "" {
+			orgNames = []string{activeOrg}
+		}
 	}
 
-	repos, err := app.repositories.ListMaintainableRepositories(r.Context(), githubClient, orgName)
+	var repos []*github.Repository
+	var err error
+	if p.linked() {
+		repos, err = app.linkedRepositories(r.Context(), p, orgNames)
+	} else {
+		// Create GitHub Client using User's Token
+		var githubClient *github.Client
+		githubClient, err = app.getGitHubClient(r.Context(), p.User.AccessToken)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "Failed to create GitHub client", slog.String("error", err.Error()))
+			problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal, "Internal Server Error")
+			return
+		}
+		repos, err = app.repositories.ListMaintainableRepositories(repository.WithUser(r.Context(), p.User.UserID), githubClient, orgNames...)
+	}
 	if err != nil {
-		app.logger.Error("Failed to list repositories", slog.String("error", err.Error()), slog.String("org", orgName))
-		http.Error(w, "Failed to fetch repositories", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "Failed to list repositories", slog.String("error", err.Error()), slog.String("orgs", strings.Join(orgNames, ",")))
+		app.errorResponse(w, r, err)
 		return
 	}
 
 	// Respond with JSON
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(repos); err != nil {
-		app.logger.Error("Failed to encode response", slog.String("error", err.Error()))
+	if err := json.NewEncoder(w).Encode(groupByOrg(orgNames, repos)); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode response", slog.String("error", err.Error()))
+	}
+}
+
+// linkedRepositories lists the repositories a linked principal maintains,
+// within the scope of the API token if there is one. Without a user token,
+// the candidates are listed with the PAT and each one is checked for the
+// user.
+//
+// Tokens scoped to single repositories only need those checked. For
+// organization-wide scopes and OIDC users, every repository the PAT
+// maintains is a candidate, which can be far more checks than fit into a
+// request. They run a few at a time within linkedCheckBudget; repositories
+// whose check didn't finish are left out this time. Checks are cached per
+// user, so later listings pick up where this one stopped.
+func (app *application) linkedRepositories(ctx context.Context, p principal, orgNames []string) ([]*github.Repository, error) {
+	var orgs []string
+	for _, org := range orgNames {
+		if p.Token == nil || p.Token.InOrg(org) {
+			orgs = append(orgs, org)
+		}
+	}
+	if len(orgs) == 0 {
+		return nil, nil
+	}
+
+	candidates, err := app.repositories.ListMaintainableRepositories(ctx, app.pat(), orgs...)
+	if err != nil {
+		return nil, err
+	}
+	if p.Token != nil {
+		candidates = slices.DeleteFunc(candidates, func(repo *github.Repository) bool {
+			return !p.Token.InScope(repo.GetOwner().GetLogin(), repo.GetName())
+		})
+	}
+
+	checkCtx, cancel := context.WithTimeout(repository.WithUser(ctx, p.User.UserID), linkedCheckBudget)
+	defer cancel()
+	maintained := make([]bool, len(candidates))
+	errs := make([]error, len(candidates))
+	parallel(linkedCheckConcurrency, len(candidates), func(i int) {
+		if checkCtx.Err() != nil {
+			errs[i] = checkCtx.Err()
+			return
+		}
+		maintained[i], errs[i] = app.repositories.UserHasMaintainerAccess(checkCtx, app.pat(), candidates[i].GetOwner().GetLogin(), candidates[i].GetName(), p.User.NickName)
+	})
+
+	var repos []*github.Repository
+	unchecked := 0
+	for i, repo := range candidates {
+		switch {
+		case errs[i] != nil && ctx.Err() == nil && checkCtx.Err() != nil:
+			unchecked++
+		case errs[i] != nil:
+			return nil, errs[i]
+		case maintained[i]:
+			repos = append(repos, repo)
+		}
+	}
+	if unchecked > 0 {
+		app.logger.WarnContext(ctx, "Listing repositories ran out of time", slog.String("user", actorName(p.User)), slog.Int("unchecked", unchecked), slog.Int("candidates", len(candidates)))
 	}
+	return repos, nil
+}
+
+// groupByOrg sorts the repositories into one group per organization.
+// Groups keep the order of orgNames and are present even if empty,
+// so that the frontend can show every organization the user can switch to.
+func groupByOrg(orgNames []string, repos []*github.Repository) []client.OrgRepositories {
+	groups := make([]client.OrgRepositories, len(orgNames))
+	for i, org := range orgNames {
+		groups[i] = client.OrgRepositories{Org: org, Repositories: []*github.Repository{}}
+	}
+
+	for _, repo := range repos {
+		for i := range groups {
+			if strings.EqualFold(repo.GetOwner().GetLogin(), groups[i].Org) {
+				groups[i].Repositories = append(groups[i].Repositories, repo)
+				break
+			}
+		}
+	}
+
+	return groups
 }
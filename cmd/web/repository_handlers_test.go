@@ -3,12 +3,18 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
 	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
 	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
 	"github.com/google/go-github/v80/github"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
@@ -19,9 +25,9 @@ func TestHandleListRepositories(t *testing.T) {
 	t.Run("Authorized user", func(t *testing.T) {
 		// Mock Service
 		mockService := &mockRepositoryService{
-			ListMaintainableRepositoriesFunc: func(ctx context.Context, client *github.Client, orgName string) ([]*github.Repository, error) {
+			ListMaintainableRepositoriesFunc: func(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
 				return []*github.Repository{
-					{Name: github.Ptr("repo-1")},
+					{Name: github.Ptr("repo-1"), Owner: &github.User{Login: github.Ptr("test-org")}},
 				}, nil
 			},
 		}
@@ -29,7 +35,7 @@ func TestHandleListRepositories(t *testing.T) {
 		app := &application{
 			logger:       setupTestLogger(),
 			repositories: mockService,
-			config:       &config.Config{GithubOrg: "test-org"},
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
 		}
 
 		// Mock Session
@@ -60,21 +66,132 @@ func TestHandleListRepositories(t *testing.T) {
 
 		assert.Equal(t, res.StatusCode, http.StatusOK)
 
-		var repos []*github.Repository
-		_ = json.NewDecoder(res.Body).Decode(&repos)
+		var groups []client.OrgRepositories
+		_ = json.NewDecoder(res.Body).Decode(&groups)
+		if len(groups) != 1 {
+			t.Fatalf("expected 1 org group, got %d", len(groups))
+		}
+		assert.Equal(t, groups[0].Org, "test-org")
+		repos := groups[0].Repositories
 		if len(repos) != 1 {
-			t.Errorf("expected 1 repo, got %d", len(repos))
+			t.Fatalf("expected 1 repo, got %d", len(repos))
 		}
 		if *repos[0].Name != "repo-1" {
 			t.Errorf("expected repo-1, got %s", *repos[0].Name)
 		}
 	})
 
+	t.Run("Grouped per org and scoped by active org", func(t *testing.T) {
+		var requestedOrgs []string
+		mockService := &mockRepositoryService{
+			ListMaintainableRepositoriesFunc: func(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
+				requestedOrgs = orgNames
+				return []*github.Repository{
+					{Name: github.Ptr("repo-a"), Owner: &github.User{Login: github.Ptr("org-a")}},
+					{Name: github.Ptr("repo-b"), Owner: &github.User{Login: github.Ptr("ORG-B")}},
+				}, nil
+			},
+		}
+
+		app := &application{
+			logger:       setupTestLogger(),
+			repositories: mockService,
+			config:       &config.Config{GithubOrgs: []string{"org-a", "org-b"}},
+		}
+
+		store := sessions.NewCookieStore([]byte("secret"))
+		gothic.Store = store
+
+		req, _ := http.NewRequest("GET", "/api/user/repos", nil)
+		w := httptest.NewRecorder()
+		session, _ := store.Get(req, "session")
+		session.Values["user"] = goth.User{AccessToken: "valid-token"}
+		_ = session.Save(req, w)
+		cookie := w.Header().Get("Set-Cookie")
+
+		// All orgs
+		req, _ = http.NewRequest("GET", "/api/user/repos", nil)
+		req.Header.Set("Cookie", cookie)
+		w = httptest.NewRecorder()
+		app.handleListRepositories(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var groups []client.OrgRepositories
+		_ = json.NewDecoder(w.Body).Decode(&groups)
+		if len(groups) != 2 {
+			t.Fatalf("expected 2 org groups, got %d", len(groups))
+		}
+		assert.Equal(t, groups[0].Org, "org-a")
+		assert.Equal(t, groups[0].Repositories[0].GetName(), "repo-a")
+		assert.Equal(t, groups[1].Org, "org-b")
+		assert.Equal(t, groups[1].Repositories[0].GetName(), "repo-b")
+
+		// Active org only
+		req, _ = http.NewRequest("GET", "/api/user/repos", nil)
+		session, _ = store.Get(req, "session")
+		session.Values["user"] = goth.User{AccessToken: "valid-token"}
+		session.Values[activeOrgSessionKey] = "org-b"
+		w = httptest.NewRecorder()
+		_ = session.Save(req, w)
+		req.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+
+		w = httptest.NewRecorder()
+		app.handleListRepositories(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+		if len(requestedOrgs) != 1 || requestedOrgs[0] != "org-b" {
+			t.Errorf("expected listing scoped to org-b, got %v", requestedOrgs)
+		}
+	})
+
+	t.Run("Organization-wide token", func(t *testing.T) {
+		var inFlight, maxInFlight atomic.Int32
+		app := &application{
+			logger: setupTestLogger(),
+			config: &config.Config{GithubOrgs: []string{"test-org"}},
+			repositories: &mockRepositoryService{
+				ListMaintainableRepositoriesFunc: func(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
+					var repos []*github.Repository
+					for i := range 20 {
+						repos = append(repos, &github.Repository{Name: github.Ptr(fmt.Sprintf("repo-%02d", i)), Owner: &github.User{Login: github.Ptr("test-org")}})
+					}
+					return repos, nil
+				},
+				UserHasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+					n := inFlight.Add(1)
+					defer inFlight.Add(-1)
+					for {
+						m := maxInFlight.Load()
+						if n <= m || maxInFlight.CompareAndSwap(m, n) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond)
+					return strings.HasSuffix(repo, "0"), nil
+				},
+			},
+		}
+
+		req := httptest.NewRequest("GET", "/api/user/repos", nil)
+		req = req.WithContext(context.WithValue(req.Context(), tokenKey{}, apitoken.Token{UserID: "42", Login: "octocat", Repositories: []string{"test-org/*"}}))
+		w := httptest.NewRecorder()
+		app.handleListRepositories(w, req)
+		assert.Equal(t, w.Code, http.StatusOK)
+
+		var groups []client.OrgRepositories
+		_ = json.NewDecoder(w.Body).Decode(&groups)
+		assert.Equal(t, len(groups[0].Repositories), 2)
+		assert.Equal(t, groups[0].Repositories[1].GetName(), "repo-10")
+		// The checks run a few at a time
+		if maxInFlight.Load() > linkedCheckConcurrency {
+			t.Errorf("Expected at most %d checks at once, got %d", linkedCheckConcurrency, maxInFlight.Load())
+		}
+	})
+
 	t.Run("Unauthorized user", func(t *testing.T) {
 		app := &application{
 			logger:       setupTestLogger(),
 			repositories: &mockRepositoryService{},
-			config:       &config.Config{GithubOrg: "test-org"},
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
 		}
 
 		req, _ := http.NewRequest("GET", "/api/repositories", nil)
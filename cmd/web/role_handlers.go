@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/internal/roles"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/markbates/goth"
+)
+
+// patRoleLookup answers membership questions for role assignment with the
+// PAT, as OIDC users have no GitHub token and users' tokens may lack the
+// read:org scope.
+type patRoleLookup struct {
+	repositories repository.RepositoryService
+	client       *github.Client
+}
+
+func (l patRoleLookup) IsOrgOwner(ctx context.Context, org, login string) (bool, error) {
+	return l.repositories.IsOrgOwner(ctx, l.client, org, login)
+}
+
+func (l patRoleLookup) IsTeamMember(ctx context.Context, org, team, login string) (bool, error) {
+	return l.repositories.IsTeamMember(ctx, l.client, org, team, login)
+}
+
+// userRole returns the broker role of user.
+func (app *application) userRole(ctx context.Context, user goth.User) (roles.Role, error) {
+	ctx = repository.WithUser(ctx, user.UserID)
+	lookup := patRoleLookup{repositories: app.repositories, client: app.pat()}
+	return roles.FromConfig(app.cfg()).Resolve(ctx, lookup, user.NickName)
+}
+
+// handleUserRole handles the GET /api/user/role request.
+func (app *application) handleUserRole(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.requireUser(w, r)
+	if !ok {
+		return
+	}
+	role, err := app.userRole(r.Context(), user)
+	if err != nil {
+		app.errorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(client.UserRole{Role: role.String()}); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode role", slog.String("error", err.Error()))
+	}
+}
+
+// handleAdminRoles handles the GET /api/admin/roles request. It shows how
+// roles are assigned; changing that takes a change of the config.
+func (app *application) handleAdminRoles(w http.ResponseWriter, r *http.Request) {
+	cfg := app.cfg()
+	res := client.RoleAssignments{
+		AdminUsers:         nonNil(cfg.AdminUsers),
+		AdminTeams:         nonNil(cfg.AdminTeams),
+		AuditorUsers:       nonNil(cfg.AuditorUsers),
+		AuditorTeams:       nonNil(cfg.AuditorTeams),
+		OrgOwnersAreAdmins: cfg.OrgOwnersAreAdmins,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode role assignments", slog.String("error", err.Error()))
+	}
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
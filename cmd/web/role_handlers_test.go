@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/internal/roles"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+func TestRoles(t *testing.T) {
+	app := &application{
+		logger: setupTestLogger(),
+		config: &config.Config{
+			GithubOrgs:         []string{"org"},
+			AdminUsers:         []string{"root"},
+			AuditorTeams:       []string{"org/security"},
+			OrgOwnersAreAdmins: true,
+		},
+		repositories: &mockRepositoryService{
+			IsOrgOwnerFunc: func(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+				if login == "broken" {
+					return false, &repository.Error{Code: repository.CodeUpstream, Message: "GitHub is down"}
+				}
+				return org == "org" && login == "owner", nil
+			},
+			IsTeamMemberFunc: func(ctx context.Context, client *github.Client, org, team, login string) (bool, error) {
+				return org == "org" && team == "security" && login == "sec", nil
+			},
+		},
+	}
+
+	store := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = store
+	cookie := func(login string) string {
+		return sessionCookie(store, goth.User{UserID: login + "-id", NickName: login, AccessToken: "valid-token"})
+	}
+	admin := app.requireRole(roles.Auditor)(http.HandlerFunc(app.handleAdminRoles))
+
+	tests := []struct {
+		login  string
+		role   string
+		status int
+	}{
+		{login: "someone", role: "user", status: http.StatusForbidden},
+		{login: "sec", role: "auditor", status: http.StatusOK},
+		{login: "root", role: "admin", status: http.StatusOK},
+		{login: "owner", role: "admin", status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.login, func(t *testing.T) {
+			c := cookie(tt.login)
+
+			req := httptest.NewRequest("GET", "/api/user/role", nil)
+			req.Header.Set("Cookie", c)
+			w := httptest.NewRecorder()
+			app.handleUserRole(w, req)
+			assert.Equal(t, w.Code, http.StatusOK)
+
+			var role client.UserRole
+			_ = json.NewDecoder(w.Body).Decode(&role)
+			assert.Equal(t, role.Role, tt.role)
+
+			req = httptest.NewRequest("GET", "/api/admin/roles", nil)
+			req.Header.Set("Cookie", c)
+			w = httptest.NewRecorder()
+			admin.ServeHTTP(w, req)
+			assert.Equal(t, w.Code, tt.status)
+			if tt.status == http.StatusOK {
+				var res client.RoleAssignments
+				_ = json.NewDecoder(w.Body).Decode(&res)
+				assert.Equal(t, res.AuditorTeams[0], "org/security")
+				assert.Equal(t, len(res.AuditorUsers), 0)
+			}
+		})
+	}
+
+	t.Run("API token", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/admin/roles", nil)
+		req = req.WithContext(context.WithValue(req.Context(), tokenKey{}, apitoken.Token{UserID: "root-id", Login: "root"}))
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, http.StatusForbidden)
+	})
+
+	t.Run("Not logged in", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/roles", nil))
+		assert.Equal(t, w.Code, http.StatusUnauthorized)
+	})
+
+	t.Run("Lookup fails", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/admin/roles", nil)
+		req.Header.Set("Cookie", cookie("broken"))
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		assert.Equal(t, w.Code, http.StatusBadGateway)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/internal/roles"
 	"github.com/justinas/alice"
 )
 
@@ -11,36 +12,90 @@ func (app *application) routes(oauthService *oauth.Service) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", app.handleSPA)
 	mux.HandleFunc("GET /ping", ping)
+	mux.HandleFunc("GET /healthz", healthz)
+	mux.HandleFunc("GET /readyz", app.handleReadyz)
 
-	dynamic := alice.New(preventCSRFFactory(app.config.IsProduction()))
+	dynamic := alice.New(preventCSRFFactory(app.cfg().IsProduction()))
 
 	// CSRF Protection Strategy:
 	// - OAuth flows (login/logout) use CSRF protection via the 'dynamic' middleware
-	// - All current API endpoints are GET requests (read-only) and don't need CSRF
+	// - Every POST/PUT/DELETE route with a session uses 'dynamic'; the SPA
+	//   sends the token from GET /api/csrf-token in X-CSRF-Token
+	// - Requests with an "Authorization: Bearer" API token are exempt, as a
+	//   browser never adds one on its own (see preventCSRFFactory)
+	// - GET routes don't change state and need no token
 	// - SameSite=Lax cookies provide additional protection against cross-site requests
 	//
-	// IMPORTANT: When adding POST/PUT/DELETE endpoints in the future:
-	// Apply the 'dynamic' middleware but ensure your SPA sends CSRF tokens
-	//
-	// Example for future state-changing endpoints:
-	//   mux.Handle("POST /api/secrets", dynamic.ThenFunc(app.handleCreateSecret))
+	// IMPORTANT: When adding state-changing endpoints, apply 'dynamic':
+	//   mux.Handle("PUT /api/repo/{owner}/{repo}/secrets/{name}", dynamic.ThenFunc(app.handleCreateSecret))
 
 	mux.HandleFunc("GET /auth/{provider}/callback", oauthService.HandleCallback)
 	mux.Handle("GET /logout/{provider}", dynamic.ThenFunc(oauthService.ProviderLogout))
 	mux.Handle("GET /auth/{provider}", dynamic.ThenFunc(oauthService.ProviderLogin))
 
-	// API Routes - All currently read-only (GET)
-	// No CSRF needed as they don't change state and SameSite cookies provide protection
+	// API Routes - GET routes are read-only and registered without 'dynamic',
+	// state-changing ones with it (except for the device login and webhook
+	// routes below, which have their own credentials)
+	// Every /api route needs an entry in internal/openapi/openapi.json
+	mux.HandleFunc("GET /api/openapi.json", handleOpenAPI)
 	mux.HandleFunc("GET /api/providers", oauthService.HandleProvidersAPI)
 	mux.HandleFunc("GET /api/user", oauthService.HandleUserAPI)
 	mux.HandleFunc("GET /api/user/repos", app.handleListRepositories)
+	mux.HandleFunc("GET /api/orgs", app.handleListOrgs)
 	mux.HandleFunc("GET /api/repo/{owner}/{repo}/secrets", app.handleListSecrets)
+	mux.HandleFunc("GET /api/repo/{owner}/{repo}/required-secrets", app.handleRequiredSecrets)
 	// Dynamic because we need our preventCSRFFactory to be applied so that
 	// our token endpoint returns a valid token
 	mux.Handle("GET /api/csrf-token", dynamic.ThenFunc(app.handleCsrfToken))
 	mux.Handle("DELETE /api/repo/{owner}/{repo}/secrets/{name}", dynamic.ThenFunc(app.handleDeleteSecret))
 	mux.Handle("PUT /api/repo/{owner}/{repo}/secrets/{name}", dynamic.ThenFunc(app.handleCreateSecret))
+	mux.Handle("PUT /api/orgs/active", dynamic.ThenFunc(app.handleSetActiveOrg))
+	mux.HandleFunc("GET /api/tokens", app.handleListTokens)
+	mux.Handle("POST /api/tokens", dynamic.ThenFunc(app.handleCreateToken))
+	mux.Handle("DELETE /api/tokens/{id}", dynamic.ThenFunc(app.handleRevokeToken))
+	mux.HandleFunc("GET /api/user/role", app.handleUserRole)
+	mux.Handle("POST /api/break-glass", dynamic.ThenFunc(app.handleCreateBreakGlass))
+
+	// /api/admin is for auditors and admins; requireRole checks the role of
+	// the session user on every request
+	auditor := dynamic.Append(app.requireRole(roles.Auditor))
+	mux.Handle("GET /api/admin/roles", auditor.ThenFunc(app.handleAdminRoles))
+	audits := auditor.Append(app.limitAudits)
+	mux.Handle("GET /api/admin/repos", audits.ThenFunc(app.handleAuditRepositories))
+	mux.Handle("GET /api/admin/repo/{owner}/{repo}/secrets", audits.ThenFunc(app.handleAuditSecrets))
+	mux.Handle("GET /api/admin/break-glass", auditor.ThenFunc(app.handleListBreakGlass))
+	admin := dynamic.Append(app.requireRole(roles.Admin))
+	mux.Handle("POST /api/admin/break-glass/{id}/review", admin.ThenFunc(app.handleReviewBreakGlass))
+	mux.Handle("POST /api/admin/incidents/preview", admin.ThenFunc(app.handlePreviewIncident))
+	mux.Handle("POST /api/admin/incidents", admin.ThenFunc(app.handleRunIncident))
+	mux.Handle("GET /api/admin/incidents/{id}", admin.ThenFunc(app.handleGetIncident))
+
+	// Device logins have no session yet; the device code is the credential
+	mux.HandleFunc("POST /api/device/code", app.handleDeviceCode)
+	mux.HandleFunc("POST /api/device/token", app.handleDeviceToken)
+
+	// GitHub signs its deliveries, so no session or CSRF token is involved
+	mux.HandleFunc("POST /webhooks/github", app.handleGitHubWebhook)
 
-	standard := alice.New(app.recoverPanic, app.logRequest, app.commonHeaders)
+	// logRequest runs outside recoverPanic so that the completion line of a
+	// request that panicked shows the 500 it ended with.
+	// authenticateToken only acts on requests with an "Authorization: Bearer"
+	// API token; the secret endpoints accept those instead of a session.
+	// validateRequest runs last so that unauthenticated requests are
+	// rejected as such before their bodies are looked at.
+	standard := alice.New(app.requestID, app.trace(mux), app.instrument(mux), app.logRequest, app.recoverPanic, app.commonHeaders, app.authenticateToken, app.validateRequest(mux))
 	return standard.Then(mux)
 }
+
+// adminRoutes returns the handler of the admin listener. With admin_token
+// set, every request needs it as a bearer token. Without one, the config only
+// allows binding admin_addr to loopback, as anyone who can reach the listener
+// could change the log level with PUT /log-level.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", app.metrics.Handler())
+	mux.HandleFunc("GET /log-level", app.handleGetLogLevel)
+	mux.HandleFunc("PUT /log-level", app.handleSetLogLevel)
+
+	return alice.New(app.recoverPanic, app.requireAdminToken).Then(mux)
+}
@@ -1,11 +1,24 @@
 package main
 
 import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
 	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/metrics"
 	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/internal/openapi"
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
 )
 
 func TestRoutes(t *testing.T) {
@@ -34,3 +47,130 @@ func TestRoutes(t *testing.T) {
 		t.Fatal("Expected a router to be returned")
 	}
 }
+
+func TestAdminRoutes(t *testing.T) {
+	app := &application{
+		config:  &config.Config{},
+		logger:  slog.Default(),
+		metrics: metrics.New(),
+	}
+
+	ts := newTestServer(t, app.adminRoutes())
+	defer ts.Close()
+
+	res := ts.get(t, "/metrics")
+	assert.Equal(t, res.status, http.StatusOK)
+	if !strings.Contains(res.body, "gh_secret_broker_secrets_created_total") {
+		t.Error("Expected broker metrics in /metrics output")
+	}
+}
+
+func TestAdminRoutes_Token(t *testing.T) {
+	app := &application{
+		config:   &config.Config{AdminToken: "0123456789abcdef0123456789abcdef"},
+		logger:   setupTestLogger(),
+		logLevel: new(slog.LevelVar),
+		metrics:  metrics.New(),
+	}
+	handler := app.adminRoutes()
+
+	setLevel := func(authorization string) int {
+		r := httptest.NewRequest(http.MethodPut, "/log-level", strings.NewReader(`{"level":"debug"}`))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, setLevel(""), http.StatusUnauthorized)
+	assert.Equal(t, setLevel("Bearer wrong"), http.StatusUnauthorized)
+	assert.Equal(t, app.logLevel.Level(), slog.LevelInfo)
+
+	assert.Equal(t, setLevel("Bearer 0123456789abcdef0123456789abcdef"), http.StatusOK)
+	assert.Equal(t, app.logLevel.Level(), slog.LevelDebug)
+}
+
+// apiRoutes returns the patterns of the /api routes registered in routes.go.
+func apiRoutes(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "routes.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var routes []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || (sel.Sel.Name != "Handle" && sel.Sel.Name != "HandleFunc") {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			return true
+		}
+		pattern, _ := strconv.Unquote(lit.Value)
+		if _, path, _ := strings.Cut(pattern, " "); strings.HasPrefix(path, "/api/") {
+			routes = append(routes, pattern)
+		}
+		return true
+	})
+	return routes
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	doc := openapi.MustLoad()
+	routes := apiRoutes(t)
+	if len(routes) == 0 {
+		t.Fatal("Expected to find the API routes in routes.go")
+	}
+
+	for _, route := range routes {
+		if doc.Operation(route) == nil {
+			t.Errorf("Route %q has no entry in internal/openapi/openapi.json", route)
+		}
+	}
+	for _, route := range doc.Routes() {
+		if !slices.Contains(routes, route) {
+			t.Errorf("internal/openapi/openapi.json documents %q, which isn't registered", route)
+		}
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	app := &application{
+		config:       &config.Config{SessionSecret: "test-secret"},
+		logger:       setupTestLogger(),
+		repositories: &mockRepositoryService{},
+	}
+	ts := newTestServer(t, app.routes(&oauth.Service{}))
+	defer ts.Close()
+
+	res := ts.get(t, "/api/openapi.json")
+	assert.Equal(t, res.status, http.StatusOK)
+	assert.Equal(t, strings.Contains(res.body, `"openapi": "3.0.3"`), true)
+
+	for body, want := range map[string]string{
+		`{"value": 42}`: "validation_failed",
+		`{"value": `:    "bad_request",
+		// Valid, so the request reaches the CSRF check
+		`{"value": "v"}`: "csrf_failed",
+	} {
+		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/repo/org/repo/secrets/API_KEY", strings.NewReader(body))
+		r, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p problem.Details
+		_ = json.NewDecoder(r.Body).Decode(&p)
+		_ = r.Body.Close()
+		if p.Code != want {
+			t.Errorf("%s: got code %q, want %q", body, p.Code, want)
+		}
+	}
+}
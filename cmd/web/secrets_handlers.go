@@ -5,13 +5,15 @@ import (
 	"log/slog"
 	"net/http"
 
-	"github.com/google/go-github/v80/github"
-	"golang.org/x/oauth2"
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
 )
 
 func (app *application) handleListSecrets(w http.ResponseWriter, r *http.Request) {
-	// Get Session & Verify User
-	user, ok := app.requireUser(w, r)
+	// Get Session or API Token & Verify User
+	p, ok := app.requirePrincipal(w, r)
 	if !ok {
 		return
 	}
@@ -21,40 +23,30 @@ func (app *application) handleListSecrets(w http.ResponseWriter, r *http.Request
 	repo := r.PathValue("repo")
 
 	if owner == "" || repo == "" {
-		http.Error(w, "Missing owner or repo", http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest, "Missing owner or repo")
 		return
 	}
 
-	// We do not work on userGhClient directly, but instead pass it to the repository service.
-	// In tests, we can mock the repository service and inject a mock client or just
-	// return a fixed value.
-	userGhClient, err := app.getGitHubClient(r.Context(), user.AccessToken)
+	denied, err := app.checkAccess(r.Context(), &p, owner, repo, apitoken.ActionRead)
 	if err != nil {
-		app.logger.Error("Failed to create GitHub client", slog.String("error", err.Error()))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "Failed to check permissions", slog.String("error", err.Error()))
+		app.errorResponse(w, r, err)
 		return
 	}
-	hasAccess, err := app.repositories.HasMaintainerAccess(r.Context(), userGhClient, owner, repo)
-
-	if err != nil {
-		app.logger.Error("Failed to check permissions", slog.String("error", err.Error()))
-		http.Error(w, "Permission check failed", http.StatusInternalServerError)
-		return
-	}
-	if !hasAccess {
-		app.logger.Warn("User attempted to access secrets without permission", slog.String("user", user.Email), slog.String("repo", owner+"/"+repo))
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	if denied != "" {
+		app.logger.WarnContext(r.Context(), "User attempted to access secrets without permission", slog.String("user", actorName(p.User)), slog.String("repo", owner+"/"+repo))
+		app.clientError(w, r, http.StatusForbidden, denied)
 		return
 	}
 
 	// Use Shared PAT Client (Only after verification)
-	githubClient := app.patClient
+	githubClient := app.pat()
 
 	// Call repository service
 	secrets, err := app.repositories.ListSecrets(r.Context(), githubClient, owner, repo)
 	if err != nil {
-		app.logger.Error("Failed to list secrets", slog.String("error", err.Error()))
-		http.Error(w, "Failed to list secrets", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "Failed to list secrets", slog.String("error", err.Error()))
+		app.errorResponse(w, r, err)
 		return
 	}
 
@@ -62,13 +54,13 @@ func (app *application) handleListSecrets(w http.ResponseWriter, r *http.Request
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(secrets); err != nil {
-		app.logger.Error("Failed to encode secrets", slog.String("error", err.Error()))
+		app.logger.ErrorContext(r.Context(), "Failed to encode secrets", slog.String("error", err.Error()))
 	}
 }
 
 func (app *application) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
-	// Get Session & Verify User
-	user, ok := app.requireUser(w, r)
+	// Get Session or API Token & Verify User
+	p, ok := app.requirePrincipal(w, r)
 	if !ok {
 		return
 	}
@@ -79,45 +71,43 @@ func (app *application) handleDeleteSecret(w http.ResponseWriter, r *http.Reques
 	name := r.PathValue("name")
 
 	if owner == "" || repo == "" || name == "" {
-		http.Error(w, "Missing owner, repo, or secret name", http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest, "Missing owner, repo, or secret name")
 		return
 	}
 
-	userTs := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: user.AccessToken},
-	)
-	userTc := oauth2.NewClient(r.Context(), userTs)
-	userGhClient := github.NewClient(userTc)
-	hasAccess, err := app.repositories.HasMaintainerAccess(r.Context(), userGhClient, owner, repo)
-
+	denied, err := app.checkAccess(r.Context(), &p, owner, repo, apitoken.ActionDelete)
 	if err != nil {
-		app.logger.Error("Failed to check permissions", slog.String("error", err.Error()))
-		http.Error(w, "Permission check failed", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "Failed to check permissions", slog.String("error", err.Error()))
+		app.errorResponse(w, r, err)
 		return
 	}
-	if !hasAccess {
-		app.logger.Warn("User attempted to delete secret without permission", slog.String("user", user.Email), slog.String("repo", owner+"/"+repo))
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	if denied != "" {
+		app.logger.WarnContext(r.Context(), "User attempted to delete secret without permission", slog.String("user", actorName(p.User)), slog.String("repo", owner+"/"+repo))
+		app.recordSecretEvent(r, p, "secret.delete", owner, repo, name, audit.OutcomeDenied)
+		app.clientError(w, r, http.StatusForbidden, denied)
 		return
 	}
 
 	// Use Shared PAT Client (Only after verification)
-	githubClient := app.patClient
+	githubClient := app.pat()
 
 	// Call repository service
 	err = app.repositories.DeleteSecret(r.Context(), githubClient, owner, repo, name)
 	if err != nil {
-		app.logger.Error("Failed to delete secret", slog.String("error", err.Error()))
-		http.Error(w, "Failed to delete secret", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "Failed to delete secret", slog.String("error", err.Error()))
+		app.recordSecretEvent(r, p, "secret.delete", owner, repo, name, audit.OutcomeFailure)
+		app.errorResponse(w, r, err)
 		return
 	}
+	app.metrics.SecretDeleted()
+	app.recordSecretEvent(r, p, "secret.delete", owner, repo, name, audit.OutcomeSuccess)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (app *application) handleCreateSecret(w http.ResponseWriter, r *http.Request) {
-	// Get Session & Verify User
-	user, ok := app.requireUser(w, r)
+	// Get Session or API Token & Verify User
+	p, ok := app.requirePrincipal(w, r)
 	if !ok {
 		return
 	}
@@ -129,52 +119,69 @@ func (app *application) handleCreateSecret(w http.ResponseWriter, r *http.Reques
 	name := r.PathValue("name")
 
 	if owner == "" || repo == "" || name == "" {
-		http.Error(w, "Missing owner, repo, or secret name", http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest, "Missing owner, repo, or secret name")
 		return
 	}
 
 	// Parse Body
-	var req struct {
-		Value string `json:"value"`
-	}
+	var req client.SetSecretRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 	if req.Value == "" {
-		http.Error(w, "Secret value is required", http.StatusBadRequest)
+		app.clientError(w, r, http.StatusUnprocessableEntity, "Secret value is required")
 		return
 	}
-
-	// We do not work on userGhClient directly, but instead pass it to the repository service.
-	userGhClient, err := app.getGitHubClient(r.Context(), user.AccessToken)
-	if err != nil {
-		app.logger.Error("Failed to create GitHub client", slog.String("error", err.Error()))
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	if err := repository.ValidateSecretName(name); err != nil {
+		app.errorResponse(w, r, err)
 		return
 	}
 
-	hasAccess, err := app.repositories.HasMaintainerAccess(r.Context(), userGhClient, owner, repo)
+	denied, err := app.checkAccess(r.Context(), &p, owner, repo, apitoken.ActionWrite)
 	if err != nil {
-		app.logger.Error("Failed to check permissions", slog.String("error", err.Error()))
-		http.Error(w, "Permission check failed", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "Failed to check permissions", slog.String("error", err.Error()))
+		app.errorResponse(w, r, err)
 		return
 	}
-	if !hasAccess {
-		app.logger.Warn("User attempted to create secret without permission", slog.String("user", user.Email), slog.String("repo", owner+"/"+repo))
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	if denied != "" {
+		app.logger.WarnContext(r.Context(), "User attempted to create secret without permission", slog.String("user", actorName(p.User)), slog.String("repo", owner+"/"+repo))
+		app.recordSecretEvent(r, p, "secret.create", owner, repo, name, audit.OutcomeDenied)
+		app.clientError(w, r, http.StatusForbidden, denied)
 		return
 	}
 
 	// Use Shared PAT Client (Only after verification)
-	githubClient := app.patClient
+	githubClient := app.pat()
 
 	err = app.repositories.CreateOrUpdateSecret(r.Context(), githubClient, owner, repo, name, req.Value)
 	if err != nil {
-		app.logger.Error("Failed to create secret", slog.String("error", err.Error()))
-		http.Error(w, "Failed to create secret", http.StatusInternalServerError)
+		app.logger.ErrorContext(r.Context(), "Failed to create secret", slog.String("error", err.Error()))
+		app.recordSecretEvent(r, p, "secret.create", owner, repo, name, audit.OutcomeFailure)
+		app.errorResponse(w, r, err)
 		return
 	}
+	app.metrics.SecretCreated()
+	app.recordSecretEvent(r, p, "secret.create", owner, repo, name, audit.OutcomeSuccess)
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// recordSecretEvent writes an audit event for an attempted secret write.
+// Writes made with an API token name the token.
+func (app *application) recordSecretEvent(r *http.Request, p principal, action, owner, repo, name, outcome string) {
+	e := audit.Event{
+		Actor:      actorName(p.User),
+		Action:     action,
+		Repository: owner + "/" + repo,
+		Secret:     name,
+		Outcome:    outcome,
+	}
+	if p.Token != nil {
+		e.Detail = "api_token=" + p.Token.ID
+	}
+	if p.BreakGlass != nil {
+		e.Detail = "break_glass=" + p.BreakGlass.ID
+	}
+	app.audit.Record(r.Context(), e)
+}
@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
 	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
 	"github.com/google/go-github/v80/github"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
@@ -31,7 +39,7 @@ func TestHandleListSecrets(t *testing.T) {
 		app := &application{
 			logger:       setupTestLogger(),
 			repositories: mockService,
-			config:       &config.Config{GithubOrg: "test-org"},
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
 		}
 
 		// Mock Session
@@ -69,6 +77,56 @@ func TestHandleListSecrets(t *testing.T) {
 		}
 	})
 
+	t.Run("Rate Limited", func(t *testing.T) {
+		reset := time.Now().Add(90 * time.Second)
+		mockService := &mockRepositoryService{
+			HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+				return true, nil
+			},
+			ListSecretsFunc: func(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+				return nil, &repository.Error{Code: repository.CodeRateLimited, Message: "GitHub rate limit exceeded", RetryAt: reset}
+			},
+		}
+
+		app := &application{
+			logger:       setupTestLogger(),
+			repositories: mockService,
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
+		}
+
+		store := sessions.NewCookieStore([]byte("secret"))
+		gothic.Store = store
+
+		req, _ := http.NewRequest("GET", "/api/repo/TargetOrg/repo-1/secrets", nil)
+		req.SetPathValue("owner", "TargetOrg")
+		req.SetPathValue("repo", "repo-1")
+		w := httptest.NewRecorder()
+
+		session, _ := store.Get(req, "session")
+		session.Values["user"] = goth.User{AccessToken: "valid-token"}
+		_ = session.Save(req, w)
+		req.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+
+		app.handleListSecrets(w, req)
+
+		res := w.Result()
+		defer func() { _ = res.Body.Close() }()
+
+		assert.Equal(t, res.StatusCode, http.StatusTooManyRequests)
+		retryAfter, _ := strconv.Atoi(res.Header.Get("Retry-After"))
+		if retryAfter < 89 || retryAfter > 91 {
+			t.Errorf("expected Retry-After of about 90s, got %q", res.Header.Get("Retry-After"))
+		}
+
+		var body problem.Details
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode body: %v", err)
+		}
+		if body.Code != "rate_limited" || body.RetryAt == nil || body.RetryAt.Before(reset.Add(-time.Second)) {
+			t.Errorf("unexpected body %+v", body)
+		}
+	})
+
 	t.Run("Access Denied", func(t *testing.T) {
 		// Mock Service returns false for access
 		mockService := &mockRepositoryService{
@@ -80,7 +138,7 @@ func TestHandleListSecrets(t *testing.T) {
 		app := &application{
 			logger:       setupTestLogger(),
 			repositories: mockService,
-			config:       &config.Config{GithubOrg: "test-org"},
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
 		}
 
 		store := sessions.NewCookieStore([]byte("secret"))
@@ -104,6 +162,42 @@ func TestHandleListSecrets(t *testing.T) {
 
 		assert.Equal(t, res.StatusCode, http.StatusForbidden)
 	})
+
+	t.Run("OIDC user", func(t *testing.T) {
+		// Users who logged in with OIDC have no GitHub token; their linked
+		// GitHub login is checked with the PAT
+		var checkedLogin string
+		mockService := &mockRepositoryService{
+			HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+				t.Error("Expected OIDC users not to use a user client")
+				return false, nil
+			},
+			UserHasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+				checkedLogin = login
+				return true, nil
+			},
+			ListSecretsFunc: func(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+				return []string{"SECRET_1"}, nil
+			},
+		}
+
+		app := &application{
+			logger:       setupTestLogger(),
+			repositories: mockService,
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
+		}
+
+		req, _ := http.NewRequest("GET", "/api/repo/TargetOrg/repo-1/secrets", nil)
+		req.SetPathValue("owner", "TargetOrg")
+		req.SetPathValue("repo", "repo-1")
+		withSession(req, goth.User{UserID: "42", NickName: "octocat", Provider: oauth.OIDCProviderName})
+		w := httptest.NewRecorder()
+
+		app.handleListSecrets(w, req)
+
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, checkedLogin, "octocat")
+	})
 }
 
 func TestHandleDeleteSecret(t *testing.T) {
@@ -123,7 +217,7 @@ func TestHandleDeleteSecret(t *testing.T) {
 		app := &application{
 			logger:       setupTestLogger(),
 			repositories: mockService,
-			config:       &config.Config{GithubOrg: "test-org"},
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
 		}
 
 		store := sessions.NewCookieStore([]byte("secret"))
@@ -165,15 +259,17 @@ func TestHandleCreateSecret(t *testing.T) {
 			},
 		}
 
+		var auditBuf bytes.Buffer
 		app := &application{
 			logger:       setupTestLogger(),
 			repositories: mockService,
-			config:       &config.Config{GithubOrg: "test-org"},
+			config:       &config.Config{GithubOrgs: []string{"test-org"}},
+			audit:        audit.New(slog.NewJSONHandler(&auditBuf, nil)),
 		}
 
 		store := sessions.NewCookieStore([]byte("secret"))
 		gothic.Store = store
-		user := goth.User{AccessToken: "valid-token"}
+		user := goth.User{AccessToken: "valid-token", NickName: "octocat"}
 
 		reqBody := strings.NewReader(`{"value": "secret-value"}`)
 		req, _ := http.NewRequest("PUT", "/api/repo/TargetOrg/repo-1/secrets/NEW_SECRET", reqBody)
@@ -194,5 +290,15 @@ func TestHandleCreateSecret(t *testing.T) {
 		defer func() { _ = res.Body.Close() }()
 
 		assert.Equal(t, res.StatusCode, http.StatusNoContent)
+
+		var event map[string]any
+		if err := json.Unmarshal(auditBuf.Bytes(), &event); err != nil {
+			t.Fatalf("Failed to decode audit event: %v", err)
+		}
+		assert.Equal(t, event["actor"], any("octocat"))
+		assert.Equal(t, event["action"], any("secret.create"))
+		assert.Equal(t, event["repository"], any("TargetOrg/repo-1"))
+		assert.Equal(t, event["secret_name"], any("NEW_SECRET"))
+		assert.Equal(t, event["outcome"], any(audit.OutcomeSuccess))
 	})
 }
@@ -8,12 +8,25 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
 )
 
 func setupTestLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stdout, nil))
 }
 
+// sessionCookie returns the Cookie header of a session of user in store.
+func sessionCookie(store sessions.Store, user goth.User) string {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	session, _ := store.Get(req, "session")
+	session.Values["user"] = user
+	_ = session.Save(req, w)
+	return w.Header().Get("Set-Cookie")
+}
+
 type testServer struct {
 	*httptest.Server
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+)
+
+// handleListTokens handles GET /api/tokens. It lists the user's API tokens.
+func (app *application) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	tokens := app.tokens.List(user.UserID)
+	res := make([]client.APIToken, len(tokens))
+	for i, tok := range tokens {
+		res[i] = apiToken(tok)
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode tokens", slog.String("error", err.Error()))
+	}
+}
+
+// handleCreateToken handles POST /api/tokens. Tokens can only be created with
+// a session, so a leaked token can't be used to mint new ones.
+func (app *application) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req client.CreateTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.clientError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	cfg := app.cfg()
+	for _, scope := range req.Repositories {
+		owner, _, _ := strings.Cut(scope, "/")
+		if _, ok := cfg.LookupOrg(owner); !ok {
+			app.clientError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("Repository %q is not in one of the configured organizations", scope))
+			return
+		}
+	}
+
+	tok, secret, err := app.tokens.Create(user.UserID, user.NickName, apitoken.Request{
+		Name:         req.Name,
+		Repositories: req.Repositories,
+		Actions:      apitokenActions(req.Actions),
+		Lifetime:     time.Duration(req.ExpiresInDays) * 24 * time.Hour,
+	})
+	if errors.Is(err, apitoken.ErrValidation) {
+		app.clientError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.recordTokenEvent(r, actorName(user), "token.create", tok)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(client.CreatedToken{Token: apiToken(tok), Secret: secret}); err != nil {
+		app.logger.ErrorContext(r.Context(), "Failed to encode token", slog.String("error", err.Error()))
+	}
+}
+
+// handleRevokeToken handles DELETE /api/tokens/{id}.
+func (app *application) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	tok, err := app.tokens.Revoke(user.UserID, r.PathValue("id"))
+	if errors.Is(err, apitoken.ErrNotFound) {
+		app.clientError(w, r, http.StatusNotFound, "API token not found")
+		return
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.recordTokenEvent(r, actorName(user), "token.revoke", tok)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recordTokenEvent writes an audit event for a token that was created or
+// revoked. The token is identified by its ID, never by the token itself.
+func (app *application) recordTokenEvent(r *http.Request, actor, action string, tok apitoken.Token) {
+	app.audit.Record(r.Context(), audit.Event{
+		Actor:   actor,
+		Action:  action,
+		Outcome: audit.OutcomeSuccess,
+		Detail:  fmt.Sprintf("api_token=%s name=%q repositories=%s actions=%s", tok.ID, tok.Name, strings.Join(tok.Repositories, ","), joinActions(tok.Actions)),
+	})
+}
+
+// apiToken returns the wire format of tok.
+func apiToken(tok apitoken.Token) client.APIToken {
+	actions := make([]string, len(tok.Actions))
+	for i, a := range tok.Actions {
+		actions[i] = string(a)
+	}
+	return client.APIToken{
+		ID:           tok.ID,
+		Name:         tok.Name,
+		UserID:       tok.UserID,
+		Login:        tok.Login,
+		Repositories: tok.Repositories,
+		Actions:      actions,
+		CreatedAt:    tok.CreatedAt,
+		ExpiresAt:    tok.ExpiresAt,
+		LastUsedAt:   tok.LastUsedAt,
+	}
+}
+
+// apitokenActions converts requested actions; unknown ones are rejected when
+// the token is created.
+func apitokenActions(actions []string) []apitoken.Action {
+	res := make([]apitoken.Action, len(actions))
+	for i, a := range actions {
+		res[i] = apitoken.Action(a)
+	}
+	return res
+}
+
+func joinActions(actions []apitoken.Action) string {
+	s := make([]string, len(actions))
+	for i, a := range actions {
+		s[i] = string(a)
+	}
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/apitoken"
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/oauth"
+	"github.com/RobinMaas95/gh-secret-broker/pkg/client"
+	"github.com/google/go-github/v80/github"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/gothic"
+)
+
+// withSession stores user in a fresh session and adds its cookie to req.
+func withSession(req *http.Request, user goth.User) {
+	store := sessions.NewCookieStore([]byte("secret"))
+	gothic.Store = store
+
+	w := httptest.NewRecorder()
+	session, _ := store.Get(req, "session")
+	session.Values["user"] = user
+	_ = session.Save(req, w)
+	req.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+}
+
+func TestHandleCreateToken(t *testing.T) {
+	user := goth.User{UserID: "42", NickName: "octocat", AccessToken: "valid-token"}
+
+	newApp := func() (*application, *bytes.Buffer) {
+		var auditBuf bytes.Buffer
+		tokens, _ := apitoken.NewStore("")
+		return &application{
+			logger: setupTestLogger(),
+			config: &config.Config{GithubOrgs: []string{"test-org"}},
+			audit:  audit.New(slog.NewJSONHandler(&auditBuf, nil)),
+			tokens: tokens,
+		}, &auditBuf
+	}
+
+	t.Run("Created", func(t *testing.T) {
+		app, auditBuf := newApp()
+		req, _ := http.NewRequest("POST", "/api/tokens", strings.NewReader(`{"name": "ci", "repositories": ["test-org/repo-1"], "actions": ["secrets:write"], "expires_in_days": 7}`))
+		withSession(req, user)
+		w := httptest.NewRecorder()
+
+		app.handleCreateToken(w, req)
+		assert.Equal(t, w.Code, http.StatusCreated)
+
+		var res client.CreatedToken
+		_ = json.NewDecoder(w.Body).Decode(&res)
+		assert.Equal(t, strings.HasPrefix(res.Secret, apitoken.Prefix), true)
+		assert.Equal(t, res.Token.Login, "octocat")
+		assert.Equal(t, len(app.tokens.List("42")), 1)
+
+		if strings.Contains(auditBuf.String(), res.Secret) {
+			t.Error("Expected the audit event not to contain the token")
+		}
+		assert.Equal(t, strings.Contains(auditBuf.String(), `"action":"token.create"`), true)
+	})
+
+	for name, body := range map[string]string{
+		"Foreign organization": `{"name": "ci", "repositories": ["other-org/repo"], "actions": ["secrets:write"]}`,
+		"Unknown action":       `{"name": "ci", "repositories": ["test-org/repo"], "actions": ["secrets:admin"]}`,
+		"Too long":             `{"name": "ci", "repositories": ["test-org/repo"], "actions": ["secrets:read"], "expires_in_days": 1000}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			app, _ := newApp()
+			req, _ := http.NewRequest("POST", "/api/tokens", strings.NewReader(body))
+			withSession(req, user)
+			w := httptest.NewRecorder()
+
+			app.handleCreateToken(w, req)
+			assert.Equal(t, w.Code, http.StatusUnprocessableEntity)
+			assert.Equal(t, len(app.tokens.List("42")), 0)
+		})
+	}
+}
+
+func TestAPITokenAuthentication(t *testing.T) {
+	var checkedLogin string
+	mockService := &mockRepositoryService{
+		UserHasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+			checkedLogin = login
+			return true, nil
+		},
+		HasMaintainerAccessFunc: func(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+			t.Error("Expected API token requests not to use a user client")
+			return false, nil
+		},
+		ListMaintainableRepositoriesFunc: func(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
+			owner := &github.User{Login: github.Ptr("test-org")}
+			return []*github.Repository{
+				{Name: github.Ptr("repo-1"), Owner: owner},
+				{Name: github.Ptr("repo-2"), Owner: owner},
+			}, nil
+		},
+	}
+
+	var auditBuf bytes.Buffer
+	tokens, _ := apitoken.NewStore("")
+	app := &application{
+		logger:       setupTestLogger(),
+		config:       &config.Config{SessionSecret: "test-secret", GithubOrgs: []string{"test-org"}},
+		repositories: mockService,
+		audit:        audit.New(slog.NewJSONHandler(&auditBuf, nil)),
+		tokens:       tokens,
+	}
+	tok, secret, err := tokens.Create("42", "octocat", apitoken.Request{
+		Name:         "ci",
+		Repositories: []string{"test-org/repo-1"},
+		Actions:      []apitoken.Action{apitoken.ActionWrite},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := newTestServer(t, app.routes(&oauth.Service{}))
+	defer ts.Close()
+
+	// No CSRF token is sent, requests with an API token don't need one
+	do := func(method, path, token string, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		return res
+	}
+
+	t.Run("Write within scope", func(t *testing.T) {
+		res := do("PUT", "/api/repo/test-org/repo-1/secrets/DEPLOY_KEY", secret, `{"value": "v"}`)
+		assert.Equal(t, res.StatusCode, http.StatusNoContent)
+		assert.Equal(t, checkedLogin, "octocat")
+
+		var event map[string]any
+		if err := json.Unmarshal(auditBuf.Bytes(), &event); err != nil {
+			t.Fatalf("Failed to decode audit event: %v", err)
+		}
+		assert.Equal(t, event["actor"], any("octocat"))
+		assert.Equal(t, event["detail"], any("api_token="+tok.ID))
+	})
+
+	t.Run("Repositories within scope", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", ts.URL+"/api/user/repos", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = res.Body.Close() }()
+		assert.Equal(t, res.StatusCode, http.StatusOK)
+
+		var groups []client.OrgRepositories
+		_ = json.NewDecoder(res.Body).Decode(&groups)
+		assert.Equal(t, len(groups), 1)
+		assert.Equal(t, len(groups[0].Repositories), 1)
+		assert.Equal(t, groups[0].Repositories[0].GetName(), "repo-1")
+	})
+
+	t.Run("Other repository", func(t *testing.T) {
+		res := do("PUT", "/api/repo/test-org/repo-2/secrets/DEPLOY_KEY", secret, `{"value": "v"}`)
+		assert.Equal(t, res.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("Action not granted", func(t *testing.T) {
+		res := do("DELETE", "/api/repo/test-org/repo-1/secrets/DEPLOY_KEY", secret, "")
+		assert.Equal(t, res.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("Session-only endpoint", func(t *testing.T) {
+		res := do("GET", "/api/tokens", secret, "")
+		assert.Equal(t, res.StatusCode, http.StatusForbidden)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		res := do("GET", "/api/repo/test-org/repo-1/secrets", apitoken.Prefix+"unknown", "")
+		assert.Equal(t, res.StatusCode, http.StatusUnauthorized)
+		assert.Equal(t, res.Header.Get("WWW-Authenticate"), `Bearer error="invalid_token"`)
+	})
+
+	t.Run("Revoked token", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", "/api/tokens/"+tok.ID, nil)
+		req.SetPathValue("id", tok.ID)
+		withSession(req, goth.User{UserID: "42", NickName: "octocat"})
+		w := httptest.NewRecorder()
+		app.handleRevokeToken(w, req)
+		assert.Equal(t, w.Code, http.StatusNoContent)
+
+		res := do("PUT", "/api/repo/test-org/repo-1/secrets/DEPLOY_KEY", secret, `{"value": "v"}`)
+		assert.Equal(t, res.StatusCode, http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/google/go-github/v80/github"
+)
+
+// maxWebhookPayload is the largest payload GitHub sends.
+const maxWebhookPayload = 25 << 20
+
+// cacheInvalidator is implemented by repository.CachedService. Without a
+// cache (CACHE_TTL=0) there is nothing to invalidate.
+type cacheInvalidator interface {
+	InvalidateRepo(owner, repo string)
+	InvalidateUser(user string)
+	InvalidateRepositoryLists()
+	InvalidateAccess()
+}
+
+// handleGitHubWebhook receives events for changes made directly in GitHub.
+// It drops the cache entries they affect and records them in the audit log.
+//
+// GitHub has no webhook for changes to Actions secrets; cached secret lists
+// are refreshed by their TTL (and revalidated with ETags) instead. Secret
+// scanning alerts are recorded.
+func (app *application) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	secret := app.cfg().GithubWebhookSecret
+	if secret == "" {
+		app.clientError(w, r, http.StatusNotFound, "Webhooks are not configured")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+	if err != nil {
+		app.clientError(w, r, http.StatusRequestEntityTooLarge, "Payload too large")
+		return
+	}
+	if !validWebhookSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+		app.logger.WarnContext(r.Context(), "Rejected webhook with invalid signature", slog.String("delivery", github.DeliveryID(r)))
+		app.clientError(w, r, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+
+	eventType := github.WebHookType(r)
+	event, err := github.ParseWebHook(eventType, body)
+	if err != nil {
+		// Unknown event types are not an error, we just don't handle them
+		app.logger.DebugContext(r.Context(), "Ignoring webhook", slog.String("event", eventType), slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), "Received webhook", slog.String("event", eventType), slog.String("delivery", github.DeliveryID(r)))
+	app.handleWebhookEvent(r, eventType, event)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) handleWebhookEvent(r *http.Request, eventType string, event any) {
+	cache, _ := app.repositories.(cacheInvalidator)
+	record := func(action string, sender *github.User, repo *github.Repository, detail string) {
+		app.audit.Record(r.Context(), audit.Event{
+			Actor:      "github:" + sender.GetLogin(),
+			Action:     "external." + eventType + "." + action,
+			Repository: repo.GetFullName(),
+			Outcome:    audit.OutcomeExternal,
+			Detail:     detail,
+		})
+	}
+
+	switch e := event.(type) {
+	case *github.RepositoryEvent:
+		repo := e.GetRepo()
+		detail := ""
+		if cache != nil {
+			cache.InvalidateRepositoryLists()
+			cache.InvalidateRepo(repo.GetOwner().GetLogin(), repo.GetName())
+		}
+		if from := e.GetChanges().GetRepo().GetName().GetFrom(); from != "" {
+			detail = "renamed from " + repo.GetOwner().GetLogin() + "/" + from
+			if cache != nil {
+				cache.InvalidateRepo(repo.GetOwner().GetLogin(), from)
+			}
+		}
+		if owner := e.GetChanges().GetOwner().GetOwnerInfo(); owner != nil {
+			from := owner.GetOrg().GetLogin()
+			if from == "" {
+				from = owner.GetUser().GetLogin()
+			}
+			detail = "transferred from " + from
+			if cache != nil {
+				cache.InvalidateRepo(from, repo.GetName())
+			}
+		}
+		record(e.GetAction(), e.GetSender(), repo, detail)
+
+	case *github.MemberEvent:
+		// A collaborator was added, removed or their permission changed
+		if cache != nil {
+			cache.InvalidateRepo(e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName())
+			if key := userCacheKey(e.GetMember()); key != "" {
+				cache.InvalidateUser(key)
+			}
+		}
+		record(e.GetAction(), e.GetSender(), e.GetRepo(), "member "+e.GetMember().GetLogin())
+
+	case *github.TeamEvent:
+		// Team permissions apply to every member, so drop all decisions
+		if cache != nil {
+			cache.InvalidateAccess()
+		}
+		record(e.GetAction(), e.GetSender(), e.GetRepo(), "team "+e.GetTeam().GetSlug())
+
+	case *github.MembershipEvent:
+		if key := userCacheKey(e.GetMember()); cache != nil && key != "" {
+			cache.InvalidateUser(key)
+		}
+		record(e.GetAction(), e.GetSender(), nil, "member "+e.GetMember().GetLogin()+" of team "+e.GetTeam().GetSlug())
+
+	case *github.SecretScanningAlertEvent:
+		record(e.GetAction(), e.GetSender(), e.GetRepo(), "secret scanning alert for "+e.GetAlert().GetSecretTypeDisplayName())
+	}
+}
+
+// userCacheKey returns the key the repository cache uses for a user: the
+// GitHub user ID, as stored in the session by goth.
+func userCacheKey(u *github.User) string {
+	if u.GetID() == 0 {
+		return ""
+	}
+	return strconv.FormatInt(u.GetID(), 10)
+}
+
+// validWebhookSignature checks the X-Hub-Signature-256 header, an HMAC-SHA256
+// of the body keyed with the webhook secret.
+func validWebhookSignature(secret string, body []byte, header string) bool {
+	sig, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
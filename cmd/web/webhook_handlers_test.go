@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+	"github.com/RobinMaas95/gh-secret-broker/internal/audit"
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+)
+
+// invalidationRecorder is a repository service that records which cache
+// entries a webhook dropped.
+type invalidationRecorder struct {
+	*mockRepositoryService
+	calls []string
+}
+
+func (r *invalidationRecorder) InvalidateRepo(owner, repo string) {
+	r.calls = append(r.calls, "repo "+owner+"/"+repo)
+}
+
+func (r *invalidationRecorder) InvalidateUser(user string) {
+	r.calls = append(r.calls, "user "+user)
+}
+
+func (r *invalidationRecorder) InvalidateRepositoryLists() {
+	r.calls = append(r.calls, "lists")
+}
+
+func (r *invalidationRecorder) InvalidateAccess() {
+	r.calls = append(r.calls, "access")
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHandleGitHubWebhook(t *testing.T) {
+	const secret = "webhook-secret"
+
+	newApp := func(secret string) (*application, *invalidationRecorder, *bytes.Buffer) {
+		var auditBuf bytes.Buffer
+		recorder := &invalidationRecorder{mockRepositoryService: &mockRepositoryService{}}
+		return &application{
+			logger:       setupTestLogger(),
+			config:       &config.Config{GithubWebhookSecret: secret},
+			repositories: recorder,
+			audit:        audit.New(slog.NewJSONHandler(&auditBuf, nil)),
+		}, recorder, &auditBuf
+	}
+
+	deliver := func(app *application, event string, body []byte, signature string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/webhooks/github", bytes.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-GitHub-Delivery", "delivery-1")
+		req.Header.Set("X-Hub-Signature-256", signature)
+		w := httptest.NewRecorder()
+		app.handleGitHubWebhook(w, req)
+		return w
+	}
+
+	t.Run("Repository renamed", func(t *testing.T) {
+		app, recorder, auditBuf := newApp(secret)
+		body := []byte(`{
+			"action": "renamed",
+			"changes": {"repository": {"name": {"from": "old-name"}}},
+			"repository": {"name": "new-name", "full_name": "test-org/new-name", "owner": {"login": "test-org"}},
+			"sender": {"login": "octocat"}
+		}`)
+
+		w := deliver(app, "repository", body, signWebhook(secret, body))
+		assert.Equal(t, w.Code, http.StatusNoContent)
+		assert.Equal(t, strings.Join(recorder.calls, ", "), "lists, repo test-org/new-name, repo test-org/old-name")
+
+		var event map[string]any
+		if err := json.Unmarshal(auditBuf.Bytes(), &event); err != nil {
+			t.Fatalf("Failed to decode audit event: %v", err)
+		}
+		assert.Equal(t, event["actor"], any("github:octocat"))
+		assert.Equal(t, event["action"], any("external.repository.renamed"))
+		assert.Equal(t, event["repository"], any("test-org/new-name"))
+		assert.Equal(t, event["outcome"], any(audit.OutcomeExternal))
+		assert.Equal(t, event["detail"], any("renamed from test-org/old-name"))
+	})
+
+	t.Run("Collaborator removed", func(t *testing.T) {
+		app, recorder, _ := newApp(secret)
+		body := []byte(`{
+			"action": "removed",
+			"member": {"login": "hubot", "id": 42},
+			"repository": {"name": "repo", "full_name": "test-org/repo", "owner": {"login": "test-org"}},
+			"sender": {"login": "octocat"}
+		}`)
+
+		w := deliver(app, "member", body, signWebhook(secret, body))
+		assert.Equal(t, w.Code, http.StatusNoContent)
+		assert.Equal(t, strings.Join(recorder.calls, ", "), "repo test-org/repo, user 42")
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		app, recorder, auditBuf := newApp(secret)
+		body := []byte(`{"zen": "Keep it logically awesome.", "hook_id": 1}`)
+
+		w := deliver(app, "ping", body, signWebhook(secret, body))
+		assert.Equal(t, w.Code, http.StatusNoContent)
+		assert.Equal(t, len(recorder.calls), 0)
+		assert.Equal(t, auditBuf.Len(), 0)
+	})
+
+	t.Run("Invalid signature", func(t *testing.T) {
+		app, recorder, _ := newApp(secret)
+		body := []byte(`{"action": "deleted", "repository": {"name": "repo", "owner": {"login": "test-org"}}}`)
+
+		for _, signature := range []string{"", "sha256=zz", signWebhook("wrong-secret", body)} {
+			w := deliver(app, "repository", body, signature)
+			assert.Equal(t, w.Code, http.StatusUnauthorized)
+		}
+		assert.Equal(t, len(recorder.calls), 0)
+	})
+
+	t.Run("Not configured", func(t *testing.T) {
+		app, _, _ := newApp("")
+		body := []byte(`{}`)
+
+		w := deliver(app, "ping", body, signWebhook("", body))
+		assert.Equal(t, w.Code, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,321 @@
+// Package apitoken implements personal API tokens, which let scripts and CI
+// jobs use the broker without a browser session.
+//
+// A token belongs to a user and is limited to a set of repositories and
+// actions. Only a SHA-256 hash of the token is stored; the token itself is
+// shown once, when it is created. Tokens expire and can be revoked.
+package apitoken
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/storeutil"
+)
+
+// Prefix starts every token, so leaked tokens are easy to recognise and to
+// scan for.
+const Prefix = "gsb_"
+
+const (
+	// DefaultLifetime is used when a token is created without a lifetime.
+	DefaultLifetime = 30 * 24 * time.Hour
+	// MaxLifetime is the longest a token can be valid.
+	MaxLifetime = 365 * 24 * time.Hour
+	// maxTokensPerUser bounds the store, tokens are cheap to create.
+	maxTokensPerUser = 50
+	// expiredRetention is how long expired tokens stay listed, so their
+	// owners can see why a job stopped working.
+	expiredRetention = 7 * 24 * time.Hour
+)
+
+// Action is something a token may do.
+type Action string
+
+const (
+	ActionRead   Action = "secrets:read"
+	ActionWrite  Action = "secrets:write"
+	ActionDelete Action = "secrets:delete"
+)
+
+// Actions lists the valid actions.
+var Actions = []Action{ActionRead, ActionWrite, ActionDelete}
+
+var (
+	// ErrInvalid is returned for tokens that are unknown, revoked or expired.
+	// The cases are not distinguished so a caller learns nothing from them.
+	ErrInvalid = errors.New("invalid API token")
+	// ErrNotFound is returned when revoking a token the user doesn't own.
+	ErrNotFound = errors.New("API token not found")
+	// ErrValidation wraps the problems of a create request.
+	ErrValidation = errors.New("invalid token request")
+)
+
+// Token describes an API token. It never contains the token itself.
+type Token struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	UserID string `json:"user_id"`
+	// Login is the GitHub login of the user, whose access to a repository is
+	// checked on every use.
+	Login string `json:"login"`
+	// Repositories are "owner/repo" names; "owner/*" matches every
+	// repository of owner.
+	Repositories []string   `json:"repositories"`
+	Actions      []Action   `json:"actions"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Allows reports whether the token's scope covers action on owner/repo.
+// Whether the user may do it is a separate question.
+func (t Token) Allows(owner, repo string, action Action) bool {
+	return slices.Contains(t.Actions, action) && t.InScope(owner, repo)
+}
+
+// InScope reports whether owner/repo is one of the token's repositories.
+func (t Token) InScope(owner, repo string) bool {
+	for _, scope := range t.Repositories {
+		o, r, _ := strings.Cut(scope, "/")
+		if strings.EqualFold(o, owner) && (r == "*" || strings.EqualFold(r, repo)) {
+			return true
+		}
+	}
+	return false
+}
+
+// InOrg reports whether any of the token's repositories belongs to org.
+func (t Token) InOrg(org string) bool {
+	for _, scope := range t.Repositories {
+		if o, _, _ := strings.Cut(scope, "/"); strings.EqualFold(o, org) {
+			return true
+		}
+	}
+	return false
+}
+
+// Request holds the properties of a new token.
+type Request struct {
+	Name         string
+	Repositories []string
+	Actions      []Action
+	// Lifetime defaults to DefaultLifetime.
+	Lifetime time.Duration
+}
+
+// Validate checks req. Create validates every request itself; Validate is for
+// rejecting one before it gets there.
+func (req Request) Validate() error {
+	var problems []string
+	if strings.TrimSpace(req.Name) == "" || len(req.Name) > 100 {
+		problems = append(problems, "name must be between 1 and 100 characters")
+	}
+	if len(req.Repositories) == 0 {
+		problems = append(problems, "at least one repository is required")
+	}
+	for _, scope := range req.Repositories {
+		owner, repo, ok := strings.Cut(scope, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			problems = append(problems, fmt.Sprintf("repository %q must be \"owner/repo\" or \"owner/*\"", scope))
+		}
+	}
+	if len(req.Actions) == 0 {
+		problems = append(problems, "at least one action is required")
+	}
+	for _, a := range req.Actions {
+		if !slices.Contains(Actions, a) {
+			problems = append(problems, fmt.Sprintf("unknown action %q", a))
+		}
+	}
+	if req.Lifetime < 0 || req.Lifetime > MaxLifetime {
+		problems = append(problems, fmt.Sprintf("lifetime must be at most %d days", MaxLifetime/(24*time.Hour)))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrValidation, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// storedToken is a token as it is kept in the store.
+type storedToken struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+// Store keeps the tokens. If it has a path, the tokens are saved to that file
+// as JSON on every change; otherwise they are lost on restart.
+type Store struct {
+	path string
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]*storedToken // by hash
+}
+
+// NewStore returns a store backed by the file at path, loading the tokens it
+// already holds. An empty path keeps the tokens in memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now, tokens: make(map[string]*storedToken)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading API tokens: %w", err)
+	}
+	var tokens []*storedToken
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parsing API tokens from %s: %w", path, err)
+	}
+	for _, t := range tokens {
+		s.tokens[t.Hash] = t
+	}
+	return s, nil
+}
+
+// Create issues a token for the user. It returns the token's description and
+// the token itself, which is not stored and can't be recovered later.
+func (s *Store) Create(userID, login string, req Request) (Token, string, error) {
+	if err := req.Validate(); err != nil {
+		return Token{}, "", err
+	}
+	if req.Lifetime == 0 {
+		req.Lifetime = DefaultLifetime
+	}
+
+	secret := Prefix + storeutil.RandomString(32)
+	now := s.now().UTC()
+	t := &storedToken{
+		Token: Token{
+			ID:           storeutil.RandomString(9),
+			Name:         strings.TrimSpace(req.Name),
+			UserID:       userID,
+			Login:        login,
+			Repositories: req.Repositories,
+			Actions:      req.Actions,
+			CreatedAt:    now,
+			ExpiresAt:    now.Add(req.Lifetime),
+		},
+		Hash: hash(secret),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropExpired()
+	if len(s.list(userID)) >= maxTokensPerUser {
+		return Token{}, "", fmt.Errorf("%w: at most %d tokens per user, revoke unused ones first", ErrValidation, maxTokensPerUser)
+	}
+	s.tokens[t.Hash] = t
+	if err := s.save(); err != nil {
+		delete(s.tokens, t.Hash)
+		return Token{}, "", err
+	}
+	return t.Token, secret, nil
+}
+
+// Authenticate returns the token with the given secret and records its use.
+func (s *Store) Authenticate(secret string) (Token, error) {
+	if !strings.HasPrefix(secret, Prefix) {
+		return Token{}, ErrInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[hash(secret)]
+	if !ok {
+		return Token{}, ErrInvalid
+	}
+	now := s.now().UTC()
+	if !now.Before(t.ExpiresAt) {
+		return Token{}, ErrInvalid
+	}
+	// Saved with the next change, writing the file on every request is not
+	// worth it for this
+	t.LastUsedAt = &now
+	return t.Token, nil
+}
+
+// List returns the tokens of the user, oldest first.
+func (s *Store) List(userID string) []Token {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list(userID)
+}
+
+func (s *Store) list(userID string) []Token {
+	tokens := []Token{}
+	for _, t := range s.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t.Token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b Token) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return tokens
+}
+
+// Revoke deletes the user's token with the given ID.
+func (s *Store) Revoke(userID, id string) (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for h, t := range s.tokens {
+		if t.ID == id && t.UserID == userID {
+			delete(s.tokens, h)
+			if err := s.save(); err != nil {
+				s.tokens[h] = t
+				return Token{}, err
+			}
+			return t.Token, nil
+		}
+	}
+	return Token{}, ErrNotFound
+}
+
+// dropExpired forgets tokens that expired more than expiredRetention ago.
+func (s *Store) dropExpired() {
+	now := s.now()
+	for h, t := range s.tokens {
+		if now.After(t.ExpiresAt.Add(expiredRetention)) {
+			delete(s.tokens, h)
+		}
+	}
+}
+
+// save writes the tokens to the store's file.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	tokens := make([]*storedToken, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := storeutil.WriteFile(s.path, data); err != nil {
+		return fmt.Errorf("saving API tokens: %w", err)
+	}
+	return nil
+}
+
+// hash returns the stored form of a token. Tokens are long random strings, so
+// a fast unsalted hash is enough; there is nothing to brute-force.
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
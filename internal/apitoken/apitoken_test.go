@@ -0,0 +1,175 @@
+package apitoken
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func validRequest() Request {
+	return Request{
+		Name:         "deploy",
+		Repositories: []string{"org/repo", "other-org/*"},
+		Actions:      []Action{ActionRead, ActionWrite},
+	}
+}
+
+func TestStore(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+
+	tok, secret, err := s.Create("42", "octocat", validRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(secret, Prefix) {
+		t.Errorf("expected the token to start with %q, got %q", Prefix, secret)
+	}
+	if !tok.ExpiresAt.Equal(now.Add(DefaultLifetime)) {
+		t.Errorf("expected the default lifetime, got expiry %s", tok.ExpiresAt)
+	}
+	for h := range s.tokens {
+		if strings.Contains(h, secret) || h == secret {
+			t.Error("expected the token to be stored hashed")
+		}
+	}
+
+	t.Run("Authenticate", func(t *testing.T) {
+		got, err := s.Authenticate(secret)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.ID != tok.ID || got.Login != "octocat" {
+			t.Errorf("expected token %s of octocat, got %s of %s", tok.ID, got.ID, got.Login)
+		}
+		if got.LastUsedAt == nil || !got.LastUsedAt.Equal(now) {
+			t.Errorf("expected the use to be recorded, got %v", got.LastUsedAt)
+		}
+
+		for _, bad := range []string{"", "ghp_abc", secret + "x", Prefix} {
+			if _, err := s.Authenticate(bad); !errors.Is(err, ErrInvalid) {
+				t.Errorf("%q: expected ErrInvalid, got %v", bad, err)
+			}
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		if tokens := s.List("42"); len(tokens) != 1 || tokens[0].ID != tok.ID {
+			t.Errorf("expected the user's token, got %v", tokens)
+		}
+		if tokens := s.List("43"); len(tokens) != 0 {
+			t.Errorf("expected no tokens of another user, got %v", tokens)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		now = now.Add(DefaultLifetime)
+		defer func() { now = now.Add(-DefaultLifetime) }()
+		if _, err := s.Authenticate(secret); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		if _, err := s.Revoke("43", tok.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected another user's revoke to fail with ErrNotFound, got %v", err)
+		}
+		if _, err := s.Revoke("42", tok.ID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := s.Authenticate(secret); !errors.Is(err, ErrInvalid) {
+			t.Errorf("expected a revoked token to be invalid, got %v", err)
+		}
+	})
+}
+
+func TestStore_Validation(t *testing.T) {
+	s, _ := NewStore("")
+
+	tests := map[string]func(r *Request){
+		"No name":           func(r *Request) { r.Name = " " },
+		"No repositories":   func(r *Request) { r.Repositories = nil },
+		"Bad repository":    func(r *Request) { r.Repositories = []string{"repo"} },
+		"Nested path":       func(r *Request) { r.Repositories = []string{"org/repo/x"} },
+		"No actions":        func(r *Request) { r.Actions = nil },
+		"Unknown action":    func(r *Request) { r.Actions = []Action{"secrets:admin"} },
+		"Too long":          func(r *Request) { r.Lifetime = MaxLifetime + time.Hour },
+		"Negative lifetime": func(r *Request) { r.Lifetime = -time.Hour },
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := validRequest()
+			modify(&req)
+			if _, _, err := s.Create("42", "octocat", req); !errors.Is(err, ErrValidation) {
+				t.Errorf("expected ErrValidation, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret, err := s.Create("42", "octocat", validRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), secret) {
+		t.Error("expected the file not to contain the token")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Authenticate(secret); err != nil {
+		t.Errorf("expected the token to survive a restart, got %v", err)
+	}
+}
+
+func TestToken_Allows(t *testing.T) {
+	tok := Token{
+		Repositories: []string{"org/repo", "other-org/*"},
+		Actions:      []Action{ActionRead},
+	}
+
+	tests := []struct {
+		owner, repo string
+		action      Action
+		want        bool
+	}{
+		{"org", "repo", ActionRead, true},
+		{"ORG", "Repo", ActionRead, true},
+		{"org", "repo", ActionWrite, false},
+		{"org", "other", ActionRead, false},
+		{"other-org", "anything", ActionRead, true},
+		{"third-org", "repo", ActionRead, false},
+	}
+	for _, tt := range tests {
+		if got := tok.Allows(tt.owner, tt.repo, tt.action); got != tt.want {
+			t.Errorf("Allows(%s, %s, %s) = %v, want %v", tt.owner, tt.repo, tt.action, got, tt.want)
+		}
+	}
+
+	if !tok.InOrg("Other-Org") || tok.InOrg("third-org") {
+		t.Error("expected InOrg to match the owners of the scope")
+	}
+}
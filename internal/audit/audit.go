@@ -0,0 +1,75 @@
+// Package audit records security-relevant actions, such as secret writes,
+// as structured events. Every event carries the request ID of the request
+// that caused it, so it can be correlated with the access and error logs.
+package audit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+)
+
+// Outcomes of an audited action.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+	// OutcomeExternal marks changes made directly in GitHub that the broker
+	// learned about, e.g. via a webhook.
+	OutcomeExternal = "external"
+)
+
+// Event describes one audited action.
+type Event struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	Repository string    `json:"repository,omitempty"`
+	Secret     string    `json:"secret,omitempty"`
+	Outcome    string    `json:"outcome"`
+	// Detail adds context that doesn't fit the other fields, e.g. the old
+	// name of a renamed repository.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Logger writes audit events. A nil *Logger discards all events.
+type Logger struct {
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// New creates an audit logger writing to h.
+func New(h slog.Handler) *Logger {
+	return &Logger{
+		logger: slog.New(h),
+		now:    time.Now,
+	}
+}
+
+// Record writes the event. Time and RequestID are filled in from the clock
+// and ctx if they are not set.
+func (l *Logger) Record(ctx context.Context, e Event) {
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = l.now()
+	}
+	if e.RequestID == "" {
+		e.RequestID = logging.RequestID(ctx)
+	}
+
+	l.logger.LogAttrs(ctx, slog.LevelInfo, "audit",
+		slog.Time("event_time", e.Time),
+		slog.String("request_id", e.RequestID),
+		slog.String("actor", e.Actor),
+		slog.String("action", e.Action),
+		slog.String("repository", e.Repository),
+		slog.String("secret_name", e.Secret),
+		slog.String("outcome", e.Outcome),
+		slog.String("detail", e.Detail),
+	)
+}
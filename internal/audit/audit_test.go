@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(slog.NewJSONHandler(&buf, nil))
+	fixed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	l.now = func() time.Time { return fixed }
+
+	ctx := logging.WithRequestID(context.Background(), "req-42")
+	l.Record(ctx, Event{
+		Actor:      "octocat",
+		Action:     "secret.create",
+		Repository: "org/repo",
+		Secret:     "TOKEN",
+		Outcome:    OutcomeSuccess,
+	})
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", buf.String(), err)
+	}
+
+	want := map[string]string{
+		"msg":         "audit",
+		"request_id":  "req-42",
+		"actor":       "octocat",
+		"action":      "secret.create",
+		"repository":  "org/repo",
+		"secret_name": "TOKEN",
+		"outcome":     "success",
+		"event_time":  "2026-01-02T03:04:05Z",
+	}
+	for key, val := range want {
+		if entry[key] != val {
+			t.Errorf("%s = %v, want %q", key, entry[key], val)
+		}
+	}
+}
+
+func TestRecord_NilLogger(t *testing.T) {
+	var l *Logger
+	l.Record(context.Background(), Event{Action: "secret.delete"})
+}
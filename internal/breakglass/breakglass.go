@@ -0,0 +1,267 @@
+// Package breakglass implements emergency access: during an incident, a user
+// can grant themselves time-boxed write access to one repository they don't
+// maintain, e.g. to replace a leaked secret.
+//
+// Every grant carries a written justification and a ticket reference. It
+// stays pending until an admin other than the user has reviewed it.
+package breakglass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/storeutil"
+)
+
+const (
+	// MinJustification is the shortest accepted justification, so "fix" or
+	// "incident" don't pass as one.
+	MinJustification = 20
+	maxJustification = 2000
+	maxTicket        = 200
+	// reviewedRetention is how long reviewed grants stay listed. Pending
+	// grants are kept until they are reviewed.
+	reviewedRetention = 90 * 24 * time.Hour
+)
+
+var (
+	// ErrNotFound is returned for unknown grant IDs.
+	ErrNotFound = errors.New("break-glass grant not found")
+	// ErrValidation wraps the problems of a request.
+	ErrValidation = errors.New("invalid break-glass request")
+	// ErrReviewed is returned when reviewing a grant a second time.
+	ErrReviewed = errors.New("break-glass grant is already reviewed")
+	// ErrOwnGrant is returned when a user reviews their own grant.
+	ErrOwnGrant = errors.New("break-glass grants must be reviewed by someone else")
+	// ErrTooMany is returned when a user already has as many active grants
+	// as they may.
+	ErrTooMany = errors.New("too many active break-glass grants")
+)
+
+// Grant is write access to one repository for a limited time.
+type Grant struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Login  string `json:"login"`
+	// Repository is "owner/repo".
+	Repository    string    `json:"repository"`
+	Justification string    `json:"justification"`
+	Ticket        string    `json:"ticket"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// Review is set once an admin reviewed the grant.
+	Review *Review `json:"review,omitempty"`
+}
+
+// Review is an admin's sign-off on a grant.
+type Review struct {
+	Reviewer string    `json:"reviewer"`
+	Note     string    `json:"note"`
+	At       time.Time `json:"at"`
+}
+
+// Covers reports whether the grant is for owner/repo.
+func (g Grant) Covers(owner, repo string) bool {
+	return strings.EqualFold(g.Repository, owner+"/"+repo)
+}
+
+// Request holds the properties of a new grant.
+type Request struct {
+	// Repository is "owner/repo".
+	Repository    string
+	Justification string
+	Ticket        string
+	Duration      time.Duration
+	// MaxActive caps the user's unexpired grants, including the new one. 0
+	// means no limit.
+	MaxActive int
+}
+
+func (req Request) validate() error {
+	var problems []string
+	owner, repo, ok := strings.Cut(req.Repository, "/")
+	if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") || repo == "*" {
+		problems = append(problems, fmt.Sprintf("repository %q must be \"owner/repo\"", req.Repository))
+	}
+	if n := len(strings.TrimSpace(req.Justification)); n < MinJustification || n > maxJustification {
+		problems = append(problems, fmt.Sprintf("justification must be between %d and %d characters", MinJustification, maxJustification))
+	}
+	if n := len(strings.TrimSpace(req.Ticket)); n == 0 || n > maxTicket {
+		problems = append(problems, fmt.Sprintf("ticket must be between 1 and %d characters", maxTicket))
+	}
+	if req.Duration <= 0 {
+		problems = append(problems, "duration must be positive")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrValidation, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Store keeps the grants. If it has a path, the grants are saved to that file
+// as JSON on every change; otherwise they are lost on restart.
+type Store struct {
+	path string
+	now  func() time.Time
+
+	mu     sync.Mutex
+	grants map[string]*Grant // by ID
+}
+
+// NewStore returns a store backed by the file at path, loading the grants it
+// already holds. An empty path keeps the grants in memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, now: time.Now, grants: make(map[string]*Grant)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading break-glass grants: %w", err)
+	}
+	var grants []*Grant
+	if err := json.Unmarshal(data, &grants); err != nil {
+		return nil, fmt.Errorf("parsing break-glass grants from %s: %w", path, err)
+	}
+	for _, g := range grants {
+		s.grants[g.ID] = g
+	}
+	return s, nil
+}
+
+// Create grants the user write access as described by req.
+func (s *Store) Create(userID, login string, req Request) (Grant, error) {
+	if err := req.validate(); err != nil {
+		return Grant{}, err
+	}
+
+	now := s.now().UTC()
+	g := &Grant{
+		ID:            storeutil.RandomString(9),
+		UserID:        userID,
+		Login:         login,
+		Repository:    req.Repository,
+		Justification: strings.TrimSpace(req.Justification),
+		Ticket:        strings.TrimSpace(req.Ticket),
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(req.Duration),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.MaxActive > 0 && s.countActive(userID) >= req.MaxActive {
+		return Grant{}, fmt.Errorf("%w: at most %d at a time", ErrTooMany, req.MaxActive)
+	}
+	s.dropReviewed()
+	s.grants[g.ID] = g
+	if err := s.save(); err != nil {
+		delete(s.grants, g.ID)
+		return Grant{}, err
+	}
+	return *g, nil
+}
+
+// Active returns the user's unexpired grant for owner/repo.
+func (s *Store) Active(userID, owner, repo string) (Grant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for _, g := range s.grants {
+		if g.UserID == userID && g.Covers(owner, repo) && now.Before(g.ExpiresAt) {
+			return *g, true
+		}
+	}
+	return Grant{}, false
+}
+
+// countActive returns the number of the user's unexpired grants.
+func (s *Store) countActive(userID string) int {
+	n := 0
+	now := s.now()
+	for _, g := range s.grants {
+		if g.UserID == userID && now.Before(g.ExpiresAt) {
+			n++
+		}
+	}
+	return n
+}
+
+// List returns the grants, newest first. With pending set, only grants that
+// still need a review are returned.
+func (s *Store) List(pending bool) []Grant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grants := []Grant{}
+	for _, g := range s.grants {
+		if !pending || g.Review == nil {
+			grants = append(grants, *g)
+		}
+	}
+	slices.SortFunc(grants, func(a, b Grant) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return grants
+}
+
+// Review signs off the grant with the given ID. The grant's own user can't
+// review it. A review doesn't end the grant early.
+func (s *Store) Review(id, reviewerID, reviewer, note string) (Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.grants[id]
+	if !ok {
+		return Grant{}, ErrNotFound
+	}
+	if g.Review != nil {
+		return Grant{}, ErrReviewed
+	}
+	if g.UserID == reviewerID {
+		return Grant{}, ErrOwnGrant
+	}
+
+	g.Review = &Review{Reviewer: reviewer, Note: strings.TrimSpace(note), At: s.now().UTC()}
+	if err := s.save(); err != nil {
+		g.Review = nil
+		return Grant{}, err
+	}
+	return *g, nil
+}
+
+// dropReviewed forgets grants that were reviewed more than
+// reviewedRetention ago; the audit log keeps the record.
+func (s *Store) dropReviewed() {
+	now := s.now()
+	for id, g := range s.grants {
+		if g.Review != nil && now.After(g.Review.At.Add(reviewedRetention)) {
+			delete(s.grants, id)
+		}
+	}
+}
+
+// save writes the grants to the store's file.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	grants := make([]*Grant, 0, len(s.grants))
+	for _, g := range s.grants {
+		grants = append(grants, g)
+	}
+	data, err := json.MarshalIndent(grants, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := storeutil.WriteFile(s.path, data); err != nil {
+		return fmt.Errorf("saving break-glass grants: %w", err)
+	}
+	return nil
+}
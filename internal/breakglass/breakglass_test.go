@@ -0,0 +1,146 @@
+package breakglass
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func validRequest() Request {
+	return Request{
+		Repository:    "org/repo",
+		Justification: "Deploy key leaked in a public gist, replacing it",
+		Ticket:        "INC-1234",
+		Duration:      time.Hour,
+	}
+}
+
+func TestStore(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+
+	g, err := s.Create("42", "octocat", validRequest())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !g.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the grant to expire in an hour, got %s", g.ExpiresAt)
+	}
+
+	t.Run("Active", func(t *testing.T) {
+		if _, ok := s.Active("42", "ORG", "Repo"); !ok {
+			t.Error("expected the grant to be active")
+		}
+		if _, ok := s.Active("42", "org", "other"); ok {
+			t.Error("expected the grant to cover only its repository")
+		}
+		if _, ok := s.Active("7", "org", "repo"); ok {
+			t.Error("expected the grant to cover only its user")
+		}
+
+		s.now = func() time.Time { return now.Add(time.Hour) }
+		defer func() { s.now = func() time.Time { return now } }()
+		if _, ok := s.Active("42", "org", "repo"); ok {
+			t.Error("expected the grant to expire")
+		}
+	})
+
+	t.Run("Review", func(t *testing.T) {
+		if len(s.List(true)) != 1 {
+			t.Fatal("expected the grant to be pending")
+		}
+		if _, err := s.Review(g.ID, "42", "octocat", "looks fine"); !errors.Is(err, ErrOwnGrant) {
+			t.Errorf("expected ErrOwnGrant, got %v", err)
+		}
+		reviewed, err := s.Review(g.ID, "1", "admin", "Rotated, confirmed in INC-1234")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reviewed.Review == nil || reviewed.Review.Reviewer != "admin" {
+			t.Errorf("expected the review to be recorded, got %+v", reviewed.Review)
+		}
+		if _, err := s.Review(g.ID, "1", "admin", ""); !errors.Is(err, ErrReviewed) {
+			t.Errorf("expected ErrReviewed, got %v", err)
+		}
+		if _, err := s.Review("unknown", "1", "admin", ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		if len(s.List(true)) != 0 || len(s.List(false)) != 1 {
+			t.Error("expected the grant to be listed as reviewed only")
+		}
+	})
+}
+
+func TestStore_MaxActive(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s, err := NewStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return now }
+
+	req := validRequest()
+	req.MaxActive = 2
+	for range 2 {
+		if _, err := s.Create("42", "octocat", req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := s.Create("42", "octocat", req); !errors.Is(err, ErrTooMany) {
+		t.Errorf("expected ErrTooMany, got %v", err)
+	}
+	if _, err := s.Create("7", "hubot", req); err != nil {
+		t.Errorf("expected the limit to be per user, got %v", err)
+	}
+
+	// Expired grants don't count
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	if _, err := s.Create("42", "octocat", req); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	tests := map[string]func(r *Request){
+		"Wildcard":            func(r *Request) { r.Repository = "org/*" },
+		"No repository":       func(r *Request) { r.Repository = "org" },
+		"Short justification": func(r *Request) { r.Justification = "leak" },
+		"No ticket":           func(r *Request) { r.Ticket = "  " },
+		"No duration":         func(r *Request) { r.Duration = 0 },
+	}
+	s, _ := NewStore("")
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := validRequest()
+			modify(&req)
+			if _, err := s.Create("42", "octocat", req); !errors.Is(err, ErrValidation) {
+				t.Errorf("expected ErrValidation, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "break-glass.json")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := s.Create("42", "octocat", validRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Active("42", "org", "repo"); !ok {
+		t.Errorf("expected grant %s to survive a restart", g.ID)
+	}
+}
@@ -3,22 +3,80 @@ package config
 import (
 	"crypto/rand"
 	"encoding/base64"
-	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
 	"strings"
+	"time"
 )
 
+// maxBreakGlassDuration caps break-glass grants; longer access should go
+// through the repository's maintainers.
+const maxBreakGlassDuration = 24 * time.Hour
+
+// Config holds the application configuration.
+//
+// Values are layered: defaults, then the optional YAML config file, then
+// environment variables. Command-line flags are applied on top by the caller.
+// The yaml and env tags name the key in the config file and the environment
+// variable for each field. Fields tagged with secret can additionally be read
+// from a file, Docker/Kubernetes-secret style, via <ENV>_FILE or <key>_file.
 type Config struct {
-	Addr                string
-	BaseURL             string
-	Environment         string // "development" or "production"
-	SessionSecret       string
-	GithubClientID      string
-	GithubClientSecret  string
-	GithubOrg           string
-	GithubPAT           string
-	GithubEnterpriseURL string
+	Addr                string              `yaml:"addr" env:"ADDR"`
+	AdminAddr           string              `yaml:"admin_addr" env:"ADMIN_ADDR"`                 // Listener for /metrics, empty disables it
+	AdminToken          string              `yaml:"admin_token" env:"ADMIN_TOKEN" secret:"true"` // Bearer token for the admin listener, required off loopback
+	BaseURL             string              `yaml:"base_url" env:"BASE_URL"`
+	Environment         string              `yaml:"environment" env:"ENVIRONMENT"`       // "development" or "production"
+	LogFormat           string              `yaml:"log_format" env:"LOG_FORMAT"`         // "text" or "json"
+	LogLevel            string              `yaml:"log_level" env:"LOG_LEVEL"`           // "debug", "info", "warn" or "error"
+	AuditLogFile        string              `yaml:"audit_log_file" env:"AUDIT_LOG_FILE"` // JSON lines, defaults to the application log
+	APITokenFile        string              `yaml:"api_token_file" env:"API_TOKEN_FILE"` // Hashed API tokens, kept in memory when empty
+	SessionSecret       string              `yaml:"session_secret" env:"SESSION_SECRET" secret:"true"`
+	GithubClientID      string              `yaml:"github_client_id" env:"GITHUB_CLIENT_ID"`
+	GithubClientSecret  string              `yaml:"github_client_secret" env:"GITHUB_CLIENT_SECRET" secret:"true"`
+	GithubOrgs          []string            `yaml:"github_orgs" env:"GITHUB_ORG"`
+	GithubPAT           string              `yaml:"github_pat" env:"GITHUB_PAT" secret:"true"`
+	GithubWebhookSecret string              `yaml:"github_webhook_secret" env:"GITHUB_WEBHOOK_SECRET" secret:"true"` // POST /webhooks/github is disabled when empty
+	GithubEnterpriseURL string              `yaml:"github_enterprise_url" env:"GITHUB_ENTERPRISE_URL"`
+	GithubDeviceFlow    bool                `yaml:"github_device_flow" env:"GITHUB_DEVICE_FLOW"` // POST /api/device/* are disabled when false
+	LoginProviders      []string            `yaml:"login_providers" env:"LOGIN_PROVIDERS"`       // "github" and "oidc", in the order the login page lists them
+	OIDCIssuer          string              `yaml:"oidc_issuer" env:"OIDC_ISSUER"`
+	OIDCClientID        string              `yaml:"oidc_client_id" env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret    string              `yaml:"oidc_client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	OIDCName            string              `yaml:"oidc_name" env:"OIDC_NAME"`                 // shown on the login page
+	OIDCGithubClaim     string              `yaml:"oidc_github_claim" env:"OIDC_GITHUB_CLAIM"` // ID token claim holding the user's GitHub login
+	AdminUsers          []string            `yaml:"admin_users" env:"ADMIN_USERS"`             // GitHub logins
+	AdminTeams          []string            `yaml:"admin_teams" env:"ADMIN_TEAMS"`             // "org/team-slug"
+	AuditorUsers        []string            `yaml:"auditor_users" env:"AUDITOR_USERS"`
+	AuditorTeams        []string            `yaml:"auditor_teams" env:"AUDITOR_TEAMS"`
+	OrgOwnersAreAdmins  bool                `yaml:"org_owners_are_admins" env:"ORG_OWNERS_ARE_ADMINS"`         // owners of the github_orgs
+	AuditRateLimit      int                 `yaml:"audit_rate_limit" env:"AUDIT_RATE_LIMIT"`                   // audit requests per minute and auditor, 0 disables
+	BreakGlass          bool                `yaml:"break_glass" env:"BREAK_GLASS"`                             // POST /api/break-glass is disabled when false
+	BreakGlassFile      string              `yaml:"break_glass_file" env:"BREAK_GLASS_FILE"`                   // Grants, kept in memory when empty
+	BreakGlassDuration  time.Duration       `yaml:"break_glass_duration" env:"BREAK_GLASS_DURATION"`           // How long a grant lasts
+	BreakGlassMaxActive int                 `yaml:"break_glass_max_active" env:"BREAK_GLASS_MAX_ACTIVE"`       // Unexpired grants per user
+	NotifyWebhookURL    string              `yaml:"notify_webhook_url" env:"NOTIFY_WEBHOOK_URL" secret:"true"` // High-priority notifications, disabled when empty
+	SecretTemplates     map[string][]string `yaml:"secret_templates"`                                          // Template name to required secret names; config file only
+	RepositoryTemplates map[string]string   `yaml:"repository_templates"`                                      // "owner/repo" to template name; config file only
+	SecretManifestPath  string              `yaml:"secret_manifest_path" env:"SECRET_MANIFEST_PATH"`           // Manifest file read from repositories without a configured template
+	CacheTTL            time.Duration       `yaml:"cache_ttl" env:"CACHE_TTL"`                                 // repository lists and access decisions, 0 disables
+	OTLPEndpoint        string              `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT"`           // Tracing is disabled when empty
+	TLSCertFile         string              `yaml:"tls_cert_file" env:"TLS_CERT_FILE"`
+	TLSKeyFile          string              `yaml:"tls_key_file" env:"TLS_KEY_FILE"`
+	TLSClientCAFile     string              `yaml:"tls_client_ca_file" env:"TLS_CLIENT_CA_FILE"`
+	TLSClientAuth       string              `yaml:"tls_client_auth" env:"TLS_CLIENT_AUTH"` // "none", "optional" or "require"
+}
+
+// ValidationError lists every problem found while loading the configuration,
+// so that all of them can be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
 }
 
 // IsProduction returns true if running in production environment
@@ -26,55 +84,210 @@ func (c *Config) IsProduction() bool {
 	return c.Environment == "production"
 }
 
-func Load() (*Config, error) {
-	var errs []error
-	getEnv := func(key string) string {
-		val := os.Getenv(key)
+// TLSEnabled returns true if the server terminates TLS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
+}
+
+// OIDCEnabled returns true if users can log in with the OIDC provider
+func (c *Config) OIDCEnabled() bool {
+	return slices.Contains(c.LoginProviders, "oidc")
+}
+
+// LookupOrg reports whether the given organization is one of the configured
+// organizations and returns it in its configured spelling.
+// GitHub logins are case-insensitive, so is the comparison.
+func (c *Config) LookupOrg(org string) (string, bool) {
+	for _, o := range c.GithubOrgs {
+		if strings.EqualFold(o, org) {
+			return o, true
+		}
+	}
+	return "", false
+}
+
+// RepositoryTemplate returns the secret template configured for owner/repo.
+// Like GitHub, the lookup ignores case.
+func (c *Config) RepositoryTemplate(owner, repo string) (string, bool) {
+	for r, template := range c.RepositoryTemplates {
+		if strings.EqualFold(r, owner+"/"+repo) {
+			return template, true
+		}
+	}
+	return "", false
+}
+
+// Level returns the configured log level. An empty level means info.
+func (c *Config) Level() slog.Level {
+	level, _ := ParseLevel(c.LogLevel)
+	return level
+}
+
+// ParseLevel parses a log level name (debug, info, warn, error), ignoring case.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf(`must be one of "debug", "info", "warn" or "error", got %q`, name)
+}
+
+// Load builds the configuration from the config file at path (optional, may be
+// empty) and the environment. All problems are collected and returned together
+// as a *ValidationError.
+func Load(path string) (*Config, error) {
+	config := &Config{
+		Addr:                ":4000",
+		AdminAddr:           "127.0.0.1:9090",
+		Environment:         "development",
+		LogFormat:           "text",
+		LogLevel:            "info",
+		LoginProviders:      []string{"github"},
+		OIDCName:            "SSO",
+		OIDCGithubClaim:     "github_login",
+		AuditRateLimit:      60,
+		BreakGlassDuration:  time.Hour,
+		BreakGlassMaxActive: 2,
+		SecretManifestPath:  ".github/secret-broker.yml",
+		CacheTTL:            time.Minute,
+		TLSClientAuth:       "none",
+	}
+
+	var problems []string
+	if path != "" {
+		problems = append(problems, config.loadFile(path)...)
+	}
+	problems = append(problems, config.loadEnv()...)
+	problems = append(problems, config.validate()...)
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	// Session secret is required in production, generated in development
+	if config.SessionSecret == "" {
+		config.SessionSecret = generateRandomSecret()
+	}
+
+	return config, nil
+}
+
+// isLoopback reports whether the listen address addr only accepts local
+// connections. An empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c *Config) validate() []string {
+	var problems []string
+	require := func(val, env, key string) {
 		if val == "" {
-			errs = append(errs, fmt.Errorf("environment variable %s is not set", key))
+			problems = append(problems, fmt.Sprintf("%s (%s) is not set", env, key))
 		}
-		return val
 	}
 
-	// Get environment, default to development
-	env := os.Getenv("ENVIRONMENT")
-	if env == "" {
-		env = "development"
+	if !slices.Contains([]string{"text", "json"}, c.LogFormat) {
+		problems = append(problems, fmt.Sprintf(`LOG_FORMAT must be one of "text" or "json", got %q`, c.LogFormat))
+	}
+	if c.CacheTTL < 0 {
+		problems = append(problems, "CACHE_TTL must not be negative")
+	}
+	if _, err := ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL: %v", err))
 	}
 
-	// Get session secret - required in production, generated in development
-	sessionSecret := os.Getenv("SESSION_SECRET")
-	if sessionSecret == "" {
-		if env == "production" {
-			errs = append(errs, fmt.Errorf("SESSION_SECRET is required in production"))
-		} else {
-			// Generate a random secret for development
-			sessionSecret = generateRandomSecret()
+	if c.SessionSecret == "" {
+		if c.IsProduction() {
+			problems = append(problems, "SESSION_SECRET is required in production")
 		}
-	} else if len(sessionSecret) < 32 {
-		errs = append(errs, fmt.Errorf("SESSION_SECRET must be at least 32 characters"))
+	} else if len(c.SessionSecret) < 32 {
+		problems = append(problems, "SESSION_SECRET must be at least 32 characters")
 	}
 
-	config := &Config{
-		BaseURL:             os.Getenv("BASE_URL"), // Optional
-		Environment:         env,
-		SessionSecret:       sessionSecret,
-		GithubClientID:      getEnv("GITHUB_CLIENT_ID"),
-		GithubClientSecret:  getEnv("GITHUB_CLIENT_SECRET"),
-		GithubOrg:           getEnv("GITHUB_ORG"),
-		GithubPAT:           getEnv("GITHUB_PAT"),
-		GithubEnterpriseURL: os.Getenv("GITHUB_ENTERPRISE_URL"), // Optional
-	}
-
-	if len(errs) > 0 {
-		errMsgs := make([]string, len(errs))
-		for i, e := range errs {
-			errMsgs[i] = e.Error()
+	if c.AdminAddr != "" && c.AdminToken == "" && !isLoopback(c.AdminAddr) {
+		problems = append(problems, "ADMIN_TOKEN is required when ADMIN_ADDR is not a loopback address")
+	} else if c.AdminToken != "" && len(c.AdminToken) < 32 {
+		problems = append(problems, "ADMIN_TOKEN must be at least 32 characters")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problems = append(problems, "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if !slices.Contains([]string{"none", "optional", "require"}, c.TLSClientAuth) {
+		problems = append(problems, fmt.Sprintf(`TLS_CLIENT_AUTH must be one of "none", "optional" or "require", got %q`, c.TLSClientAuth))
+	} else if c.TLSClientAuth != "none" && c.TLSClientCAFile == "" {
+		problems = append(problems, "TLS_CLIENT_CA_FILE is required when TLS_CLIENT_AUTH is enabled")
+	} else if c.TLSClientAuth == "none" && c.TLSClientCAFile != "" {
+		problems = append(problems, `TLS_CLIENT_CA_FILE is set but TLS_CLIENT_AUTH is "none"`)
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		problems = append(problems, "TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	// The OAuth app is only needed to log in with GitHub
+	if slices.Contains(c.LoginProviders, "github") || c.GithubDeviceFlow {
+		require(c.GithubClientID, "GITHUB_CLIENT_ID", "github_client_id")
+		require(c.GithubClientSecret, "GITHUB_CLIENT_SECRET", "github_client_secret")
+	}
+	require(strings.Join(c.GithubOrgs, ","), "GITHUB_ORG", "github_orgs")
+	require(c.GithubPAT, "GITHUB_PAT", "github_pat")
+
+	for _, team := range slices.Concat(c.AdminTeams, c.AuditorTeams) {
+		org, slug, ok := strings.Cut(team, "/")
+		if !ok || org == "" || slug == "" || strings.Contains(slug, "/") {
+			problems = append(problems, fmt.Sprintf(`ADMIN_TEAMS and AUDITOR_TEAMS must contain "org/team-slug" entries, got %q`, team))
+		}
+	}
+	if c.AuditRateLimit < 0 {
+		problems = append(problems, fmt.Sprintf("AUDIT_RATE_LIMIT must not be negative, got %d", c.AuditRateLimit))
+	}
+	if c.BreakGlass && (c.BreakGlassDuration <= 0 || c.BreakGlassDuration > maxBreakGlassDuration) {
+		problems = append(problems, fmt.Sprintf("BREAK_GLASS_DURATION must be between 1s and %s, got %s", maxBreakGlassDuration, c.BreakGlassDuration))
+	}
+	if c.BreakGlass && c.BreakGlassMaxActive < 1 {
+		problems = append(problems, fmt.Sprintf("BREAK_GLASS_MAX_ACTIVE must be at least 1, got %d", c.BreakGlassMaxActive))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.SecretTemplates)) {
+		if name == "" || len(c.SecretTemplates[name]) == 0 {
+			problems = append(problems, fmt.Sprintf("secret_templates: template %q must list at least one secret", name))
+		}
+	}
+	for _, repo := range slices.Sorted(maps.Keys(c.RepositoryTemplates)) {
+		template := c.RepositoryTemplates[repo]
+		owner, name, ok := strings.Cut(repo, "/")
+		if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+			problems = append(problems, fmt.Sprintf(`repository_templates must map "owner/repo" to a template, got %q`, repo))
+		} else if _, ok := c.SecretTemplates[template]; !ok {
+			problems = append(problems, fmt.Sprintf("repository_templates: %s uses unknown template %q", repo, template))
 		}
-		return nil, errors.New(strings.Join(errMsgs, "; "))
+	}
+	for _, p := range c.LoginProviders {
+		if !slices.Contains([]string{"github", "oidc"}, p) {
+			problems = append(problems, fmt.Sprintf(`LOGIN_PROVIDERS must only contain "github" or "oidc", got %q`, p))
+		}
+	}
+	if c.OIDCEnabled() {
+		require(c.OIDCIssuer, "OIDC_ISSUER", "oidc_issuer")
+		require(c.OIDCClientID, "OIDC_CLIENT_ID", "oidc_client_id")
+		require(c.OIDCClientSecret, "OIDC_CLIENT_SECRET", "oidc_client_secret")
+		require(c.OIDCGithubClaim, "OIDC_GITHUB_CLAIM", "oidc_github_claim")
 	}
 
-	return config, nil
+	return problems
 }
 
 // generateRandomSecret generates a cryptographically secure random secret
@@ -85,3 +298,14 @@ func generateRandomSecret() string {
 	}
 	return base64.StdEncoding.EncodeToString(bytes)
 }
+
+// splitList splits a comma-separated value into its trimmed, non-empty parts.
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"log/slog"
 	"os"
 	"strings"
 	"testing"
@@ -116,7 +117,7 @@ func TestConfig_Load(t *testing.T) {
 				_ = os.Setenv(key, val) //nolint:errcheck
 			}
 
-			got, err := Load()
+			got, err := Load("")
 
 			if tt.wantErr {
 				if err == nil {
@@ -178,3 +179,206 @@ func TestConfig_IsProduction(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_LoadMultipleOrgs(t *testing.T) {
+	t.Setenv("GITHUB_CLIENT_ID", "test-client-id")
+	t.Setenv("GITHUB_CLIENT_SECRET", "test-client-secret")
+	t.Setenv("GITHUB_PAT", "test-pat")
+	t.Setenv("GITHUB_ORG", "org-a, org-b,,org-c ")
+
+	got, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+
+	want := []string{"org-a", "org-b", "org-c"}
+	if strings.Join(got.GithubOrgs, ",") != strings.Join(want, ",") {
+		t.Errorf("GithubOrgs = %v, want %v", got.GithubOrgs, want)
+	}
+}
+
+func TestConfig_LookupOrg(t *testing.T) {
+	cfg := &Config{GithubOrgs: []string{"Org-A", "org-b"}}
+
+	tests := []struct {
+		org    string
+		want   string
+		wantOk bool
+	}{
+		{"Org-A", "Org-A", true},
+		{"org-a", "Org-A", true},
+		{"ORG-B", "org-b", true},
+		{"org-c", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.org, func(t *testing.T) {
+			got, ok := cfg.LookupOrg(tt.org)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("LookupOrg(%q) = %q, %v, want %q, %v", tt.org, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateAdmin(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		errContains string
+	}{
+		{name: "Disabled"},
+		{name: "Loopback", cfg: Config{AdminAddr: "127.0.0.1:9090"}},
+		{name: "Localhost", cfg: Config{AdminAddr: "localhost:9090"}},
+		{name: "IPv6 loopback", cfg: Config{AdminAddr: "[::1]:9090"}},
+		{name: "Public with token", cfg: Config{AdminAddr: ":9090", AdminToken: "0123456789abcdef0123456789abcdef"}},
+		{name: "All interfaces", cfg: Config{AdminAddr: ":9090"}, errContains: "ADMIN_TOKEN is required"},
+		{name: "Public address", cfg: Config{AdminAddr: "10.0.0.5:9090"}, errContains: "ADMIN_TOKEN is required"},
+		{name: "Short token", cfg: Config{AdminAddr: ":9090", AdminToken: "short"}, errContains: "at least 32 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.LogFormat = "text"
+			tt.cfg.TLSClientAuth = "none"
+			tt.cfg.GithubClientID = "id"
+			tt.cfg.GithubClientSecret = "secret"
+			tt.cfg.GithubOrgs = []string{"org"}
+			tt.cfg.GithubPAT = "pat"
+
+			problems := strings.Join(tt.cfg.validate(), "; ")
+			if tt.errContains == "" {
+				if problems != "" {
+					t.Errorf("unexpected problems: %s", problems)
+				}
+				return
+			}
+			if !strings.Contains(problems, tt.errContains) {
+				t.Errorf("problems = %q, want %q", problems, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateTLS(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         Config
+		errContains string
+	}{
+		{name: "Disabled", cfg: Config{TLSClientAuth: "none"}},
+		{name: "Cert and key", cfg: Config{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TLSClientAuth: "none"}},
+		{name: "Mutual TLS", cfg: Config{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TLSClientCAFile: "ca.crt", TLSClientAuth: "require"}},
+		{name: "Cert without key", cfg: Config{TLSCertFile: "tls.crt", TLSClientAuth: "none"}, errContains: "must be set together"},
+		{name: "Unknown client auth", cfg: Config{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TLSClientAuth: "maybe"}, errContains: "TLS_CLIENT_AUTH must be one of"},
+		{name: "Client auth without CA", cfg: Config{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key", TLSClientAuth: "optional"}, errContains: "TLS_CLIENT_CA_FILE is required"},
+		{name: "CA without TLS", cfg: Config{TLSClientCAFile: "ca.crt", TLSClientAuth: "optional"}, errContains: "requires TLS_CERT_FILE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.LogFormat = "text"
+			tt.cfg.GithubClientID = "id"
+			tt.cfg.GithubClientSecret = "secret"
+			tt.cfg.GithubOrgs = []string{"org"}
+			tt.cfg.GithubPAT = "pat"
+
+			problems := strings.Join(tt.cfg.validate(), "; ")
+			if tt.errContains == "" {
+				if problems != "" {
+					t.Errorf("unexpected problems: %s", problems)
+				}
+				return
+			}
+			if !strings.Contains(problems, tt.errContains) {
+				t.Errorf("problems = %q, want %q", problems, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateLoginProviders(t *testing.T) {
+	oidc := func(c *Config) {
+		c.LoginProviders = []string{"oidc"}
+		c.OIDCIssuer = "https://sso.example.com"
+		c.OIDCClientID = "broker"
+		c.OIDCClientSecret = "secret"
+		c.OIDCGithubClaim = "github_login"
+	}
+	tests := []struct {
+		name        string
+		modify      func(c *Config)
+		errContains string
+	}{
+		{name: "GitHub", modify: func(c *Config) {}},
+		{name: "OIDC without OAuth app", modify: func(c *Config) {
+			oidc(c)
+			c.GithubClientID, c.GithubClientSecret = "", ""
+		}},
+		{name: "GitHub without OAuth app", modify: func(c *Config) { c.GithubClientID = "" }, errContains: "GITHUB_CLIENT_ID"},
+		{name: "Unknown provider", modify: func(c *Config) { c.LoginProviders = []string{"gitlab"} }, errContains: `got "gitlab"`},
+		{name: "OIDC without issuer", modify: func(c *Config) {
+			oidc(c)
+			c.OIDCIssuer = ""
+		}, errContains: "OIDC_ISSUER"},
+		{name: "Role teams", modify: func(c *Config) {
+			c.AdminTeams = []string{"org/platform"}
+			c.AuditorTeams = []string{"org/security"}
+		}},
+		{name: "Team without org", modify: func(c *Config) { c.AdminTeams = []string{"platform"} }, errContains: `got "platform"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				LogFormat:          "text",
+				TLSClientAuth:      "none",
+				LoginProviders:     []string{"github"},
+				GithubClientID:     "id",
+				GithubClientSecret: "secret",
+				GithubOrgs:         []string{"org"},
+				GithubPAT:          "pat",
+			}
+			tt.modify(&cfg)
+
+			problems := strings.Join(cfg.validate(), "; ")
+			if tt.errContains == "" {
+				if problems != "" {
+					t.Errorf("unexpected problems: %s", problems)
+				}
+				return
+			}
+			if !strings.Contains(problems, tt.errContains) {
+				t.Errorf("problems = %q, want %q", problems, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestConfig_ParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    slog.Level
+		wantErr bool
+	}{
+		{name: "debug", want: slog.LevelDebug},
+		{name: "INFO", want: slog.LevelInfo},
+		{name: "", want: slog.LevelInfo},
+		{name: "warn", want: slog.LevelWarn},
+		{name: "error", want: slog.LevelError},
+		{name: "verbose", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLevel(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseLevel(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
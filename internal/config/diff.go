@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// redacted replaces the values of secret fields in a Change.
+const redacted = "[redacted]"
+
+// Change describes a single field that differs between two configurations.
+// Values of secret fields are redacted, only the fact that they changed is kept.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.Old, c.New)
+}
+
+// Diff returns the fields that differ between old and new, identified by their
+// config file key.
+func Diff(old, new *Config) []Change {
+	oldFields, newFields := old.fields(), new.fields()
+
+	var changes []Change
+	for i, of := range oldFields {
+		nf := newFields[i]
+		if reflect.DeepEqual(of.value.Interface(), nf.value.Interface()) {
+			continue
+		}
+
+		change := Change{Field: of.key, Old: redacted, New: redacted}
+		if !of.secret {
+			change.Old = fmt.Sprint(of.value.Interface())
+			change.New = fmt.Sprint(nf.value.Interface())
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// Pin sets the fields of c with the given config file keys to their values
+// in from, e.g. to keep the fields that only take effect on restart when
+// reloading. Unknown keys are an error, so a misspelled key doesn't silently
+// leave its field unpinned.
+func (c *Config) Pin(from *Config, keys []string) error {
+	fromFields := from.fields()
+	pinned := 0
+	for i, f := range c.fields() {
+		if slices.Contains(keys, f.key) {
+			f.value.Set(fromFields[i].value)
+			pinned++
+		}
+	}
+	if pinned != len(keys) {
+		known := make([]string, 0, len(fromFields))
+		for _, f := range fromFields {
+			known = append(known, f.key)
+		}
+		for _, key := range keys {
+			if !slices.Contains(known, key) {
+				return fmt.Errorf("unknown config key %q", key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := &Config{
+		Addr:       ":4000",
+		GithubOrgs: []string{"org-a"},
+		GithubPAT:  "old-pat",
+	}
+	new := &Config{
+		Addr:       ":4000",
+		GithubOrgs: []string{"org-a", "org-b"},
+		GithubPAT:  "new-pat",
+	}
+
+	changes := Diff(old, new)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d: %v", len(changes), changes)
+	}
+
+	if changes[0].Field != "github_orgs" || changes[0].Old != "[org-a]" || changes[0].New != "[org-a org-b]" {
+		t.Errorf("unexpected change %v", changes[0])
+	}
+
+	if changes[1].Field != "github_pat" {
+		t.Errorf("expected github_pat change, got %v", changes[1])
+	}
+	if changes[1].Old != "[redacted]" || changes[1].New != "[redacted]" {
+		t.Errorf("secret values must be redacted, got %v", changes[1])
+	}
+}
+
+func TestDiff_NoChanges(t *testing.T) {
+	cfg := &Config{Addr: ":4000", GithubPAT: "pat"}
+	if changes := Diff(cfg, &Config{Addr: ":4000", GithubPAT: "pat"}); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
+
+func TestPin(t *testing.T) {
+	old := &Config{Addr: ":4000", LoginProviders: []string{"github"}, GithubOrgs: []string{"org-a"}}
+	new := &Config{Addr: ":9999", LoginProviders: []string{"oidc"}, GithubOrgs: []string{"org-b"}}
+
+	if err := new.Pin(old, []string{"addr", "login_providers"}); err != nil {
+		t.Fatal(err)
+	}
+	changes := Diff(old, new)
+	if len(changes) != 1 || changes[0].Field != "github_orgs" {
+		t.Errorf("expected only github_orgs to change, got %v", changes)
+	}
+
+	if err := new.Pin(old, []string{"adr"}); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileSuffix marks a key or environment variable whose value is the path of a
+// file containing the actual value of a secret field.
+const fileSuffix = "_file"
+
+// field describes one configurable Config field together with its names in
+// the config file and the environment.
+type field struct {
+	value  reflect.Value
+	key    string
+	env    string
+	secret bool
+}
+
+// fields returns the configurable fields of c, in declaration order.
+func (c *Config) fields() []field {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	var fields []field
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		key := sf.Tag.Get("yaml")
+		if key == "" || key == "-" {
+			continue
+		}
+		fields = append(fields, field{
+			value:  v.Field(i),
+			key:    key,
+			env:    sf.Tag.Get("env"),
+			secret: sf.Tag.Get("secret") == "true",
+		})
+	}
+	return fields
+}
+
+// loadFile overlays the values of the YAML config file at path onto c.
+// Unknown keys are reported as problems instead of being silently ignored.
+func (c *Config) loadFile(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []string{fmt.Sprintf("config file: %v", err)}
+	}
+
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return []string{fmt.Sprintf("config file %s: %v", path, err)}
+	}
+	if len(doc.Content) == 0 {
+		return nil // empty file
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return []string{fmt.Sprintf("config file %s: top level must be a mapping", path)}
+	}
+
+	byKey := make(map[string]field)
+	for _, f := range c.fields() {
+		byKey[f.key] = f
+	}
+
+	var problems []string
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		keyNode, valNode := root.Content[i], root.Content[i+1]
+		key := keyNode.Value
+
+		if f, ok := byKey[key]; ok {
+			if err := valNode.Decode(f.value.Addr().Interface()); err != nil {
+				problems = append(problems, fmt.Sprintf("config file %s: %s (line %d): %v", path, key, keyNode.Line, err))
+			}
+			continue
+		}
+
+		if f, ok := byKey[strings.TrimSuffix(key, fileSuffix)]; ok && f.secret && strings.HasSuffix(key, fileSuffix) {
+			if _, ok := fileKeys(root)[f.key]; ok {
+				problems = append(problems, fmt.Sprintf("config file %s: only one of %s and %s may be set", path, f.key, key))
+				continue
+			}
+			val, err := readSecretFile(valNode.Value)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("config file %s: %s: %v", path, key, err))
+				continue
+			}
+			f.value.SetString(val)
+			continue
+		}
+
+		problems = append(problems, fmt.Sprintf("config file %s: unknown key %q (line %d)", path, key, keyNode.Line))
+	}
+
+	return problems
+}
+
+// loadEnv overlays the environment variables onto c. Empty variables are
+// treated as unset, so they never clear a value from the config file.
+func (c *Config) loadEnv() []string {
+	var problems []string
+	for _, f := range c.fields() {
+		if f.env == "" {
+			continue
+		}
+
+		val := os.Getenv(f.env)
+		if f.secret {
+			fileEnv := f.env + strings.ToUpper(fileSuffix)
+			if path := os.Getenv(fileEnv); path != "" {
+				if val != "" {
+					problems = append(problems, fmt.Sprintf("only one of %s and %s may be set", f.env, fileEnv))
+					continue
+				}
+				var err error
+				if val, err = readSecretFile(path); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: %v", fileEnv, err))
+					continue
+				}
+			}
+		}
+		if val == "" {
+			continue
+		}
+
+		if err := setFromString(f.value, val); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f.env, err))
+		}
+	}
+	return problems
+}
+
+// fileKeys returns the set of keys present in a YAML mapping node.
+func fileKeys(mapping *yaml.Node) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for i := 0; i < len(mapping.Content); i += 2 {
+		keys[mapping.Content[i].Value] = struct{}{}
+	}
+	return keys
+}
+
+// readSecretFile reads a secret from a file. The trailing newline most
+// editors and `echo` add is stripped.
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// setFromString parses an environment variable value into the field.
+func setFromString(v reflect.Value, val string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		v.Set(reflect.ValueOf(splitList(val)))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearEnv unsets every environment variable Config reads for the duration
+// of the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, f := range (&Config{}).fields() {
+		for _, key := range []string{f.env, f.env + "_FILE"} {
+			if key == "" || key == "_FILE" {
+				continue
+			}
+			t.Setenv(key, "")
+		}
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_FileThenEnv(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.yaml", `
+addr: ":8080"
+log_format: json
+github_client_id: file-client-id
+github_client_secret: file-client-secret
+github_orgs:
+  - org-a
+  - org-b
+github_pat: file-pat
+`)
+	// Environment overrides the file
+	t.Setenv("GITHUB_CLIENT_ID", "env-client-id")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+
+	if cfg.Addr != ":8080" {
+		t.Errorf("Addr = %q, want %q", cfg.Addr, ":8080")
+	}
+	if cfg.LogFormat != "json" {
+		t.Errorf("LogFormat = %q, want %q", cfg.LogFormat, "json")
+	}
+	if cfg.GithubClientID != "env-client-id" {
+		t.Errorf("GithubClientID = %q, want %q", cfg.GithubClientID, "env-client-id")
+	}
+	if cfg.GithubClientSecret != "file-client-secret" {
+		t.Errorf("GithubClientSecret = %q, want %q", cfg.GithubClientSecret, "file-client-secret")
+	}
+	if strings.Join(cfg.GithubOrgs, ",") != "org-a,org-b" {
+		t.Errorf("GithubOrgs = %v, want [org-a org-b]", cfg.GithubOrgs)
+	}
+	if cfg.Environment != "development" {
+		t.Errorf("Environment = %q, want default %q", cfg.Environment, "development")
+	}
+}
+
+func TestLoad_SecretFiles(t *testing.T) {
+	clearEnv(t)
+	patFile := writeFile(t, "pat", "pat-from-file\n")
+	clientSecretFile := writeFile(t, "client-secret", "client-secret-from-file")
+	sessionSecretFile := writeFile(t, "session-secret", strings.Repeat("s", 32)+"\n")
+
+	path := writeFile(t, "config.yaml", `
+github_client_id: client-id
+github_client_secret_file: `+clientSecretFile+`
+github_orgs: [org-a]
+github_pat: file-pat
+`)
+	t.Setenv("GITHUB_PAT_FILE", patFile)
+	t.Setenv("SESSION_SECRET_FILE", sessionSecretFile)
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() unexpected error = %v", err)
+	}
+
+	if cfg.GithubPAT != "pat-from-file" {
+		t.Errorf("GithubPAT = %q, want %q", cfg.GithubPAT, "pat-from-file")
+	}
+	if cfg.GithubClientSecret != "client-secret-from-file" {
+		t.Errorf("GithubClientSecret = %q, want %q", cfg.GithubClientSecret, "client-secret-from-file")
+	}
+	if cfg.SessionSecret != strings.Repeat("s", 32) {
+		t.Errorf("SessionSecret = %q, want value from file", cfg.SessionSecret)
+	}
+}
+
+func TestLoad_ReportsAllProblems(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.yaml", `
+log_format: xml
+github_orgs: [org-a]
+github_pat: pat
+github_pat_file: /does/not/matter
+unknown_key: true
+`)
+	t.Setenv("GITHUB_CLIENT_SECRET", "secret")
+	t.Setenv("GITHUB_CLIENT_SECRET_FILE", "/run/secrets/client-secret")
+
+	_, err := Load(path)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Load() error = %v, want *ValidationError", err)
+	}
+
+	want := []string{
+		"only one of github_pat and github_pat_file",
+		`unknown key "unknown_key"`,
+		"only one of GITHUB_CLIENT_SECRET and GITHUB_CLIENT_SECRET_FILE",
+		"LOG_FORMAT must be one of",
+		"GITHUB_CLIENT_ID",
+	}
+	for _, w := range want {
+		found := false
+		for _, p := range validationErr.Problems {
+			if strings.Contains(p, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expected a problem containing %q, got %v", w, validationErr.Problems)
+		}
+	}
+}
+
+func TestLoad_SecretTemplates(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("GITHUB_CLIENT_ID", "id")
+	t.Setenv("GITHUB_CLIENT_SECRET", "secret")
+	t.Setenv("GITHUB_PAT", "pat")
+	t.Setenv("GITHUB_ORG", "org")
+
+	cfg, err := Load(writeFile(t, "config.yaml", `
+secret_templates:
+  deployable: [DEPLOY_KEY, SENTRY_DSN]
+repository_templates:
+  org/api: deployable
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if template, ok := cfg.RepositoryTemplate("Org", "API"); !ok || template != "deployable" {
+		t.Errorf("RepositoryTemplate() = %q, %v, want deployable", template, ok)
+	}
+	if got := cfg.SecretTemplates["deployable"]; len(got) != 2 || got[1] != "SENTRY_DSN" {
+		t.Errorf("SecretTemplates[deployable] = %v", got)
+	}
+	if cfg.SecretManifestPath != ".github/secret-broker.yml" {
+		t.Errorf("SecretManifestPath = %q, want the default", cfg.SecretManifestPath)
+	}
+
+	_, err = Load(writeFile(t, "config.yaml", `
+secret_templates:
+  empty: []
+repository_templates:
+  api: deployable
+  org/web: library
+`))
+	if err == nil {
+		t.Fatal("Load() succeeded, want problems")
+	}
+	for _, w := range []string{`template "empty"`, `got "api"`, `unknown template "library"`} {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("Load() error = %v, want a problem containing %q", err, w)
+		}
+	}
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	clearEnv(t)
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "config file") {
+		t.Errorf("Load() error = %v, want config file error", err)
+	}
+}
@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v80/github"
+)
+
+// RequiredScopes are the classic OAuth scopes the broker's PAT needs to manage
+// repository secrets.
+var RequiredScopes = []string{"repo"}
+
+// impliedScopes lists the scopes that grant a scope implicitly.
+var impliedScopes = map[string][]string{
+	"read:org":  {"write:org", "admin:org"},
+	"write:org": {"admin:org"},
+}
+
+// ParseScopes splits the X-OAuth-Scopes header into scopes.
+func ParseScopes(header string) []string {
+	var scopes []string
+	for _, s := range strings.Split(header, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// MissingScopes returns the required scopes that granted doesn't cover.
+func MissingScopes(granted, required []string) []string {
+	var missing []string
+	for _, scope := range required {
+		if slices.Contains(granted, scope) {
+			continue
+		}
+		if slices.ContainsFunc(impliedScopes[scope], func(s string) bool { return slices.Contains(granted, s) }) {
+			continue
+		}
+		missing = append(missing, scope)
+	}
+	return missing
+}
+
+// GitHubPAT checks that the PAT returned by client authenticates and carries
+// the RequiredScopes. Fine-grained tokens don't report scopes; for them only
+// authentication is checked.
+func GitHubPAT(client func() *github.Client) Check {
+	return Check{
+		Name: "github_pat",
+		Run: func(ctx context.Context) error {
+			_, resp, err := client().Users.Get(ctx, "")
+			if err != nil {
+				return fmt.Errorf("authentication failed: %w", err)
+			}
+			header, ok := resp.Header["X-Oauth-Scopes"]
+			if !ok {
+				return nil
+			}
+			if missing := MissingScopes(ParseScopes(strings.Join(header, ",")), RequiredScopes); len(missing) > 0 {
+				return fmt.Errorf("token is missing scopes: %s", strings.Join(missing, ", "))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v80/github"
+)
+
+func TestMissingScopes(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  string
+		required []string
+		want     []string
+	}{
+		{name: "All granted", granted: "repo, read:org", required: []string{"repo", "read:org"}},
+		{name: "Implied", granted: "repo, admin:org", required: []string{"read:org"}},
+		{name: "Missing", granted: "read:user", required: []string{"repo", "read:org"}, want: []string{"repo", "read:org"}},
+		{name: "Empty header", granted: "", required: []string{"repo"}, want: []string{"repo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MissingScopes(ParseScopes(tt.granted), tt.required)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("MissingScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubPAT(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		scopes      *string
+		errContains string
+	}{
+		{name: "Sufficient scopes", status: http.StatusOK, scopes: ptr("repo, read:org")},
+		{name: "Fine-grained token", status: http.StatusOK},
+		{name: "Missing scopes", status: http.StatusOK, scopes: ptr("read:user"), errContains: "missing scopes: repo"},
+		{name: "Bad credentials", status: http.StatusUnauthorized, errContains: "authentication failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.scopes != nil {
+					w.Header().Set("X-OAuth-Scopes", *tt.scopes)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"login": "broker-bot"}`))
+			}))
+			defer srv.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+			err := GitHubPAT(func() *github.Client { return client }).Run(context.Background())
+			if tt.errContains == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("error = %v, want %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -0,0 +1,141 @@
+// Package health runs the readiness checks behind /readyz and the startup
+// self-check of the PAT.
+//
+// Readiness checks talk to GitHub, so their results are cached: a probe hitting the
+// endpoint every few seconds costs at most one round of checks per TTL.
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// checkTimeout bounds a single check so a hanging dependency can't block probes.
+const checkTimeout = 5 * time.Second
+
+// Check is a named readiness check. Run returns nil if the dependency is usable.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the outcome of all checks.
+type Report struct {
+	Status    string    `json:"status"`
+	Checks    []Result  `json:"checks"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Healthy reports whether every check passed.
+func (r Report) Healthy() bool {
+	return r.Status == StatusOK
+}
+
+// Public returns r without the errors of the checks, which may name internal
+// hosts or echo upstream responses. Log r, publish its public form.
+func (r Report) Public() Report {
+	checks := make([]Result, len(r.Checks))
+	for i, c := range r.Checks {
+		checks[i] = Result{Name: c.Name, Status: c.Status}
+	}
+	r.Checks = checks
+	return r
+}
+
+// Checker runs checks and caches the report for ttl.
+type Checker struct {
+	checks []Check
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	last    *Report
+	running *run
+}
+
+// run is a round of checks in progress. report is set before done is closed.
+type run struct {
+	done   chan struct{}
+	report Report
+}
+
+// New creates a Checker for checks whose report is reused for ttl.
+func New(ttl time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, ttl: ttl, now: time.Now}
+}
+
+// Check returns the cached report, running the checks if it is older than the
+// TTL. Concurrent callers wait for a single run instead of starting their own.
+//
+// The checks don't run on ctx: a probe that gives up doesn't cancel the run
+// others wait for. If ctx ends first, Check returns an unavailable report
+// right away and the run still fills the cache.
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	if c.last != nil && c.now().Sub(c.last.CheckedAt) < c.ttl {
+		report := *c.last
+		c.mu.Unlock()
+		return report
+	}
+	current := c.running
+	if current == nil {
+		current = &run{done: make(chan struct{})}
+		c.running = current
+		go c.run(context.WithoutCancel(ctx), current)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-current.done:
+		return current.report
+	case <-ctx.Done():
+		return Report{Status: StatusUnavailable, Checks: []Result{}, CheckedAt: c.now()}
+	}
+}
+
+// run runs the checks one after another, each with its own timeout. A report
+// with a canceled check isn't cached, as it says nothing about the dependency.
+func (c *Checker) run(ctx context.Context, current *run) {
+	report := Report{Status: StatusOK, Checks: make([]Result, 0, len(c.checks)), CheckedAt: c.now()}
+	canceled := false
+	for _, check := range c.checks {
+		result := Result{Name: check.Name, Status: StatusOK}
+		if err := runCheck(ctx, check); err != nil {
+			result.Status = StatusUnavailable
+			result.Error = err.Error()
+			report.Status = StatusUnavailable
+			canceled = canceled || errors.Is(err, context.Canceled)
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	c.mu.Lock()
+	if !canceled {
+		c.last = &report
+	}
+	c.running = nil
+	c.mu.Unlock()
+
+	current.report = report
+	close(current.done)
+}
+
+func runCheck(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	return check.Run(ctx)
+}
@@ -0,0 +1,118 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	calls := 0
+	failing := false
+	check := Check{Name: "dep", Run: func(ctx context.Context) error {
+		calls++
+		if failing {
+			return errors.New("down")
+		}
+		return nil
+	}}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New(30*time.Second, check)
+	c.now = func() time.Time { return now }
+
+	report := c.Check(context.Background())
+	if !report.Healthy() || len(report.Checks) != 1 || report.Checks[0].Status != StatusOK {
+		t.Fatalf("expected healthy report, got %+v", report)
+	}
+
+	// Within the TTL the cached report is returned
+	failing = true
+	now = now.Add(10 * time.Second)
+	if report := c.Check(context.Background()); !report.Healthy() || calls != 1 {
+		t.Fatalf("expected cached healthy report after %d calls, got %+v", calls, report)
+	}
+
+	// After the TTL the checks run again
+	now = now.Add(30 * time.Second)
+	report = c.Check(context.Background())
+	if report.Healthy() || calls != 2 {
+		t.Fatalf("expected unhealthy report after %d calls, got %+v", calls, report)
+	}
+	if report.Checks[0].Status != StatusUnavailable || report.Checks[0].Error != "down" {
+		t.Errorf("unexpected result %+v", report.Checks[0])
+	}
+}
+
+func TestChecker_NoChecks(t *testing.T) {
+	if report := New(time.Second).Check(context.Background()); !report.Healthy() {
+		t.Errorf("expected healthy report without checks, got %+v", report)
+	}
+}
+
+func TestChecker_CallerGivesUp(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	check := Check{Name: "dep", Run: func(ctx context.Context) error {
+		calls.Add(1)
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+	c := New(time.Minute, check)
+
+	// A caller that gives up gets an unavailable report without waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	go cancel()
+	if report := c.Check(ctx); report.Healthy() {
+		t.Fatalf("expected unavailable report for a canceled caller, got %+v", report)
+	}
+
+	// The run goes on, and others wait for it instead of starting their own
+	var wg sync.WaitGroup
+	reports := make([]Report, 3)
+	for i := range reports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i] = c.Check(context.Background())
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, report := range reports {
+		if !report.Healthy() {
+			t.Errorf("expected healthy report, got %+v", report)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected a single run, got %d", n)
+	}
+}
+
+func TestChecker_CanceledNotCached(t *testing.T) {
+	calls := 0
+	check := Check{Name: "dep", Run: func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			return fmt.Errorf("fetch: %w", context.Canceled)
+		}
+		return nil
+	}}
+	c := New(time.Minute, check)
+
+	if report := c.Check(context.Background()); report.Healthy() {
+		t.Fatalf("expected unavailable report, got %+v", report)
+	}
+	if report := c.Check(context.Background()); !report.Healthy() || calls != 2 {
+		t.Fatalf("expected the checks to run again after %d calls, got %+v", calls, report)
+	}
+}
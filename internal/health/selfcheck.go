@@ -0,0 +1,131 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v80/github"
+)
+
+// Finding is the outcome of one step of the startup self-check.
+type Finding struct {
+	Check  string
+	OK     bool
+	Detail string
+	// Hint tells the operator how to fix a failed check.
+	Hint string
+}
+
+// SelfCheckReport collects the findings of SelfCheck.
+type SelfCheckReport struct {
+	Findings []Finding
+}
+
+// OK reports whether every check passed.
+func (r SelfCheckReport) OK() bool {
+	for _, f := range r.Findings {
+		if !f.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Write prints the report in a human-readable form.
+func (r SelfCheckReport) Write(w io.Writer) {
+	_, _ = fmt.Fprintln(w, "GitHub PAT self-check:")
+	for _, f := range r.Findings {
+		mark := "ok  "
+		if !f.OK {
+			mark = "FAIL"
+		}
+		_, _ = fmt.Fprintf(w, "  [%s] %s: %s\n", mark, f.Check, f.Detail)
+		if !f.OK && f.Hint != "" {
+			_, _ = fmt.Fprintf(w, "         -> %s\n", f.Hint)
+		}
+	}
+}
+
+// SelfCheck verifies that client's token can do what the broker needs: it
+// authenticates, has the RequiredScopes (classic tokens only) and can list
+// the repositories of every org and read a repository's secrets public key.
+func SelfCheck(ctx context.Context, client *github.Client, orgs []string) SelfCheckReport {
+	var report SelfCheckReport
+	add := func(f Finding) { report.Findings = append(report.Findings, f) }
+
+	user, resp, err := client.Users.Get(ctx, "")
+	if err != nil {
+		add(Finding{
+			Check:  "authentication",
+			Detail: err.Error(),
+			Hint:   "Check that GITHUB_PAT is set to a valid, unexpired token (and GITHUB_ENTERPRISE_URL, if you use GitHub Enterprise).",
+		})
+		return report
+	}
+	add(Finding{Check: "authentication", OK: true, Detail: "authenticated as " + user.GetLogin()})
+
+	if header, ok := resp.Header["X-Oauth-Scopes"]; ok {
+		granted := ParseScopes(strings.Join(header, ","))
+		if missing := MissingScopes(granted, RequiredScopes); len(missing) > 0 {
+			add(Finding{
+				Check:  "scopes",
+				Detail: fmt.Sprintf("granted [%s], missing [%s]", strings.Join(granted, ", "), strings.Join(missing, ", ")),
+				Hint:   fmt.Sprintf("Regenerate the classic token with the %s scope(s).", strings.Join(missing, ", ")),
+			})
+		} else {
+			add(Finding{Check: "scopes", OK: true, Detail: "granted [" + strings.Join(granted, ", ") + "]"})
+		}
+	} else {
+		add(Finding{Check: "scopes", OK: true, Detail: "not reported (fine-grained token), relying on the access checks below"})
+	}
+
+	for _, org := range orgs {
+		add(checkOrg(ctx, client, org))
+	}
+	return report
+}
+
+// checkOrg lists one repository of org and reads its secrets public key.
+func checkOrg(ctx context.Context, client *github.Client, org string) Finding {
+	check := "org " + org
+	repos, _, err := client.Repositories.ListByOrg(ctx, org, &github.RepositoryListByOrgOptions{
+		ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return Finding{
+			Check:  check,
+			Detail: "cannot list repositories: " + err.Error(),
+			Hint:   orgHint(err, org),
+		}
+	}
+	if len(repos) == 0 {
+		return Finding{Check: check, OK: true, Detail: "no repositories visible, secrets access not verified"}
+	}
+
+	repo := repos[0].GetName()
+	if _, _, err := client.Actions.GetRepoPublicKey(ctx, org, repo); err != nil {
+		return Finding{
+			Check:  check,
+			Detail: fmt.Sprintf("cannot read the secrets public key of %s/%s: %v", org, repo, err),
+			Hint:   "Grant the token write access to Actions secrets: the repo scope for classic tokens, \"Secrets: Read and write\" for fine-grained tokens.",
+		}
+	}
+	return Finding{Check: check, OK: true, Detail: fmt.Sprintf("repositories and secrets public key of %s/%s readable", org, repo)}
+}
+
+func orgHint(err error, org string) string {
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) && ghErr.Response != nil {
+		switch ghErr.Response.StatusCode {
+		case http.StatusNotFound:
+			return fmt.Sprintf("Check the spelling of %q in GITHUB_ORG and that the token owner is a member of it.", org)
+		case http.StatusForbidden:
+			return fmt.Sprintf("Authorize the token for SAML SSO of %q, or have an org owner approve it if the org restricts token access.", org)
+		}
+	}
+	return "Check that GitHub is reachable from the broker."
+}
@@ -0,0 +1,100 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v80/github"
+)
+
+func TestSelfCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		wantOK    bool
+		wantInOut []string
+	}{
+		{
+			name: "All good",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/user":
+					w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+					_, _ = w.Write([]byte(`{"login": "broker-bot"}`))
+				case "/orgs/my-org/repos":
+					_, _ = w.Write([]byte(`[{"name": "app"}]`))
+				case "/repos/my-org/app/actions/secrets/public-key":
+					_, _ = w.Write([]byte(`{"key_id": "1", "key": "a2V5"}`))
+				default:
+					http.NotFound(w, r)
+				}
+			},
+			wantOK:    true,
+			wantInOut: []string{"authenticated as broker-bot", "my-org/app"},
+		},
+		{
+			name: "Bad credentials",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"message": "Bad credentials"}`))
+			},
+			wantInOut: []string{"[FAIL] authentication", "GITHUB_PAT"},
+		},
+		{
+			name: "Missing scope and unknown org",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/user" {
+					w.Header().Set("X-OAuth-Scopes", "read:user")
+					_, _ = w.Write([]byte(`{"login": "broker-bot"}`))
+					return
+				}
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			},
+			wantInOut: []string{"missing [repo]", "[FAIL] org my-org", "spelling"},
+		},
+		{
+			name: "Fine-grained token without secrets access",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/user":
+					_, _ = w.Write([]byte(`{"login": "broker-bot"}`))
+				case "/orgs/my-org/repos":
+					_, _ = w.Write([]byte(`[{"name": "app"}]`))
+				default:
+					w.WriteHeader(http.StatusForbidden)
+					_, _ = w.Write([]byte(`{"message": "Resource not accessible by personal access token"}`))
+				}
+			},
+			wantInOut: []string{"fine-grained", "[FAIL] org my-org", "Secrets: Read and write"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+			report := SelfCheck(context.Background(), client, []string{"my-org"})
+			if report.OK() != tt.wantOK {
+				t.Errorf("OK() = %v, want %v", report.OK(), tt.wantOK)
+			}
+
+			var buf bytes.Buffer
+			report.Write(&buf)
+			for _, want := range tt.wantInOut {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("expected %q in report:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	probeSessionName = "readyz"
+	probeKey         = "probe"
+)
+
+// headerWriter is the minimal http.ResponseWriter a session store needs to
+// set its cookie.
+type headerWriter struct {
+	header http.Header
+}
+
+func (w *headerWriter) Header() http.Header         { return w.header }
+func (w *headerWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *headerWriter) WriteHeader(int)             {}
+
+// SessionStore checks that the store returned by store can save a session and
+// read it back, which catches e.g. broken keys. The probe session is deleted
+// again, so stores that keep sessions on disk don't collect one per run.
+func SessionStore(store func() sessions.Store) Check {
+	return Check{
+		Name: "session_store",
+		Run: func(ctx context.Context) (err error) {
+			s := store()
+			if s == nil {
+				return errors.New("no session store configured")
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			if err != nil {
+				return err
+			}
+			probe, err := s.New(req, probeSessionName)
+			if err != nil {
+				return fmt.Errorf("failed to create session: %w", err)
+			}
+			probe.Values[probeKey] = "ok"
+			w := &headerWriter{header: http.Header{}}
+			if err := s.Save(req, w, probe); err != nil {
+				return fmt.Errorf("failed to save session: %w", err)
+			}
+			defer func() {
+				if probe.Options == nil {
+					probe.Options = &sessions.Options{}
+				}
+				probe.Options.MaxAge = -1
+				if deleteErr := s.Save(req, &headerWriter{header: http.Header{}}, probe); deleteErr != nil && err == nil {
+					err = fmt.Errorf("failed to delete session: %w", deleteErr)
+				}
+			}()
+
+			// Read it back the way the next request would
+			req, err = http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			if err != nil {
+				return err
+			}
+			for _, c := range (&http.Response{Header: w.header}).Cookies() {
+				req.AddCookie(c)
+			}
+			session, err := s.New(req, probeSessionName)
+			if err != nil {
+				return fmt.Errorf("failed to load session: %w", err)
+			}
+			if session.Values[probeKey] != "ok" {
+				return errors.New("session did not round-trip")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+// failingStore is a session store that can't persist sessions.
+type failingStore struct {
+	*sessions.CookieStore
+}
+
+func (s failingStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	return errors.New("disk full")
+}
+
+func TestSessionStore(t *testing.T) {
+	t.Run("Cookie store", func(t *testing.T) {
+		store := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+		if err := SessionStore(func() sessions.Store { return store }).Run(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Filesystem store", func(t *testing.T) {
+		dir := t.TempDir()
+		store := sessions.NewFilesystemStore(dir, []byte("0123456789abcdef0123456789abcdef"))
+		check := SessionStore(func() sessions.Store { return store })
+		for range 3 {
+			if err := check.Run(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		// The probe sessions are deleted again
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("expected no session files, got %d", len(entries))
+		}
+	})
+
+	t.Run("Store fails to save", func(t *testing.T) {
+		store := failingStore{sessions.NewCookieStore([]byte("key"))}
+		if err := SessionStore(func() sessions.Store { return store }).Run(context.Background()); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("No store", func(t *testing.T) {
+		if err := SessionStore(func() sessions.Store { return nil }).Run(context.Background()); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
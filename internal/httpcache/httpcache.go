@@ -0,0 +1,149 @@
+// Package httpcache revalidates GitHub API responses with ETags.
+//
+// GitHub does not count a 304 Not Modified against the rate limit, so
+// repeating a GET with If-None-Match is free as long as nothing changed.
+// Responses are cached per credential: the Authorization header is part of
+// the key (hashed, so tokens are never kept in memory in the clear).
+package httpcache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// DefaultMaxEntries bounds the cache if NewTransport is given no limit.
+const DefaultMaxEntries = 1000
+
+type entry struct {
+	key    string
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// Transport is an http.RoundTripper that stores GET responses carrying an
+// ETag and revalidates them on the next identical request. It must sit below
+// the transport that adds the Authorization header.
+type Transport struct {
+	base       http.RoundTripper
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds the entries, most recently used first
+	lru *list.List
+}
+
+// NewTransport wraps base (http.DefaultTransport if nil) and keeps at most
+// maxEntries responses, dropping the least recently used.
+func NewTransport(base http.RoundTripper, maxEntries int) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	return &Transport{
+		base:       base,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	key := cacheKey(req)
+	cached := t.get(key)
+	if cached != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		// Fresh headers (e.g. rate limit) win over the stored ones
+		header := cached.header.Clone()
+		for k, v := range resp.Header {
+			header[k] = v
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = header
+		resp.Body = io.NopCloser(bytes.NewReader(cached.body))
+		resp.ContentLength = int64(len(cached.body))
+		return resp, nil
+
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.put(&entry{key: key, etag: resp.Header.Get("ETag"), header: resp.Header.Clone(), body: body})
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+
+	return resp, nil
+}
+
+// Len returns the number of cached responses.
+func (t *Transport) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lru.Len()
+}
+
+func (t *Transport) get(key string) *entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	el, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	t.lru.MoveToFront(el)
+	return el.Value.(*entry)
+}
+
+func (t *Transport) put(e *entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.entries[e.key]; ok {
+		el.Value = e
+		t.lru.MoveToFront(el)
+		return
+	}
+	t.entries[e.key] = t.lru.PushFront(e)
+	for t.lru.Len() > t.maxEntries {
+		oldest := t.lru.Back()
+		t.lru.Remove(oldest)
+		delete(t.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// cacheKey identifies a response by credential, URL and the headers GitHub
+// varies its responses on.
+func cacheKey(req *http.Request) string {
+	h := sha256.New()
+	_, _ = io.WriteString(h, req.Header.Get("Authorization"))
+	_, _ = io.WriteString(h, "\x00"+req.Header.Get("Accept"))
+	_, _ = io.WriteString(h, "\x00"+req.Header.Get("X-GitHub-Api-Version"))
+	return hex.EncodeToString(h.Sum(nil)) + " " + req.URL.String()
+}
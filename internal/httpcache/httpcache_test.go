@@ -0,0 +1,106 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	calls, notModified := 0, 0
+	body := `{"name": "v1"}`
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		_, _ = io.WriteString(w, body)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: NewTransport(nil, 10)}
+	get := func(token string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/repos/o/r", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = res.Body.Close() }()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+
+	status, got := get("alice")
+	if status != http.StatusOK || got != body {
+		t.Fatalf("first request: got %d %q", status, got)
+	}
+
+	// Revalidated, served from the cache
+	status, got = get("alice")
+	if status != http.StatusOK || got != body || notModified != 1 {
+		t.Fatalf("second request: got %d %q, %d not modified", status, got, notModified)
+	}
+
+	// Another credential doesn't share the entry
+	get("bob")
+	if notModified != 1 {
+		t.Errorf("expected no revalidation for another token, got %d", notModified)
+	}
+
+	// Changed resource
+	body, etag = `{"name": "v2"}`, `"v2"`
+	_, got = get("alice")
+	if got != body {
+		t.Errorf("expected updated body, got %q", got)
+	}
+	if calls != 4 {
+		t.Errorf("expected 4 upstream calls, got %d", calls)
+	}
+}
+
+func TestTransport_Eviction(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"x"`)
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	transport := NewTransport(nil, 2)
+	client := &http.Client{Transport: transport}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		res, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+	if transport.Len() != 2 {
+		t.Errorf("expected 2 cached entries, got %d", transport.Len())
+	}
+}
+
+func TestTransport_IgnoresWrites(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"x"`)
+	}))
+	defer srv.Close()
+
+	transport := NewTransport(nil, 2)
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/a", nil)
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if transport.Len() != 0 {
+		t.Errorf("expected no cached entries, got %d", transport.Len())
+	}
+}
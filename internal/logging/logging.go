@@ -0,0 +1,50 @@
+// Package logging carries request-scoped values such as the request ID
+// through the context into log records and keeps credentials out of them.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID stored in ctx, or "" if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// ContextHandler is a slog.Handler that adds the request ID from the context
+// to every record. Use the *Context logging methods (InfoContext etc.) to pass
+// the request context along.
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler wraps h.
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
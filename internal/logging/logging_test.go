@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewContextHandler(slog.NewTextHandler(&buf, nil)))
+
+	ctx := WithRequestID(context.Background(), "req-123")
+	logger.InfoContext(ctx, "with id")
+	logger.With("component", "test").WithGroup("g").InfoContext(ctx, "derived", "key", "value")
+	logger.Info("without id")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log lines, got %d", len(lines))
+	}
+	if !strings.Contains(lines[0], "request_id=req-123") {
+		t.Errorf("expected request ID in %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "req-123") || !strings.Contains(lines[1], "component=test") {
+		t.Errorf("expected request ID and attrs in derived logger output %q", lines[1])
+	}
+	if strings.Contains(lines[2], "request_id") {
+		t.Errorf("expected no request ID without context, got %q", lines[2])
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	if got := RequestID(context.Background()); got != "" {
+		t.Errorf("RequestID() = %q, want empty", got)
+	}
+	if got := RequestID(WithRequestID(context.Background(), "abc")); got != "abc" {
+		t.Errorf("RequestID() = %q, want %q", got, "abc")
+	}
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces scrubbed values.
+const Redacted = "[redacted]"
+
+// sensitiveKeys are attribute names whose values are always dropped.
+var sensitiveKeys = []string{"value", "token", "secret", "password", "authorization", "cookie"}
+
+// sensitiveSuffixes catch variants such as access_token or client_secret.
+var sensitiveSuffixes = []string{"_value", "_token", "_secret", "_password"}
+
+// tokenPattern matches GitHub tokens, the broker's own API tokens and bearer
+// credentials embedded in otherwise harmless strings, e.g. error messages.
+// Only credential shapes are matched: "token expired" or "Bearer realm" in an
+// error message is what's needed to debug it. "token ghp_…" is covered by the
+// GitHub token forms.
+var tokenPattern = regexp.MustCompile(`(?i)\b(?:gh[pousr]_[A-Za-z0-9]{16,}|github_pat_[A-Za-z0-9_]{16,}|gsb_[A-Za-z0-9_-]{16,}|bearer [A-Za-z0-9._~+/=-]{20,})`)
+
+// RedactHandler is a slog.Handler that scrubs credentials before records
+// reach the wrapped handler. Attributes named like a secret are replaced
+// entirely, tokens inside other string values are masked.
+type RedactHandler struct {
+	slog.Handler
+}
+
+// NewRedactHandler wraps h.
+func NewRedactHandler(h slog.Handler) *RedactHandler {
+	return &RedactHandler{Handler: h}
+}
+
+func (h *RedactHandler) Handle(ctx context.Context, r slog.Record) error {
+	scrubbed := slog.NewRecord(r.Time, r.Level, redactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		scrubbed.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, scrubbed)
+}
+
+func (h *RedactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scrubbed := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		scrubbed[i] = redactAttr(a)
+	}
+	return &RedactHandler{Handler: h.Handler.WithAttrs(scrubbed)}
+}
+
+func (h *RedactHandler) WithGroup(name string) slog.Handler {
+	return &RedactHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		scrubbed := make([]any, len(group))
+		for i, ga := range group {
+			scrubbed[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, scrubbed...)
+	case slog.KindAny:
+		switch val := v.Any().(type) {
+		case http.Header:
+			return slog.Any(a.Key, redactHeader(val))
+		case error:
+			return slog.String(a.Key, redactString(val.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range sensitiveKeys {
+		if key == k {
+			return true
+		}
+	}
+	for _, s := range sensitiveSuffixes {
+		if strings.HasSuffix(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactString(s string) string {
+	return tokenPattern.ReplaceAllString(s, Redacted)
+}
+
+func redactHeader(h http.Header) http.Header {
+	scrubbed := h.Clone()
+	for key := range scrubbed {
+		if isSensitiveKey(key) || strings.EqualFold(key, "Set-Cookie") {
+			scrubbed[key] = []string{Redacted}
+		}
+	}
+	return scrubbed
+}
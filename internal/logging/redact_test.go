@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		log     func(*slog.Logger)
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "Sensitive attribute names",
+			log:     func(l *slog.Logger) { l.Info("msg", "value", "s3cr3t", "access_token", "abc", "Client_Secret", "xyz") },
+			want:    []string{"value=[redacted]", "access_token=[redacted]", "Client_Secret=[redacted]"},
+			notWant: []string{"s3cr3t", "abc", "xyz"},
+		},
+		{
+			name:    "Harmless attributes",
+			log:     func(l *slog.Logger) { l.Info("msg", "secret_name", "API_KEY", "repo", "org/repo") },
+			want:    []string{"secret_name=API_KEY", "repo=org/repo"},
+			notWant: []string{Redacted},
+		},
+		{
+			name: "Token inside message and error",
+			log: func(l *slog.Logger) {
+				l.Info("using ghp_abcdefghijklmnopqrstuvwxyz and gsb_abc-DEF_ghijklmnopqrst", "error", errors.New("401 for Bearer eyJhbGciOi.eyJzdWIiOi.c2lnbmF0dXJl"))
+			},
+			want:    []string{"using [redacted] and [redacted]", "401 for [redacted]"},
+			notWant: []string{"ghp_", "gsb_", "eyJ"},
+		},
+		{
+			name: "Words that look like a credential prefix",
+			log: func(l *slog.Logger) {
+				l.Info("token signature is invalid", "error", errors.New("token expired at 12:00; Bearer realm=broker; token ghp_abcdefghijklmnopqrstuvwxyz"))
+			},
+			want:    []string{"token signature is invalid", "token expired at 12:00", "Bearer realm=broker", "token [redacted]"},
+			notWant: []string{"ghp_"},
+		},
+		{
+			name: "Authorization header",
+			log: func(l *slog.Logger) {
+				l.Info("msg", "headers", http.Header{"Authorization": {"token xyz"}, "Accept": {"application/json"}})
+			},
+			want:    []string{"Authorization:[[redacted]]", "application/json"},
+			notWant: []string{"xyz"},
+		},
+		{
+			name:    "Groups and derived loggers",
+			log:     func(l *slog.Logger) { l.With("token", "abc").Info("msg", slog.Group("req", "authorization", "xyz")) },
+			want:    []string{"token=[redacted]", "req.authorization=[redacted]"},
+			notWant: []string{"abc", "xyz"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(slog.New(NewRedactHandler(slog.NewTextHandler(&buf, nil))))
+			out := buf.String()
+
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("expected %q in %q", w, out)
+				}
+			}
+			for _, nw := range tt.notWant {
+				if strings.Contains(out, nw) {
+					t.Errorf("did not expect %q in %q", nw, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package manifest resolves the secrets a repository is required to have.
+//
+// Admins define named templates, lists of secret names such as every
+// deployable service's DEPLOY_KEY and SENTRY_DSN, in the broker's config. A
+// repository uses a template either through the config as well, or through a
+// manifest file it keeps itself.
+package manifest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalid is returned for manifests that can't be used, e.g. because they
+// name an unknown template.
+var ErrInvalid = errors.New("invalid secret manifest")
+
+// Manifest declares the secrets a repository needs:
+//
+//	template: deployable
+//	secrets: [STRIPE_KEY]
+//
+// Both fields are optional; secrets are required on top of the template's.
+type Manifest struct {
+	Template string   `yaml:"template"`
+	Secrets  []string `yaml:"secrets"`
+}
+
+// Parse parses a manifest file. Unknown keys are an error, so a misspelled
+// key doesn't silently require nothing.
+func Parse(data []byte) (Manifest, error) {
+	var m Manifest
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return Manifest{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return m, nil
+}
+
+// Required returns the secrets m requires, sorted and without duplicates.
+// GitHub secret names are case-insensitive; they are returned upper case, as
+// GitHub lists them.
+func (m Manifest) Required(templates map[string][]string) ([]string, error) {
+	names := m.Secrets
+	if m.Template != "" {
+		template, ok := templates[m.Template]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown template %q", ErrInvalid, m.Template)
+		}
+		names = slices.Concat(template, names)
+	}
+
+	required := make([]string, 0, len(names))
+	for _, name := range names {
+		if err := repository.ValidateSecretName(name); err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", ErrInvalid, name, err)
+		}
+		required = append(required, strings.ToUpper(name))
+	}
+	slices.Sort(required)
+	return slices.Compact(required), nil
+}
+
+// Missing returns the names in required that are not in present, ignoring
+// case.
+func Missing(required, present []string) []string {
+	missing := []string{}
+	for _, name := range required {
+		if !slices.ContainsFunc(present, func(p string) bool { return strings.EqualFold(p, name) }) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
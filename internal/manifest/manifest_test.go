@@ -0,0 +1,90 @@
+package manifest
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+)
+
+func TestParse(t *testing.T) {
+	m, err := Parse([]byte("template: deployable\nsecrets: [STRIPE_KEY]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, m.Template, "deployable")
+	assert.Equal(t, slices.Equal(m.Secrets, []string{"STRIPE_KEY"}), true)
+
+	m, err = Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, m.Template, "")
+
+	_, err = Parse([]byte("templates: deployable\n"))
+	assert.Equal(t, errors.Is(err, ErrInvalid), true)
+}
+
+func TestRequired(t *testing.T) {
+	templates := map[string][]string{
+		"deployable": {"DEPLOY_KEY", "SENTRY_DSN", "REGISTRY_TOKEN"},
+	}
+
+	tests := []struct {
+		name     string
+		manifest Manifest
+		want     []string
+		invalid  bool
+	}{
+		{
+			name:     "Template",
+			manifest: Manifest{Template: "deployable"},
+			want:     []string{"DEPLOY_KEY", "REGISTRY_TOKEN", "SENTRY_DSN"},
+		},
+		{
+			name:     "Template and secrets",
+			manifest: Manifest{Template: "deployable", Secrets: []string{"stripe_key", "sentry_dsn"}},
+			want:     []string{"DEPLOY_KEY", "REGISTRY_TOKEN", "SENTRY_DSN", "STRIPE_KEY"},
+		},
+		{
+			name:     "Secrets only",
+			manifest: Manifest{Secrets: []string{"STRIPE_KEY"}},
+			want:     []string{"STRIPE_KEY"},
+		},
+		{
+			name:     "Nothing",
+			manifest: Manifest{},
+			want:     []string{},
+		},
+		{
+			name:     "Unknown template",
+			manifest: Manifest{Template: "library"},
+			invalid:  true,
+		},
+		{
+			name:     "Invalid name",
+			manifest: Manifest{Secrets: []string{"GITHUB_TOKEN"}},
+			invalid:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.manifest.Required(templates)
+			if tt.invalid {
+				assert.Equal(t, errors.Is(err, ErrInvalid), true)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, slices.Equal(got, tt.want), true)
+		})
+	}
+}
+
+func TestMissing(t *testing.T) {
+	got := Missing([]string{"DEPLOY_KEY", "SENTRY_DSN"}, []string{"deploy_key", "OTHER"})
+	assert.Equal(t, slices.Equal(got, []string{"SENTRY_DSN"}), true)
+	assert.Equal(t, len(Missing(nil, []string{"OTHER"})), 0)
+}
@@ -0,0 +1,202 @@
+// Package metrics collects the broker's Prometheus metrics: HTTP requests,
+// GitHub API calls and secret writes.
+//
+// A nil *Metrics discards all observations, so code paths and tests that don't
+// care about metrics don't need to set one up.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gh_secret_broker"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+
+	githubRequests           *prometheus.CounterVec
+	githubErrors             *prometheus.CounterVec
+	githubRateLimitRemaining *prometheus.GaugeVec
+
+	secretsCreated prometheus.Counter
+	secretsDeleted prometheus.Counter
+}
+
+// New creates the metrics and registers them, together with the Go runtime
+// and process collectors, on a dedicated registry.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		githubRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "github_api_requests_total",
+			Help:      "Number of GitHub API calls by client and operation.",
+		}, []string{"client", "operation"}),
+		githubErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "github_api_errors_total",
+			Help:      "Number of failed GitHub API calls by client and operation.",
+		}, []string{"client", "operation"}),
+		githubRateLimitRemaining: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "github_rate_limit_remaining",
+			Help:      "Remaining GitHub API rate limit as reported by the last response, by client and resource.",
+		}, []string{"client", "resource"}),
+		secretsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "secrets_created_total",
+			Help:      "Number of secrets created or updated through the broker.",
+		}),
+		secretsDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "secrets_deleted_total",
+			Help:      "Number of secrets deleted through the broker.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.githubRequests,
+		m.githubErrors,
+		m.githubRateLimitRemaining,
+		m.secretsCreated,
+		m.secretsDeleted,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a served HTTP request. route is the ServeMux pattern
+// that matched, never the raw path, to keep the label cardinality bounded.
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	if route == "" {
+		route = "unmatched"
+	}
+	labels := prometheus.Labels{"route": route, "method": method, "status": strconv.Itoa(status)}
+	m.httpRequests.With(labels).Inc()
+	m.httpRequestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// SecretCreated counts a secret created or updated through the broker.
+func (m *Metrics) SecretCreated() {
+	if m == nil {
+		return
+	}
+	m.secretsCreated.Inc()
+}
+
+// SecretDeleted counts a secret deleted through the broker.
+func (m *Metrics) SecretDeleted() {
+	if m == nil {
+		return
+	}
+	m.secretsDeleted.Inc()
+}
+
+// Transport wraps base so that every GitHub API call is counted and the rate
+// limit headers of the responses are recorded. client names the credential the
+// calls are made with, e.g. "pat" or "user".
+func (m *Metrics) Transport(base http.RoundTripper, client string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if m == nil {
+		return base
+	}
+	return &transport{base: base, client: client, metrics: m}
+}
+
+type transport struct {
+	base    http.RoundTripper
+	client  string
+	metrics *Metrics
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	operation := githubOperation(req.Method, req.URL.Path)
+	t.metrics.githubRequests.WithLabelValues(t.client, operation).Inc()
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.metrics.githubErrors.WithLabelValues(t.client, operation).Inc()
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		t.metrics.githubErrors.WithLabelValues(t.client, operation).Inc()
+	}
+
+	if remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining")); err == nil {
+		resource := res.Header.Get("X-RateLimit-Resource")
+		if resource == "" {
+			resource = "core"
+		}
+		t.metrics.githubRateLimitRemaining.WithLabelValues(t.client, resource).Set(float64(remaining))
+	}
+
+	return res, nil
+}
+
+// githubOperation maps a GitHub API request to a low-cardinality operation name.
+func githubOperation(method, path string) string {
+	// GitHub Enterprise serves the API below /api/v3
+	path = strings.TrimPrefix(path, "/api/v3")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "user":
+		return "get_user"
+	case len(parts) == 2 && parts[0] == "user" && parts[1] == "repos":
+		return "list_user_repos"
+	case len(parts) == 3 && parts[0] == "orgs" && parts[2] == "repos":
+		return "list_org_repos"
+	case len(parts) == 3 && parts[0] == "repos":
+		return "get_repo"
+	case len(parts) == 5 && parts[0] == "repos" && parts[3] == "actions" && parts[4] == "secrets":
+		return "list_repo_secrets"
+	case len(parts) == 6 && parts[0] == "repos" && parts[3] == "actions" && parts[4] == "secrets":
+		if parts[5] == "public-key" {
+			return "get_repo_public_key"
+		}
+		switch method {
+		case http.MethodPut:
+			return "create_or_update_repo_secret"
+		case http.MethodDelete:
+			return "delete_repo_secret"
+		default:
+			return "get_repo_secret"
+		}
+	}
+	return "other"
+}
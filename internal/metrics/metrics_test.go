@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the metrics in the Prometheus text format.
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	return string(body)
+}
+
+func TestObserveRequest(t *testing.T) {
+	m := New()
+	m.ObserveRequest("GET /api/user/repos", http.MethodGet, http.StatusOK, 120*time.Millisecond)
+	m.ObserveRequest("", http.MethodGet, http.StatusNotFound, time.Millisecond)
+	m.SecretCreated()
+	m.SecretDeleted()
+	m.SecretDeleted()
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`gh_secret_broker_http_requests_total{method="GET",route="GET /api/user/repos",status="200"} 1`,
+		`gh_secret_broker_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`gh_secret_broker_http_request_duration_seconds_bucket{method="GET",route="GET /api/user/repos",status="200",le="0.25"} 1`,
+		`gh_secret_broker_secrets_created_total 1`,
+		`gh_secret_broker_secrets_deleted_total 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "4321")
+		w.Header().Set("X-RateLimit-Resource", "core")
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	m := New()
+	client := &http.Client{Transport: m.Transport(nil, "pat")}
+
+	res, err := client.Get(server.URL + "/repos/org/repo/actions/secrets/public-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/repos/org/repo/actions/secrets/TOKEN", nil)
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`gh_secret_broker_github_api_requests_total{client="pat",operation="get_repo_public_key"} 1`,
+		`gh_secret_broker_github_api_requests_total{client="pat",operation="delete_repo_secret"} 1`,
+		`gh_secret_broker_github_api_errors_total{client="pat",operation="delete_repo_secret"} 1`,
+		`gh_secret_broker_github_rate_limit_remaining{client="pat",resource="core"} 4321`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected metrics to contain %q", want)
+		}
+	}
+	if strings.Contains(out, `github_api_errors_total{client="pat",operation="get_repo_public_key"}`) {
+		t.Error("successful call must not be counted as error")
+	}
+}
+
+func TestGithubOperation(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/user", "get_user"},
+		{http.MethodGet, "/user/repos", "list_user_repos"},
+		{http.MethodGet, "/api/v3/user/repos", "list_user_repos"},
+		{http.MethodGet, "/orgs/org/repos", "list_org_repos"},
+		{http.MethodGet, "/repos/org/repo", "get_repo"},
+		{http.MethodGet, "/repos/org/repo/actions/secrets", "list_repo_secrets"},
+		{http.MethodGet, "/repos/org/repo/actions/secrets/public-key", "get_repo_public_key"},
+		{http.MethodPut, "/repos/org/repo/actions/secrets/NAME", "create_or_update_repo_secret"},
+		{http.MethodDelete, "/repos/org/repo/actions/secrets/NAME", "delete_repo_secret"},
+		{http.MethodGet, "/rate_limit", "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if got := githubOperation(tt.method, tt.path); got != tt.want {
+				t.Errorf("githubOperation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	m.ObserveRequest("GET /", http.MethodGet, http.StatusOK, time.Second)
+	m.SecretCreated()
+	m.SecretDeleted()
+	if m.Transport(nil, "pat") != http.DefaultTransport {
+		t.Error("expected nil metrics to return the base transport")
+	}
+}
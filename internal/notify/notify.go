@@ -0,0 +1,71 @@
+// Package notify sends high-priority notifications, such as break-glass
+// access, to a webhook. The payload's text field makes it work with Slack
+// and Mattermost incoming webhooks; other receivers can use the structured
+// fields.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Priorities of a message.
+const (
+	PriorityHigh = "high"
+)
+
+// timeout bounds a delivery.
+const timeout = 5 * time.Second
+
+// Message is the JSON body posted to the webhook.
+type Message struct {
+	Event    string `json:"event"`
+	Priority string `json:"priority"`
+	// Text is a human-readable summary.
+	Text   string            `json:"text"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Webhook posts messages to URL. A Webhook without URL discards them.
+type Webhook struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// Send posts m to the webhook. Any status but 2xx is an error.
+func (w Webhook) Send(ctx context.Context, m Message) error {
+	if w.URL == "" {
+		return nil
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := w.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		// The URL may carry a token, so it is left out of the error
+		return fmt.Errorf("sending %s notification failed", m.Event)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("sending %s notification: unexpected status %s", m.Event, res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	var got Message
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("expected JSON, got %q", r.Header.Get("Content-Type"))
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	hook := Webhook{URL: server.URL, HTTPClient: server.Client()}
+	m := Message{Event: "break_glass", Priority: PriorityHigh, Text: "octocat broke the glass", Fields: map[string]string{"ticket": "INC-1"}}
+	if err := hook.Send(context.Background(), m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Text != m.Text || got.Fields["ticket"] != "INC-1" {
+		t.Errorf("unexpected message %+v", got)
+	}
+
+	status = http.StatusInternalServerError
+	if err := hook.Send(context.Background(), m); err == nil {
+		t.Error("expected an error for a failed delivery")
+	}
+
+	if err := (Webhook{}).Send(context.Background(), m); err != nil {
+		t.Errorf("expected a webhook without URL to discard messages, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors of DeviceFlow.Exchange while the user hasn't completed the login.
+// They correspond to the error codes of RFC 8628.
+var (
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too fast")
+	ErrExpiredToken         = errors.New("device code expired")
+	ErrAccessDenied         = errors.New("access denied by the user")
+)
+
+// DeviceAuthorization is GitHub's answer to starting a device login.
+type DeviceAuthorization struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// DeviceFlow runs GitHub's OAuth device flow, for logins from machines
+// without a browser. Device flow must be enabled in the OAuth app's settings.
+type DeviceFlow struct {
+	ClientID string
+	// BaseURL is https://github.com or the GitHub Enterprise URL.
+	BaseURL    string
+	Scopes     []string
+	HTTPClient *http.Client
+}
+
+// NewDeviceFlow returns a device flow for the OAuth app of the web login.
+func NewDeviceFlow(clientID, enterpriseURL string) *DeviceFlow {
+	baseURL := "https://github.com"
+	if enterpriseURL != "" {
+		baseURL = enterpriseURL
+	}
+	return &DeviceFlow{ClientID: clientID, BaseURL: baseURL, Scopes: []string{"user:email"}}
+}
+
+// Start requests a device and a user code.
+func (d *DeviceFlow) Start(ctx context.Context) (*DeviceAuthorization, error) {
+	var auth DeviceAuthorization
+	err := d.post(ctx, "/login/device/code", url.Values{
+		"client_id": {d.ClientID},
+		"scope":     {strings.Join(d.Scopes, " ")},
+	}, &auth)
+	if err != nil {
+		return nil, err
+	}
+	if auth.DeviceCode == "" {
+		return nil, errors.New("GitHub returned no device code")
+	}
+	return &auth, nil
+}
+
+// Exchange returns the user's access token once they entered the user code.
+// Until then it fails with one of the Err variables above.
+func (d *DeviceFlow) Exchange(ctx context.Context, deviceCode string) (string, error) {
+	var res struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err := d.post(ctx, "/login/oauth/access_token", url.Values{
+		"client_id":   {d.ClientID},
+		"device_code": {deviceCode},
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+	}, &res)
+	if err != nil {
+		return "", err
+	}
+
+	switch res.Error {
+	case "":
+		if res.AccessToken == "" {
+			return "", errors.New("GitHub returned no access token")
+		}
+		return res.AccessToken, nil
+	case "authorization_pending":
+		return "", ErrAuthorizationPending
+	case "slow_down":
+		return "", ErrSlowDown
+	case "expired_token":
+		return "", ErrExpiredToken
+	case "access_denied":
+		return "", ErrAccessDenied
+	}
+	return "", fmt.Errorf("device login failed: %s: %s", res.Error, res.ErrorDescription)
+}
+
+func (d *DeviceFlow) post(ctx context.Context, path string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := d.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: unexpected status %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
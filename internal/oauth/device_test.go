@@ -0,0 +1,66 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	var exchangeResult map[string]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login/device/code", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("client_id") != "client-id" || r.PostForm.Get("scope") != "user:email" {
+			t.Errorf("Unexpected form %v", r.PostForm)
+		}
+		_ = json.NewEncoder(w).Encode(DeviceAuthorization{DeviceCode: "device", UserCode: "ABCD-1234", Interval: 5})
+	})
+	mux.HandleFunc("POST /login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:device_code" || r.PostForm.Get("device_code") != "device" {
+			t.Errorf("Unexpected form %v", r.PostForm)
+		}
+		_ = json.NewEncoder(w).Encode(exchangeResult)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	d := NewDeviceFlow("client-id", server.URL)
+	auth, err := d.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if auth.UserCode != "ABCD-1234" || auth.Interval != 5 {
+		t.Errorf("Unexpected authorization %+v", auth)
+	}
+
+	tests := []struct {
+		result  map[string]string
+		wantErr error
+	}{
+		{map[string]string{"error": "authorization_pending"}, ErrAuthorizationPending},
+		{map[string]string{"error": "slow_down"}, ErrSlowDown},
+		{map[string]string{"error": "expired_token"}, ErrExpiredToken},
+		{map[string]string{"error": "access_denied"}, ErrAccessDenied},
+		{map[string]string{"access_token": "token"}, nil},
+	}
+	for _, tt := range tests {
+		exchangeResult = tt.result
+		token, err := d.Exchange(context.Background(), auth.DeviceCode)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%v: expected %v, got %v", tt.result, tt.wantErr, err)
+		}
+		if tt.wantErr == nil && token != "token" {
+			t.Errorf("Expected the access token, got %q", token)
+		}
+	}
+
+	exchangeResult = map[string]string{"error": "incorrect_client_credentials"}
+	if _, err := d.Exchange(context.Background(), auth.DeviceCode); err == nil {
+		t.Error("Expected an error for unknown error codes")
+	}
+}
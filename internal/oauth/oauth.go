@@ -1,13 +1,15 @@
 package oauth
 
 import (
+	"context"
 	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
-	"sort"
 
 	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+	"github.com/RobinMaas95/gh-secret-broker/internal/problem"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
 	"github.com/markbates/goth/gothic"
@@ -18,18 +20,27 @@ func init() {
 	gob.Register(goth.User{})
 }
 
-type ProviderIndex struct {
-	Providers    []string
-	ProvidersMap map[string]string
-}
-
 type Service struct {
 	logger *slog.Logger
 	config *config.Config
 	store  sessions.Store
+	// oidc is the OIDC provider, if it is a login provider
+	oidc *oidcProvider
+
+	// LinkGitHub returns the GitHub user ID of a GitHub login. Users who log
+	// in with OIDC are linked to the GitHub account named by their token, and
+	// GitHub authorization checks run for that account. OIDC logins fail
+	// while it is nil.
+	LinkGitHub func(ctx context.Context, login string) (userID string, err error)
+}
+
+// providerNames are the names the login page shows for the providers.
+var providerNames = map[string]string{
+	"github":         "Github",
+	OIDCProviderName: "SSO",
 }
 
-func NewService(logger *slog.Logger, cfg *config.Config) *Service {
+func NewService(logger *slog.Logger, cfg *config.Config) (*Service, error) {
 	// Initialize the store with secret from config
 	store := sessions.NewFilesystemStore("", []byte(cfg.SessionSecret))
 	store.MaxLength(0) // No limit on length
@@ -51,35 +62,60 @@ func NewService(logger *slog.Logger, cfg *config.Config) *Service {
 		github.EmailURL = cfg.GithubEnterpriseURL + "/api/v3/user/emails"
 	}
 
-	goth.UseProviders(
-		github.New(cfg.GithubClientID, cfg.GithubClientSecret, cfg.BaseURL+"/auth/github/callback", "user:email"),
-	)
+	// Only the providers of the login page are registered, gothic rejects
+	// logins with any other
+	goth.ClearProviders()
+	var oidc *oidcProvider
+	for _, name := range cfg.LoginProviders {
+		switch name {
+		case "github":
+			goth.UseProviders(github.New(cfg.GithubClientID, cfg.GithubClientSecret, cfg.BaseURL+"/auth/github/callback", "user:email"))
+		case OIDCProviderName:
+			ctx, cancel := context.WithTimeout(context.Background(), oidcTimeout)
+			p, err := newOIDCProvider(ctx, &http.Client{Timeout: oidcTimeout}, cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.BaseURL+"/auth/oidc/callback", cfg.OIDCGithubClaim)
+			cancel()
+			if err != nil {
+				return nil, err
+			}
+			goth.UseProviders(p)
+			oidc = p
+		}
+	}
 
 	return &Service{
 		logger: logger,
 		config: cfg,
 		store:  store,
-	}
+		oidc:   oidc,
+	}, nil
+}
+
+// ProviderIndex lists the login providers as served by /api/providers.
+type ProviderIndex struct {
+	Providers []string
+	// ProvidersMap maps a provider to its display name.
+	ProvidersMap map[string]string
 }
 
+// GetProviderIndex lists the login providers in the configured order.
 func (s *Service) GetProviderIndex() *ProviderIndex {
-	m := map[string]string{
-		"github": "Github",
-	}
-	var keys []string
-	for k := range m {
-		keys = append(keys, k)
+	index := &ProviderIndex{Providers: []string{}, ProvidersMap: map[string]string{}}
+	for _, name := range s.config.LoginProviders {
+		display := providerNames[name]
+		if name == OIDCProviderName && s.config.OIDCName != "" {
+			display = s.config.OIDCName
+		}
+		index.Providers = append(index.Providers, name)
+		index.ProvidersMap[name] = display
 	}
-	sort.Strings(keys)
-
-	return &ProviderIndex{Providers: keys, ProvidersMap: m}
+	return index
 }
 
 func (s *Service) ProviderLogin(res http.ResponseWriter, req *http.Request) {
 	// try to get the user without re-authenticating
 	session, err := s.store.Get(req, "session")
 	if err != nil {
-		s.logger.Warn("Failed to get session, creating new one", slog.String("error", err.Error()))
+		s.logger.WarnContext(req.Context(), "Failed to get session, creating new one", slog.String("error", err.Error()))
 		// Continue with new/invalid session - don't block login
 	}
 
@@ -101,18 +137,18 @@ func (s *Service) ProviderLogin(res http.ResponseWriter, req *http.Request) {
 
 func (s *Service) ProviderLogout(res http.ResponseWriter, req *http.Request) {
 	if err := gothic.Logout(res, req); err != nil {
-		s.logger.Warn("Gothic logout error", slog.String("error", err.Error()))
+		s.logger.WarnContext(req.Context(), "Gothic logout error", slog.String("error", err.Error()))
 	}
 
 	session, err := s.store.Get(req, "session")
 	if err != nil {
-		s.logger.Warn("Failed to get session during logout", slog.String("error", err.Error()))
+		s.logger.WarnContext(req.Context(), "Failed to get session during logout", slog.String("error", err.Error()))
 	}
 
 	if session != nil {
 		delete(session.Values, "user")
 		if err := session.Save(req, res); err != nil {
-			s.logger.Error("Failed to save session during logout", slog.String("error", err.Error()))
+			s.logger.ErrorContext(req.Context(), "Failed to save session during logout", slog.String("error", err.Error()))
 		}
 	}
 
@@ -124,23 +160,35 @@ func (s *Service) ProviderLogout(res http.ResponseWriter, req *http.Request) {
 func (s *Service) HandleCallback(res http.ResponseWriter, req *http.Request) {
 	user, err := gothic.CompleteUserAuth(res, req)
 	if err != nil {
-		s.logger.Error("Failed to complete user auth", slog.String("error", err.Error()))
+		s.logger.ErrorContext(req.Context(), "Failed to complete user auth", slog.String("error", err.Error()))
 		http.Error(res, "Authentication failed", http.StatusInternalServerError)
 		return
 	}
-	s.logger.Info("User logged in", slog.String("user_id", user.UserID), slog.String("email", user.Email))
+	if user.Provider == OIDCProviderName {
+		if user, err = s.oidc.verifyUser(req.Context(), user); err != nil {
+			s.logger.ErrorContext(req.Context(), "Failed to verify OIDC user", slog.String("error", err.Error()))
+			http.Error(res, "Authentication failed", http.StatusInternalServerError)
+			return
+		}
+		if user, err = s.linkGitHub(req.Context(), user); err != nil {
+			s.logger.WarnContext(req.Context(), "Failed to link OIDC user to GitHub", slog.String("login", user.NickName), slog.String("error", err.Error()))
+			http.Error(res, "Your account is not linked to a GitHub account", http.StatusForbidden)
+			return
+		}
+	}
+	s.logger.InfoContext(req.Context(), "User logged in", slog.String("user_id", user.UserID), slog.String("provider", user.Provider), slog.String("email", user.Email))
 
 	// Store user in session
 	session, err := s.store.Get(req, "session")
 	if err != nil {
-		s.logger.Error("Failed to get session after auth", slog.String("error", err.Error()))
+		s.logger.ErrorContext(req.Context(), "Failed to get session after auth", slog.String("error", err.Error()))
 		http.Error(res, "Session error", http.StatusInternalServerError)
 		return
 	}
 
 	session.Values["user"] = user
 	if err = session.Save(req, res); err != nil {
-		s.logger.Error("Failed to save session", slog.String("error", err.Error()))
+		s.logger.ErrorContext(req.Context(), "Failed to save session", slog.String("error", err.Error()))
 		http.Error(res, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
@@ -149,6 +197,21 @@ func (s *Service) HandleCallback(res http.ResponseWriter, req *http.Request) {
 	http.Redirect(res, req, "/", http.StatusTemporaryRedirect)
 }
 
+// linkGitHub replaces the OIDC subject of user by the ID of the GitHub
+// account its NickName names, so that the user is the same to the broker no
+// matter how they logged in.
+func (s *Service) linkGitHub(ctx context.Context, user goth.User) (goth.User, error) {
+	if s.LinkGitHub == nil {
+		return user, errors.New("GitHub linking is not set up")
+	}
+	id, err := s.LinkGitHub(ctx, user.NickName)
+	if err != nil {
+		return user, err
+	}
+	user.UserID = id
+	return user, nil
+}
+
 /*
 HandleProvidersAPI returns the list of available providers.
 It is used by the frontend to display the list of providers that might
@@ -158,38 +221,66 @@ func (s *Service) HandleProvidersAPI(res http.ResponseWriter, req *http.Request)
 	index := s.GetProviderIndex()
 	res.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(res).Encode(index); err != nil {
-		s.logger.Error("Failed to encode providers response", slog.String("error", err.Error()))
+		s.logger.ErrorContext(req.Context(), "Failed to encode providers response", slog.String("error", err.Error()))
 	}
 }
 
 /*
 HandleUserAPI returns the user information from the session.
-It is used by the frontend to display the user information.
+It is used by the frontend to display the user information. The provider's
+tokens stay on the server.
 */
 func (s *Service) HandleUserAPI(res http.ResponseWriter, req *http.Request) {
 	session, err := s.store.Get(req, "session")
 	if err != nil {
-		s.logger.Warn("HandleUserAPI: Failed to get session", slog.String("error", err.Error()))
-		http.Error(res, "Unauthorized", http.StatusUnauthorized)
+		s.logger.WarnContext(req.Context(), "HandleUserAPI: Failed to get session", slog.String("error", err.Error()))
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeUnauthorized, "Not logged in")
 		return
 	}
 
 	val, ok := session.Values["user"]
 	if !ok {
-		s.logger.Debug("HandleUserAPI: No user in session")
-		http.Error(res, "Unauthorized", http.StatusUnauthorized)
+		s.logger.DebugContext(req.Context(), "HandleUserAPI: No user in session")
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeUnauthorized, "Not logged in")
 		return
 	}
 
 	user, ok := val.(goth.User)
 	if !ok {
-		s.logger.Error("HandleUserAPI: User in session is not goth.User")
-		http.Error(res, "Unauthorized", http.StatusUnauthorized)
+		s.logger.ErrorContext(req.Context(), "HandleUserAPI: User in session is not goth.User")
+		problem.Error(res, req, http.StatusUnauthorized, problem.CodeUnauthorized, "Not logged in")
 		return
 	}
 
 	res.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(res).Encode(user); err != nil {
-		s.logger.Error("Failed to encode user response", slog.String("error", err.Error()))
+	if err := json.NewEncoder(res).Encode(publicUser(user)); err != nil {
+		s.logger.ErrorContext(req.Context(), "Failed to encode user response", slog.String("error", err.Error()))
+	}
+}
+
+// User is the logged-in user as served by /api/user.
+type User struct {
+	UserID      string
+	Provider    string
+	NickName    string
+	Name        string
+	Email       string
+	AvatarURL   string
+	Location    string
+	Description string
+}
+
+// publicUser returns the parts of user the browser may see. The provider's
+// tokens and raw data stay on the server.
+func publicUser(user goth.User) User {
+	return User{
+		UserID:      user.UserID,
+		Provider:    user.Provider,
+		NickName:    user.NickName,
+		Name:        user.Name,
+		Email:       user.Email,
+		AvatarURL:   user.AvatarURL,
+		Location:    user.Location,
+		Description: user.Description,
 	}
 }
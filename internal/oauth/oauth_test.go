@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/RobinMaas95/gh-secret-broker/internal/config"
@@ -15,8 +16,11 @@ import (
 
 func TestGetProviderIndex(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	cfg := &config.Config{SessionSecret: "testsecret"}
-	svc := NewService(logger, cfg)
+	cfg := &config.Config{SessionSecret: "testsecret", LoginProviders: []string{"github"}}
+	svc, err := NewService(logger, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	index := svc.GetProviderIndex()
 	if len(index.Providers) != 1 || index.Providers[0] != "github" {
@@ -29,8 +33,11 @@ func TestGetProviderIndex(t *testing.T) {
 
 func TestHandleProvidersAPI(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	cfg := &config.Config{SessionSecret: "testsecret"}
-	svc := NewService(logger, cfg)
+	cfg := &config.Config{SessionSecret: "testsecret", LoginProviders: []string{"github"}}
+	svc, err := NewService(logger, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	handler := http.HandlerFunc(svc.HandleProvidersAPI)
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -58,8 +65,11 @@ func TestHandleProvidersAPI(t *testing.T) {
 
 func TestHandleUserAPI_NoUser(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	cfg := &config.Config{SessionSecret: "testsecret"}
-	svc := NewService(logger, cfg)
+	cfg := &config.Config{SessionSecret: "testsecret", LoginProviders: []string{"github"}}
+	svc, err := NewService(logger, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	handler := http.HandlerFunc(svc.HandleUserAPI)
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
@@ -74,8 +84,11 @@ func TestHandleUserAPI_NoUser(t *testing.T) {
 
 func TestProviderLogin_NoUser(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
-	cfg := &config.Config{SessionSecret: "testsecret"}
-	svc := NewService(logger, cfg)
+	cfg := &config.Config{SessionSecret: "testsecret", LoginProviders: []string{"github"}}
+	svc, err := NewService(logger, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	handler := http.HandlerFunc(svc.ProviderLogin)
 	req, err := http.NewRequest(http.MethodGet, "/auth/github?provider=github", nil)
@@ -202,7 +215,15 @@ func TestHandleUserAPI_Authenticated(t *testing.T) {
 
 	// Pre-populate session
 	session, _ := store.Get(req, "session")
-	expectedUser := goth.User{UserID: "123", Email: "test@example.com", Name: "Test User"}
+	expectedUser := goth.User{
+		UserID:       "123",
+		Email:        "test@example.com",
+		Name:         "Test User",
+		AccessToken:  "gho_access",
+		RefreshToken: "ghr_refresh",
+		IDToken:      "id-token",
+		RawData:      map[string]any{"private": "raw-data"},
+	}
 	session.Values["user"] = expectedUser
 	_ = session.Save(req, w)
 
@@ -217,7 +238,7 @@ func TestHandleUserAPI_Authenticated(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
-	var gotUser goth.User
+	var gotUser User
 	if err := json.NewDecoder(w.Body).Decode(&gotUser); err != nil {
 		t.Fatalf("Failed to decode JSON: %v", err)
 	}
@@ -225,4 +246,9 @@ func TestHandleUserAPI_Authenticated(t *testing.T) {
 	if gotUser.UserID != expectedUser.UserID {
 		t.Errorf("Expected UserID %s, got %s", expectedUser.UserID, gotUser.UserID)
 	}
+	for _, private := range []string{"gho_access", "ghr_refresh", "id-token", "raw-data"} {
+		if strings.Contains(w.Body.String(), private) {
+			t.Errorf("Expected the response not to contain %q: %s", private, w.Body.String())
+		}
+	}
 }
@@ -0,0 +1,118 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+// OIDCProviderName is the provider name of users who logged in with OIDC.
+// They have no GitHub token; the broker acts for them as their linked GitHub
+// login.
+const OIDCProviderName = "oidc"
+
+// oidcTimeout bounds discovery and key requests.
+const oidcTimeout = 10 * time.Second
+
+// oidcProvider is goth's OpenID Connect provider, which leaves out checking
+// the ID token's signature, together with a verifier that does. The GitHub
+// login of the user is taken from the loginClaim of the verified token.
+type oidcProvider struct {
+	*openidConnect.Provider
+	verifier   *oidc.IDTokenVerifier
+	loginClaim string
+}
+
+// newOIDCProvider discovers the provider at issuer. httpClient is used for
+// every request to the provider, including fetching its keys.
+func newOIDCProvider(ctx context.Context, httpClient *http.Client, issuer, clientID, clientSecret, callbackURL, loginClaim string) (*oidcProvider, error) {
+	discovered, err := oidc.NewProvider(oidc.ClientContext(ctx, httpClient), issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+
+	endpoint := discovered.Endpoint()
+	p, err := openidConnect.NewCustomisedURL(clientID, clientSecret, callbackURL, endpoint.AuthURL, endpoint.TokenURL, issuer, discovered.UserInfoEndpoint(), "", "openid", "profile", "email")
+	if err != nil {
+		return nil, err
+	}
+	p.SetName(OIDCProviderName)
+	p.HTTPClient = httpClient
+
+	return &oidcProvider{
+		Provider:   p,
+		verifier:   discovered.Verifier(&oidc.Config{ClientID: clientID}),
+		loginClaim: loginClaim,
+	}, nil
+}
+
+// oidcSession is goth's session, which only works with goth's provider.
+type oidcSession struct {
+	*openidConnect.Session
+}
+
+func (s oidcSession) Authorize(provider goth.Provider, params goth.Params) (string, error) {
+	if p, ok := provider.(*oidcProvider); ok {
+		provider = p.Provider
+	}
+	return s.Session.Authorize(provider, params)
+}
+
+func (p *oidcProvider) BeginAuth(state string) (goth.Session, error) {
+	sess, err := p.Provider.BeginAuth(state)
+	if err != nil {
+		return nil, err
+	}
+	return oidcSession{sess.(*openidConnect.Session)}, nil
+}
+
+func (p *oidcProvider) UnmarshalSession(data string) (goth.Session, error) {
+	sess, err := p.Provider.UnmarshalSession(data)
+	if err != nil {
+		return nil, err
+	}
+	return oidcSession{sess.(*openidConnect.Session)}, nil
+}
+
+// FetchUser returns goth's user, whose ID token is not verified yet. goth
+// gives providers no request context, so verifyUser does that in the
+// callback.
+func (p *oidcProvider) FetchUser(session goth.Session) (goth.User, error) {
+	wrapped, ok := session.(oidcSession)
+	if !ok || wrapped.IDToken == "" {
+		return goth.User{}, errors.New("OIDC login returned no ID token")
+	}
+	return p.Provider.FetchUser(wrapped.Session)
+}
+
+// verifyUser verifies the ID token of a user returned by FetchUser. NickName
+// is set to the GitHub login; the tokens of the provider are dropped as they
+// are of no use to the broker.
+func (p *oidcProvider) verifyUser(ctx context.Context, user goth.User) (goth.User, error) {
+	token, err := p.verifier.Verify(ctx, user.IDToken)
+	if err != nil {
+		return goth.User{}, fmt.Errorf("verifying ID token: %w", err)
+	}
+	if token.Subject != user.UserID {
+		return goth.User{}, errors.New("the ID token is not the user's")
+	}
+	var claims map[string]any
+	if err := token.Claims(&claims); err != nil {
+		return goth.User{}, err
+	}
+	login, _ := claims[p.loginClaim].(string)
+	if login == "" {
+		return goth.User{}, fmt.Errorf("the ID token has no %s claim", p.loginClaim)
+	}
+
+	user.NickName = login
+	user.AccessToken, user.RefreshToken, user.IDToken = "", "", ""
+	user.RawData = nil
+	return user, nil
+}
@@ -0,0 +1,295 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes of the signing algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+)
+
+// fakeOIDC is an OpenID Connect provider that issues ID tokens with claims
+// for any code. It publishes keys and signs with signer, using alg and
+// naming kid in the header.
+type fakeOIDC struct {
+	*httptest.Server
+	keys   map[string]crypto.PublicKey
+	signer crypto.Signer
+	kid    string
+	alg    string
+	claims map[string]any
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeOIDC{keys: map[string]crypto.PublicKey{"key-1": key.Public()}, signer: key, kid: "key-1", alg: "RS256"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                f.URL,
+			"authorization_endpoint":                f.URL + "/authorize",
+			"token_endpoint":                        f.URL + "/token",
+			"userinfo_endpoint":                     f.URL + "/userinfo",
+			"jwks_uri":                              f.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256", "ES256", "ES384"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		var keys []map[string]string
+		for kid, key := range f.keys {
+			keys = append(keys, publicJWK(kid, key))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "oidc-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     f.sign(t),
+		})
+	})
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"sub": f.claims["sub"], "name": "Mona Lisa"})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	f.claims = map[string]any{
+		"iss":          f.URL,
+		"aud":          "broker",
+		"sub":          "sso-123",
+		"exp":          time.Now().Add(time.Hour).Unix(),
+		"email":        "mona@example.com",
+		"github_login": "octocat",
+	}
+	return f
+}
+
+// publicJWK returns pub as a JSON Web Key.
+func publicJWK(kid string, pub crypto.PublicKey) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": enc(pub.N.Bytes()), "e": enc(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": pub.Curve.Params().Name, "x": enc(pub.X.FillBytes(make([]byte, size))), "y": enc(pub.Y.FillBytes(make([]byte, size)))}
+	}
+	panic("unsupported key")
+}
+
+// sign returns an ID token with the claims of f, signed with its signer and
+// algorithm. ECDSA signatures are sized for the key's curve, whatever
+// the algorithm says.
+func (f *fakeOIDC) sign(t *testing.T) string {
+	header, _ := json.Marshal(map[string]string{"alg": f.alg, "kid": f.kid})
+	payload, _ := json.Marshal(f.claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := crypto.SHA256
+	if f.alg == "ES384" {
+		hash = crypto.SHA384
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	var err error
+	switch key := f.signer.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest)
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// login runs the OIDC login of svc and returns the callback's response and
+// the session cookie.
+func login(t *testing.T, svc *Service) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc", nil)
+	req.SetPathValue("provider", "oidc")
+	w := httptest.NewRecorder()
+	svc.ProviderLogin(w, req)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected a redirect to the provider, got %d: %s", w.Code, w.Body.String())
+	}
+	authURL, _ := url.Parse(w.Header().Get("Location"))
+	state := authURL.Query().Get("state")
+	cookies := w.Result().Cookies()
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?code=code&state="+url.QueryEscape(state), nil)
+	req.SetPathValue("provider", "oidc")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	svc.HandleCallback(w, req)
+
+	var session string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session" {
+			session = c.String()
+		}
+	}
+	return w, session
+}
+
+func TestOIDCLogin(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	newService := func(t *testing.T, f *fakeOIDC) *Service {
+		t.Helper()
+		svc, err := NewService(logger, &config.Config{
+			SessionSecret:    "testsecret",
+			LoginProviders:   []string{"github", "oidc"},
+			OIDCIssuer:       f.URL,
+			OIDCClientID:     "broker",
+			OIDCClientSecret: "secret",
+			OIDCName:         "Company SSO",
+			OIDCGithubClaim:  "github_login",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc.LinkGitHub = func(ctx context.Context, login string) (string, error) {
+			if login != "octocat" {
+				return "", errors.New("unknown GitHub user")
+			}
+			return "42", nil
+		}
+		return svc
+	}
+
+	t.Run("Linked", func(t *testing.T) {
+		svc := newService(t, newFakeOIDC(t))
+
+		index := svc.GetProviderIndex()
+		if strings.Join(index.Providers, ",") != "github,oidc" || index.ProvidersMap["oidc"] != "Company SSO" {
+			t.Errorf("Unexpected providers %+v", index)
+		}
+
+		w, cookie := login(t, svc)
+		if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/" {
+			t.Fatalf("Expected the login to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user", nil)
+		req.Header.Set("Cookie", cookie)
+		w = httptest.NewRecorder()
+		svc.HandleUserAPI(w, req)
+
+		var user User
+		_ = json.NewDecoder(w.Body).Decode(&user)
+		if user.UserID != "42" || user.NickName != "octocat" || user.Provider != "oidc" || user.Email != "mona@example.com" {
+			t.Errorf("Expected the linked GitHub user, got %+v", user)
+		}
+	})
+
+	t.Run("Not linked", func(t *testing.T) {
+		f := newFakeOIDC(t)
+		f.claims["github_login"] = "someone-else"
+		w, _ := login(t, newService(t, f))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("Missing claim", func(t *testing.T) {
+		f := newFakeOIDC(t)
+		delete(f.claims, "github_login")
+		w, _ := login(t, newService(t, f))
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected the login to fail, got %d", w.Code)
+		}
+	})
+
+	t.Run("ES256", func(t *testing.T) {
+		f := newFakeOIDC(t)
+		ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		f.keys["ec-256"], f.signer = ec.Public(), ec
+		f.kid, f.alg = "ec-256", "ES256"
+		w, _ := login(t, newService(t, f))
+		if w.Code != http.StatusTemporaryRedirect {
+			t.Errorf("Expected the login to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Rejected tokens", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			setup func(f *fakeOIDC)
+		}{
+			{
+				name: "Unpublished key",
+				setup: func(f *fakeOIDC) {
+					f.signer, _ = rsa.GenerateKey(rand.Reader, 2048)
+				},
+			},
+			{
+				name: "Curve does not match algorithm",
+				setup: func(f *fakeOIDC) {
+					ec, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+					f.keys["ec-384"], f.signer = ec.Public(), ec
+					f.kid, f.alg = "ec-384", "ES256"
+				},
+			},
+			{
+				name: "Key type does not match algorithm",
+				setup: func(f *fakeOIDC) {
+					// An EC key published under the ID of the RSA key
+					ec, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+					f.keys["ec-256"], f.signer = ec.Public(), ec
+					f.alg = "ES256"
+				},
+			},
+			{
+				name: "Algorithm none",
+				setup: func(f *fakeOIDC) {
+					f.alg = "none"
+				},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				f := newFakeOIDC(t)
+				tt.setup(f)
+				svc := newService(t, f)
+				w, cookie := login(t, svc)
+				if w.Code != http.StatusInternalServerError || cookie != "" {
+					t.Errorf("Expected the login to fail, got %d", w.Code)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,315 @@
+// Package openapi holds the OpenAPI 3 document of the broker's JSON API and
+// validates request bodies against the schemas in it.
+//
+// The document in openapi.json is written by hand. Only the parts needed for
+// validation are parsed; it is served to consumers as is. Schemas support the
+// subset of JSON Schema the document uses: type, properties, required,
+// additionalProperties: false, items, enum, minLength, maxLength, minItems,
+// minimum, maximum and local $ref to components/schemas.
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Spec is the raw OpenAPI document.
+//
+//go:embed openapi.json
+var Spec []byte
+
+// ErrInvalidJSON is returned for request bodies that aren't JSON at all.
+var ErrInvalidJSON = errors.New("request body is not valid JSON")
+
+// ValidationError is a request body that doesn't match its schema. Message
+// names the offending field.
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string { return e.Message }
+
+// methods are the operation keys of a path item.
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// Document is the parsed part of an OpenAPI document.
+type Document struct {
+	// operations maps "METHOD /path/{param}" to its operation.
+	operations map[string]*Operation
+}
+
+// Operation is a single API operation.
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	RequestBody *RequestBody `json:"requestBody"`
+}
+
+// RequestBody describes the body an operation accepts.
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// MediaType holds the schema of one content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON Schema, limited to the keywords listed in the package
+// documentation.
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Properties map[string]*Schema `json:"properties"`
+	Required   []string           `json:"required"`
+	// AdditionalProperties is only enforced when it is false.
+	AdditionalProperties any      `json:"additionalProperties"`
+	Items                *Schema  `json:"items"`
+	Enum                 []any    `json:"enum"`
+	MinLength            *int     `json:"minLength"`
+	MaxLength            *int     `json:"maxLength"`
+	MinItems             *int     `json:"minItems"`
+	Minimum              *float64 `json:"minimum"`
+	Maximum              *float64 `json:"maximum"`
+}
+
+// Load parses the embedded document. It is only parsed once.
+var Load = sync.OnceValues(func() (*Document, error) {
+	return Parse(Spec)
+})
+
+// MustLoad is like Load but panics if the embedded document is invalid, which
+// the package tests rule out.
+func MustLoad() *Document {
+	doc, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// Parse parses an OpenAPI document and resolves the schema references in its
+// request bodies.
+func Parse(data []byte) (*Document, error) {
+	var raw struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]*Schema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI document: %w", err)
+	}
+
+	doc := &Document{operations: map[string]*Operation{}}
+	for path, item := range raw.Paths {
+		for method, data := range item {
+			if !slices.Contains(methods, method) {
+				continue
+			}
+			var op Operation
+			if err := json.Unmarshal(data, &op); err != nil {
+				return nil, fmt.Errorf("parsing %s %s: %w", method, path, err)
+			}
+			if op.RequestBody != nil {
+				for _, media := range op.RequestBody.Content {
+					if err := resolve(media.Schema, raw.Components.Schemas, nil); err != nil {
+						return nil, fmt.Errorf("%s %s: %w", method, path, err)
+					}
+				}
+			}
+			doc.operations[strings.ToUpper(method)+" "+path] = &op
+		}
+	}
+	return doc, nil
+}
+
+// resolve replaces references in s by the schemas they point to. seen guards
+// against recursive schemas, which aren't supported.
+func resolve(s *Schema, components map[string]*Schema, seen []string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		target := components[name]
+		if !ok || target == nil {
+			return fmt.Errorf("unresolvable schema reference %q", s.Ref)
+		}
+		if slices.Contains(seen, name) {
+			return fmt.Errorf("recursive schema %q", name)
+		}
+		seen = append(seen, name)
+		*s = *target
+	}
+	for _, p := range s.Properties {
+		if err := resolve(p, components, seen); err != nil {
+			return err
+		}
+	}
+	return resolve(s.Items, components, seen)
+}
+
+// Routes returns all operations as "METHOD /path/{param}", sorted. These
+// match the patterns of an http.ServeMux.
+func (d *Document) Routes() []string {
+	routes := make([]string, 0, len(d.operations))
+	for route := range d.operations {
+		routes = append(routes, route)
+	}
+	slices.Sort(routes)
+	return routes
+}
+
+// Operation returns the operation of a ServeMux pattern such as
+// "PUT /api/repo/{owner}/{repo}/secrets/{name}", or nil if the document
+// doesn't describe it.
+func (d *Document) Operation(pattern string) *Operation {
+	return d.operations[pattern]
+}
+
+// ValidateBody checks a JSON request body against the operation's schema.
+// Operations without a request body accept any body.
+func (o *Operation) ValidateBody(body []byte) error {
+	if o.RequestBody == nil {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if o.RequestBody.Required {
+			return &ValidationError{Message: "a request body is required"}
+		}
+		return nil
+	}
+	media, ok := o.RequestBody.Content["application/json"]
+	if !ok || media.Schema == nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return ErrInvalidJSON
+	}
+	return media.Schema.Validate(v)
+}
+
+// Validate checks a value decoded from JSON with UseNumber against s.
+func (s *Schema) Validate(v any) error {
+	if msg := s.validate("request body", v); msg != "" {
+		return &ValidationError{Message: msg}
+	}
+	return nil
+}
+
+// validate returns a message about the first problem of v, or "".
+func (s *Schema) validate(path string, v any) string {
+	if s.Type != "" && !hasType(v, s.Type) {
+		return fmt.Sprintf("%s must be of type %s", path, s.Type)
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return fmt.Sprint(e) == fmt.Sprint(v) }) {
+		values := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			values[i] = fmt.Sprint(e)
+		}
+		return fmt.Sprintf("%s must be one of %s", path, strings.Join(values, ", "))
+	}
+
+	switch v := v.(type) {
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Sprintf("%s must be at least %d characters long", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Sprintf("%s must be at most %d characters long", path, *s.MaxLength)
+		}
+	case json.Number:
+		f, _ := v.Float64()
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Sprintf("%s must be at least %v", path, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fmt.Sprintf("%s must be at most %v", path, *s.Maximum)
+		}
+	case []any:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			return fmt.Sprintf("%s must have at least %d items", path, *s.MinItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				if msg := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); msg != "" {
+					return msg
+				}
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Sprintf("%s is required", field(path, name))
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties == false {
+					return fmt.Sprintf("%s is not a known field", field(path, name))
+				}
+				continue
+			}
+			if msg := prop.validate(field(path, name), v[name]); msg != "" {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+// field names a property; top-level fields are named without a prefix.
+func field(path, name string) string {
+	if path == "request body" {
+		return name
+	}
+	return path + "." + name
+}
+
+func hasType(v any, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "null":
+		return v == nil
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package openapi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatalf("embedded document is invalid: %v", err)
+	}
+
+	seen := map[string]string{}
+	for _, route := range doc.Routes() {
+		op := doc.Operation(route)
+		if op.OperationID == "" {
+			t.Errorf("%s has no operationId", route)
+		}
+		if other, ok := seen[op.OperationID]; ok {
+			t.Errorf("%s and %s share the operationId %q", route, other, op.OperationID)
+		}
+		seen[op.OperationID] = route
+	}
+}
+
+func TestParse_UnresolvableReference(t *testing.T) {
+	_, err := Parse([]byte(`{"paths": {"/api/x": {"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Missing"}}}}}}}}`))
+	if err == nil {
+		t.Error("expected an error for a missing schema")
+	}
+}
+
+func TestValidateBody(t *testing.T) {
+	doc := MustLoad()
+	createToken := doc.Operation("POST /api/tokens")
+	setSecret := doc.Operation("PUT /api/repo/{owner}/{repo}/secrets/{name}")
+	if createToken == nil || setSecret == nil {
+		t.Fatal("expected the operations to be documented")
+	}
+
+	tests := []struct {
+		name string
+		op   *Operation
+		body string
+		want string
+	}{
+		{"Valid", createToken, `{"name": "ci", "repositories": ["org/*"], "actions": ["secrets:read"], "expires_in_days": 7}`, ""},
+		{"Missing field", createToken, `{"name": "ci", "actions": ["secrets:read"]}`, "repositories is required"},
+		{"Unknown field", setSecret, `{"value": "v", "visibility": "all"}`, "visibility is not a known field"},
+		{"Wrong type", setSecret, `{"value": 42}`, "value must be of type string"},
+		{"Too short", setSecret, `{"value": ""}`, "value must be at least 1 characters long"},
+		{"Enum", createToken, `{"name": "ci", "repositories": ["org/*"], "actions": ["secrets:admin"]}`, "actions[0] must be one of secrets:read, secrets:write, secrets:delete"},
+		{"Not an integer", createToken, `{"name": "ci", "repositories": ["org/*"], "actions": ["secrets:read"], "expires_in_days": 1.5}`, "expires_in_days must be of type integer"},
+		{"Maximum", createToken, `{"name": "ci", "repositories": ["org/*"], "actions": ["secrets:read"], "expires_in_days": 1000}`, "expires_in_days must be at most 365"},
+		{"Empty array", createToken, `{"name": "ci", "repositories": [], "actions": ["secrets:read"]}`, "repositories must have at least 1 items"},
+		{"Missing body", setSecret, ``, "a request body is required"},
+		{"Not an object", setSecret, `[]`, "request body must be of type object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op.ValidateBody([]byte(tt.body))
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) || verr.Message != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if err := setSecret.ValidateBody([]byte(`{"value": `)); !errors.Is(err, ErrInvalidJSON) {
+		t.Errorf("expected ErrInvalidJSON, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Package problem writes RFC 9457 problem details, the error format of the
+// broker's JSON API. Every problem carries a machine-readable code that
+// clients can switch on, and the request ID for support.
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+)
+
+// ContentType is the media type of problem details.
+const ContentType = "application/problem+json"
+
+// Codes for problems that don't come from the repository package.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeInternal     = "internal_error"
+	CodeCSRFFailed   = "csrf_failed"
+)
+
+// Details is a problem details object.
+type Details struct {
+	Type      string     `json:"type"`
+	Title     string     `json:"title"`
+	Status    int        `json:"status"`
+	Detail    string     `json:"detail,omitempty"`
+	Code      string     `json:"code"`
+	RequestID string     `json:"request_id,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+}
+
+// Write sends d. Type and Title default to "about:blank" and the status text,
+// the request ID is taken from the request context.
+func Write(w http.ResponseWriter, r *http.Request, d Details) {
+	if d.Type == "" {
+		d.Type = "about:blank"
+	}
+	if d.Title == "" {
+		d.Title = http.StatusText(d.Status)
+	}
+	if d.RequestID == "" {
+		d.RequestID = logging.RequestID(r.Context())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(d.Status)
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+// Error writes a problem with status, code and a user-facing detail message.
+func Error(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	Write(w, r, Details{Status: status, Code: code, Detail: detail})
+}
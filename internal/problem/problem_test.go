@@ -0,0 +1,35 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/logging"
+)
+
+func TestError(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/user", nil)
+	req = req.WithContext(logging.WithRequestID(context.Background(), "req-1"))
+	w := httptest.NewRecorder()
+
+	Error(w, req, http.StatusUnauthorized, CodeUnauthorized, "Not logged in")
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+	}
+
+	var d Details
+	if err := json.NewDecoder(w.Body).Decode(&d); err != nil {
+		t.Fatal(err)
+	}
+	want := Details{Type: "about:blank", Title: "Unauthorized", Status: 401, Detail: "Not logged in", Code: CodeUnauthorized, RequestID: "req-1"}
+	if d != want {
+		t.Errorf("got %+v, want %+v", d, want)
+	}
+}
@@ -0,0 +1,321 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v80/github"
+)
+
+// ttlCache is a map whose entries expire after ttl. A nil *ttlCache stores
+// nothing. Expired entries are dropped when read, and all of them at most once
+// per ttl when writing, so keys that are never read again don't pile up.
+type ttlCache[V any] struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]ttlEntry[V]
+	nextSweep time.Time
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{ttl: ttl, now: time.Now, entries: make(map[string]ttlEntry[V])}
+}
+
+func (c *ttlCache[V]) get(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return zero, false
+	}
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *ttlCache[V]) set(key string, value V) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if !now.Before(c.nextSweep) {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.nextSweep = now.Add(c.ttl)
+	}
+	c.entries[key] = ttlEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// deleteFunc drops the entries whose key matches.
+func (c *ttlCache[V]) deleteFunc(match func(key string) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if match(key) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx that identifies the user on whose behalf
+// GitHub is called. CachedService only caches per-user results, such as
+// repository lists and access decisions, for requests that carry a user.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+func userFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// CachedService is a RepositoryService that caches the results of another
+// one for a TTL:
+//   - the maintainable repositories, per user and set of orgs
+//   - access decisions, per user and repository
+//   - secret names, per repository
+//   - org ownership and team memberships, per user
+//
+// Secret writes drop the cached secret names of the repository.
+type CachedService struct {
+	next RepositoryService
+
+	repos      *ttlCache[[]*github.Repository]
+	access     *ttlCache[bool]
+	secrets    *ttlCache[[]string]
+	membership *ttlCache[bool]
+}
+
+// NewCachedService wraps next, caching its results for ttl.
+func NewCachedService(next RepositoryService, ttl time.Duration) *CachedService {
+	return &CachedService{
+		next:       next,
+		repos:      newTTLCache[[]*github.Repository](ttl),
+		access:     newTTLCache[bool](ttl),
+		secrets:    newTTLCache[[]string](ttl),
+		membership: newTTLCache[bool](ttl),
+	}
+}
+
+// Keys are lower case because GitHub logins and repository names are
+// case-insensitive. Parts are separated by a NUL byte, which can't occur in
+// any of them.
+func repoKey(owner, repo string) string {
+	return strings.ToLower(owner + "/" + repo)
+}
+
+func userRepoKey(user, owner, repo string) string {
+	return user + "\x00" + repoKey(owner, repo)
+}
+
+func (s *CachedService) ListMaintainableRepositories(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
+	user := userFromContext(ctx)
+	if user == "" {
+		return s.next.ListMaintainableRepositories(ctx, client, orgNames...)
+	}
+
+	orgs := make([]string, len(orgNames))
+	for i, org := range orgNames {
+		orgs[i] = strings.ToLower(org)
+	}
+	slices.Sort(orgs)
+	key := user + "\x00" + strings.Join(orgs, ",")
+
+	if repos, ok := s.repos.get(key); ok {
+		return slices.Clone(repos), nil
+	}
+	repos, err := s.next.ListMaintainableRepositories(ctx, client, orgNames...)
+	if err != nil {
+		return nil, err
+	}
+	s.repos.set(key, repos)
+	return slices.Clone(repos), nil
+}
+
+func (s *CachedService) HasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+	user := userFromContext(ctx)
+	if user == "" {
+		return s.next.HasMaintainerAccess(ctx, client, owner, repo)
+	}
+
+	key := userRepoKey(user, owner, repo)
+	if ok, cached := s.access.get(key); cached {
+		return ok, nil
+	}
+	ok, err := s.next.HasMaintainerAccess(ctx, client, owner, repo)
+	if err != nil {
+		return false, err
+	}
+	s.access.set(key, ok)
+	return ok, nil
+}
+
+// UserHasMaintainerAccess shares the access decisions of HasMaintainerAccess;
+// both answer the same question for the user in ctx.
+func (s *CachedService) UserHasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+	user := userFromContext(ctx)
+	if user == "" {
+		return s.next.UserHasMaintainerAccess(ctx, client, owner, repo, login)
+	}
+
+	key := userRepoKey(user, owner, repo)
+	if ok, cached := s.access.get(key); cached {
+		return ok, nil
+	}
+	ok, err := s.next.UserHasMaintainerAccess(ctx, client, owner, repo, login)
+	if err != nil {
+		return false, err
+	}
+	s.access.set(key, ok)
+	return ok, nil
+}
+
+// IsOrgMember is not cached; it is only asked on device logins.
+func (s *CachedService) IsOrgMember(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+	return s.next.IsOrgMember(ctx, client, org, login)
+}
+
+func (s *CachedService) IsOrgOwner(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+	return s.cachedMembership(ctx, "owner\x00"+strings.ToLower(org), func() (bool, error) {
+		return s.next.IsOrgOwner(ctx, client, org, login)
+	})
+}
+
+func (s *CachedService) IsTeamMember(ctx context.Context, client *github.Client, org, team, login string) (bool, error) {
+	return s.cachedMembership(ctx, "team\x00"+strings.ToLower(org+"/"+team), func() (bool, error) {
+		return s.next.IsTeamMember(ctx, client, org, team, login)
+	})
+}
+
+// cachedMembership caches the answer of lookup for the user in ctx.
+func (s *CachedService) cachedMembership(ctx context.Context, what string, lookup func() (bool, error)) (bool, error) {
+	user := userFromContext(ctx)
+	if user == "" {
+		return lookup()
+	}
+
+	key := user + "\x00" + what
+	if ok, cached := s.membership.get(key); cached {
+		return ok, nil
+	}
+	ok, err := lookup()
+	if err != nil {
+		return false, err
+	}
+	s.membership.set(key, ok)
+	return ok, nil
+}
+
+func (s *CachedService) ListSecrets(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+	key := repoKey(owner, repo)
+	if names, ok := s.secrets.get(key); ok {
+		return slices.Clone(names), nil
+	}
+	names, err := s.next.ListSecrets(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	s.secrets.set(key, names)
+	return slices.Clone(names), nil
+}
+
+// ListOrgRepositories is not cached; it is only used for audits, which
+// should see the current state.
+func (s *CachedService) ListOrgRepositories(ctx context.Context, client *github.Client, org string) ([]*github.Repository, error) {
+	return s.next.ListOrgRepositories(ctx, client, org)
+}
+
+// ListSecretMetadata is not cached, for the same reason.
+func (s *CachedService) ListSecretMetadata(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error) {
+	return s.next.ListSecretMetadata(ctx, client, owner, repo)
+}
+
+// GetFileContent is not cached; repositories change their files at any time.
+func (s *CachedService) GetFileContent(ctx context.Context, client *github.Client, owner, repo, path string) ([]byte, error) {
+	return s.next.GetFileContent(ctx, client, owner, repo, path)
+}
+
+func (s *CachedService) DeleteSecret(ctx context.Context, client *github.Client, owner, repo, name string) error {
+	defer s.secrets.deleteFunc(func(key string) bool { return key == repoKey(owner, repo) })
+	return s.next.DeleteSecret(ctx, client, owner, repo, name)
+}
+
+func (s *CachedService) CreateOrUpdateSecret(ctx context.Context, client *github.Client, owner, repo, name, value string) error {
+	defer s.secrets.deleteFunc(func(key string) bool { return key == repoKey(owner, repo) })
+	return s.next.CreateOrUpdateSecret(ctx, client, owner, repo, name, value)
+}
+
+// InvalidateRepo drops everything cached about a repository: its secret
+// names, all access decisions and its public key. Use it when the repository
+// changed outside the broker.
+func (s *CachedService) InvalidateRepo(owner, repo string) {
+	rk := repoKey(owner, repo)
+	s.secrets.deleteFunc(func(key string) bool { return key == rk })
+	s.access.deleteFunc(func(key string) bool { return strings.HasSuffix(key, "\x00"+rk) })
+	if inv, ok := s.next.(interface{ InvalidatePublicKey(owner, repo string) }); ok {
+		inv.InvalidatePublicKey(owner, repo)
+	}
+}
+
+// InvalidateUser drops the repository lists, access decisions and
+// memberships of user.
+func (s *CachedService) InvalidateUser(user string) {
+	prefix := user + "\x00"
+	match := func(key string) bool { return strings.HasPrefix(key, prefix) }
+	s.repos.deleteFunc(match)
+	s.access.deleteFunc(match)
+	s.membership.deleteFunc(match)
+}
+
+// InvalidateRepositoryLists drops the repository lists of all users, e.g.
+// after a repository was created, renamed or transferred.
+func (s *CachedService) InvalidateRepositoryLists() {
+	s.repos.deleteFunc(func(string) bool { return true })
+}
+
+// InvalidateAccess drops all access decisions, repository lists and
+// memberships, e.g. after team permissions changed.
+func (s *CachedService) InvalidateAccess() {
+	all := func(string) bool { return true }
+	s.repos.deleteFunc(all)
+	s.access.deleteFunc(all)
+	s.membership.deleteFunc(all)
+}
+
+// InvalidateAll empties the caches.
+func (s *CachedService) InvalidateAll() {
+	all := func(string) bool { return true }
+	s.repos.deleteFunc(all)
+	s.access.deleteFunc(all)
+	s.secrets.deleteFunc(all)
+	s.membership.deleteFunc(all)
+	if inv, ok := s.next.(interface{ InvalidatePublicKeys() }); ok {
+		inv.InvalidatePublicKeys()
+	}
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTTLCacheSweep(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newTTLCache[bool](time.Minute)
+	c.now = func() time.Time { return now }
+
+	// Keys that are never read again, e.g. of users who left
+	for i := range 100 {
+		c.set(fmt.Sprint(i), true)
+	}
+	now = now.Add(time.Minute)
+	c.set("fresh", true)
+
+	if len(c.entries) != 1 {
+		t.Errorf("expected the expired entries to be swept, got %d entries", len(c.entries))
+	}
+	if _, ok := c.get("fresh"); !ok {
+		t.Error("expected the fresh entry to be kept")
+	}
+}
@@ -0,0 +1,180 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/google/go-github/v80/github"
+)
+
+// countingService counts the calls that reach it.
+type countingService struct {
+	calls map[string]int
+}
+
+func (s *countingService) ListMaintainableRepositories(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
+	s.calls["repos"]++
+	return []*github.Repository{{Name: github.Ptr("repo-1")}}, nil
+}
+
+func (s *countingService) ListSecrets(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+	s.calls["secrets"]++
+	return []string{"SECRET"}, nil
+}
+
+func (s *countingService) ListOrgRepositories(ctx context.Context, client *github.Client, org string) ([]*github.Repository, error) {
+	s.calls["org_repos"]++
+	return []*github.Repository{{Name: github.Ptr("repo-1")}}, nil
+}
+
+func (s *countingService) ListSecretMetadata(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error) {
+	s.calls["metadata"]++
+	return []*github.Secret{{Name: "SECRET"}}, nil
+}
+
+func (s *countingService) GetFileContent(ctx context.Context, client *github.Client, owner, repo, path string) ([]byte, error) {
+	s.calls["file"]++
+	return nil, nil
+}
+
+func (s *countingService) DeleteSecret(ctx context.Context, client *github.Client, owner, repo, name string) error {
+	s.calls["delete"]++
+	return nil
+}
+
+func (s *countingService) CreateOrUpdateSecret(ctx context.Context, client *github.Client, owner, repo, name, value string) error {
+	s.calls["create"]++
+	return nil
+}
+
+func (s *countingService) HasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
+	s.calls["access"]++
+	return true, nil
+}
+
+func (s *countingService) UserHasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+	s.calls["access"]++
+	return true, nil
+}
+
+func (s *countingService) IsOrgMember(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+	s.calls["member"]++
+	return true, nil
+}
+
+func (s *countingService) IsOrgOwner(ctx context.Context, client *github.Client, org, login string) (bool, error) {
+	s.calls["owner"]++
+	return false, nil
+}
+
+func (s *countingService) IsTeamMember(ctx context.Context, client *github.Client, org, team, login string) (bool, error) {
+	s.calls["team"]++
+	return true, nil
+}
+
+func TestCachedService(t *testing.T) {
+	ctx := repository.WithUser(context.Background(), "42")
+
+	t.Run("Repositories are cached per user", func(t *testing.T) {
+		next := &countingService{calls: map[string]int{}}
+		s := repository.NewCachedService(next, time.Minute)
+
+		_, _ = s.ListMaintainableRepositories(ctx, nil, "org-a", "org-b")
+		_, _ = s.ListMaintainableRepositories(ctx, nil, "ORG-B", "org-a")
+		if next.calls["repos"] != 1 {
+			t.Errorf("expected 1 upstream call, got %d", next.calls["repos"])
+		}
+
+		_, _ = s.ListMaintainableRepositories(repository.WithUser(context.Background(), "43"), nil, "org-a", "org-b")
+		if next.calls["repos"] != 2 {
+			t.Errorf("expected another user to miss the cache, got %d calls", next.calls["repos"])
+		}
+
+		s.InvalidateUser("42")
+		_, _ = s.ListMaintainableRepositories(ctx, nil, "org-a", "org-b")
+		if next.calls["repos"] != 3 {
+			t.Errorf("expected a call after invalidation, got %d", next.calls["repos"])
+		}
+	})
+
+	t.Run("Requests without a user are not cached", func(t *testing.T) {
+		next := &countingService{calls: map[string]int{}}
+		s := repository.NewCachedService(next, time.Minute)
+
+		_, _ = s.HasMaintainerAccess(context.Background(), nil, "org", "repo")
+		_, _ = s.HasMaintainerAccess(context.Background(), nil, "org", "repo")
+		if next.calls["access"] != 2 {
+			t.Errorf("expected 2 upstream calls, got %d", next.calls["access"])
+		}
+	})
+
+	t.Run("Access decisions are dropped with the repository", func(t *testing.T) {
+		next := &countingService{calls: map[string]int{}}
+		s := repository.NewCachedService(next, time.Minute)
+
+		_, _ = s.HasMaintainerAccess(ctx, nil, "org", "repo")
+		_, _ = s.HasMaintainerAccess(ctx, nil, "Org", "Repo")
+		if next.calls["access"] != 1 {
+			t.Errorf("expected 1 upstream call, got %d", next.calls["access"])
+		}
+
+		s.InvalidateRepo("org", "repo")
+		_, _ = s.HasMaintainerAccess(ctx, nil, "org", "repo")
+		if next.calls["access"] != 2 {
+			t.Errorf("expected a call after invalidation, got %d", next.calls["access"])
+		}
+	})
+
+	t.Run("Secret writes invalidate the secret list", func(t *testing.T) {
+		next := &countingService{calls: map[string]int{}}
+		s := repository.NewCachedService(next, time.Minute)
+
+		_, _ = s.ListSecrets(ctx, nil, "org", "repo")
+		_, _ = s.ListSecrets(ctx, nil, "org", "repo")
+		if next.calls["secrets"] != 1 {
+			t.Errorf("expected 1 upstream call, got %d", next.calls["secrets"])
+		}
+
+		_ = s.CreateOrUpdateSecret(ctx, nil, "org", "repo", "NEW", "value")
+		_, _ = s.ListSecrets(ctx, nil, "org", "repo")
+		_ = s.DeleteSecret(ctx, nil, "org", "repo", "NEW")
+		_, _ = s.ListSecrets(ctx, nil, "org", "repo")
+		if next.calls["secrets"] != 3 {
+			t.Errorf("expected a call after each write, got %d", next.calls["secrets"])
+		}
+	})
+
+	t.Run("Memberships are cached per user", func(t *testing.T) {
+		next := &countingService{calls: map[string]int{}}
+		s := repository.NewCachedService(next, time.Minute)
+
+		_, _ = s.IsTeamMember(ctx, nil, "org", "admins", "octocat")
+		_, _ = s.IsTeamMember(ctx, nil, "ORG", "admins", "octocat")
+		_, _ = s.IsTeamMember(ctx, nil, "org", "auditors", "octocat")
+		_, _ = s.IsOrgOwner(ctx, nil, "org", "octocat")
+		_, _ = s.IsOrgOwner(ctx, nil, "org", "octocat")
+		if next.calls["team"] != 2 || next.calls["owner"] != 1 {
+			t.Errorf("expected 2 team and 1 owner calls, got %v", next.calls)
+		}
+
+		s.InvalidateAccess()
+		_, _ = s.IsOrgOwner(ctx, nil, "org", "octocat")
+		if next.calls["owner"] != 2 {
+			t.Errorf("expected access changes to drop memberships, got %d calls", next.calls["owner"])
+		}
+	})
+
+	t.Run("Entries expire", func(t *testing.T) {
+		next := &countingService{calls: map[string]int{}}
+		s := repository.NewCachedService(next, time.Millisecond)
+
+		_, _ = s.ListSecrets(ctx, nil, "org", "repo")
+		time.Sleep(5 * time.Millisecond)
+		_, _ = s.ListSecrets(ctx, nil, "org", "repo")
+		if next.calls["secrets"] != 2 {
+			t.Errorf("expected expired entry to be refetched, got %d calls", next.calls["secrets"])
+		}
+	})
+}
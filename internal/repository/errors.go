@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v80/github"
+)
+
+// Code classifies the errors returned by Service. Codes are part of the API:
+// they are sent to clients as the machine-readable error code.
+type Code string
+
+const (
+	CodeNotFound    Code = "not_found"
+	CodeForbidden   Code = "forbidden"
+	CodeValidation  Code = "validation_failed"
+	CodeUpstream    Code = "upstream_error"
+	CodeRateLimited Code = "rate_limited"
+)
+
+// Error is an error returned by Service.
+type Error struct {
+	Code Code
+	// Message describes the error for users. Unlike Err it never contains
+	// upstream details.
+	Message string
+	// RetryAt is set for CodeRateLimited.
+	RetryAt time.Time
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrNotFound) etc. match any Error with that code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Message == "" && t.Code == e.Code
+}
+
+// Sentinels to test for with errors.Is.
+var (
+	ErrNotFound    = &Error{Code: CodeNotFound}
+	ErrForbidden   = &Error{Code: CodeForbidden}
+	ErrValidation  = &Error{Code: CodeValidation}
+	ErrUpstream    = &Error{Code: CodeUpstream}
+	ErrRateLimited = &Error{Code: CodeRateLimited}
+)
+
+// validationError returns a CodeValidation error with a user-facing message.
+func validationError(format string, args ...any) error {
+	return &Error{Code: CodeValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+// classify turns an error from go-github into an *Error. nil stays nil and
+// errors that are already classified are returned as they are.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return &Error{Code: CodeRateLimited, Message: "GitHub rate limit exceeded", RetryAt: rateErr.Rate.Reset.Time, Err: err}
+	}
+	now := time.Now()
+	if wait, limited := RetryAfter(err, now); limited {
+		return &Error{Code: CodeRateLimited, Message: "GitHub secondary rate limit exceeded", RetryAt: now.Add(wait), Err: err}
+	}
+
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) && ghErr.Response != nil {
+		switch ghErr.Response.StatusCode {
+		case http.StatusNotFound:
+			// GitHub answers 404 for private repositories the caller can't see
+			return &Error{Code: CodeNotFound, Message: "Repository or secret not found", Err: err}
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return &Error{Code: CodeForbidden, Message: "GitHub denied access", Err: err}
+		case http.StatusUnprocessableEntity:
+			return &Error{Code: CodeValidation, Message: "GitHub rejected the request", Err: err}
+		}
+	}
+	return &Error{Code: CodeUpstream, Message: "GitHub request failed", Err: err}
+}
+
+// ValidateSecretName checks name against GitHub's rules for secret names.
+func ValidateSecretName(name string) error {
+	if name == "" {
+		return validationError("Secret name is required")
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return validationError("Secret names can only contain letters, numbers and underscores")
+		}
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		return validationError("Secret names must not start with a number")
+	}
+	if strings.HasPrefix(strings.ToUpper(name), "GITHUB_") {
+		return validationError("Secret names must not start with GITHUB_")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/google/go-github/v80/github"
+)
+
+func TestServiceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   error
+	}{
+		{name: "Not found", status: http.StatusNotFound, want: repository.ErrNotFound},
+		{name: "Forbidden", status: http.StatusForbidden, want: repository.ErrForbidden},
+		{name: "Unauthorized", status: http.StatusUnauthorized, want: repository.ErrForbidden},
+		{name: "Unprocessable", status: http.StatusUnprocessableEntity, want: repository.ErrValidation},
+		{name: "Server error", status: http.StatusBadGateway, want: repository.ErrUpstream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"message": "nope"}`))
+			}))
+			defer server.Close()
+
+			client := github.NewClient(nil)
+			client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+			_, err := repository.NewService().ListSecrets(context.Background(), client, "TargetOrg", "repo-1")
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			var e *repository.Error
+			if !errors.As(err, &e) || e.Message == "" {
+				t.Errorf("expected an *Error with a message, got %#v", err)
+			}
+			var ghErr *github.ErrorResponse
+			if !errors.As(err, &ghErr) {
+				t.Error("expected the GitHub error to be wrapped")
+			}
+		})
+	}
+}
+
+func TestValidateSecretName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "API_KEY", valid: true},
+		{name: "api_key_2", valid: true},
+		{name: "", valid: false},
+		{name: "API-KEY", valid: false},
+		{name: "2FA_SEED", valid: false},
+		{name: "github_token", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := repository.ValidateSecretName(tt.name)
+			if tt.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, repository.ErrValidation) {
+				t.Errorf("expected a validation error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/go-github/v80/github"
+)
+
+const (
+	// maxRetryWait is the longest a call waits for a rate limit before the
+	// error is returned instead. Requests have to finish within the server's
+	// write timeout.
+	maxRetryWait = 3 * time.Second
+	maxAttempts  = 3
+	// retryBackoff is the first wait if GitHub didn't say how long to wait.
+	retryBackoff = 500 * time.Millisecond
+	// secondaryLimitWait is GitHub's advice for secondary rate limits without
+	// a Retry-After header.
+	secondaryLimitWait = time.Minute
+)
+
+// RetryAfter reports whether err is a GitHub rate-limit error and how long
+// after now the request may be retried.
+func RetryAfter(err error, now time.Time) (time.Duration, bool) {
+	var e *Error
+	if errors.As(err, &e) && e.Code == CodeRateLimited {
+		return max(e.RetryAt.Sub(now), 0), true
+	}
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return max(rateErr.Rate.Reset.Sub(now), 0), true
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if abuseErr.RetryAfter != nil {
+			return *abuseErr.RetryAfter, true
+		}
+		return secondaryLimitWait, true
+	}
+	return 0, false
+}
+
+// withRetry runs call and repeats it while it fails with a rate-limit error
+// that clears within maxRetryWait. The final error is classified. Every call
+// the broker makes is a read or an idempotent write (PUT/DELETE of a secret),
+// so repeating one is safe; a rate-limited request wasn't executed by GitHub
+// in the first place.
+func withRetry(ctx context.Context, call func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		wait, limited := RetryAfter(err, time.Now())
+		if !limited || attempt == maxAttempts || wait > maxRetryWait {
+			return classify(err)
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return classify(err)
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/google/go-github/v80/github"
+)
+
+func TestListSecrets_RetriesSecondaryRateLimit(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message": "You have exceeded a secondary rate limit", "documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"total_count": 1, "secrets": [{"name": "SECRET_ONE"}]}`))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	secrets, err := repository.NewService().ListSecrets(context.Background(), client, "TargetOrg", "repo-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(secrets) != 1 || calls != 2 {
+		t.Errorf("expected 1 secret after 2 calls, got %d secrets after %d calls", len(secrets), calls)
+	}
+}
+
+func TestListSecrets_ReturnsLongRateLimit(t *testing.T) {
+	calls := 0
+	reset := time.Now().Add(10 * time.Minute).Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message": "API rate limit exceeded"}`))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	_, err := repository.NewService().ListSecrets(context.Background(), client, "TargetOrg", "repo-1")
+	var rateErr *github.RateLimitError
+	if !errors.As(err, &rateErr) {
+		t.Fatalf("expected a RateLimitError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected no retry for a long wait, got %d calls", calls)
+	}
+
+	wait, limited := repository.RetryAfter(err, reset.Add(-time.Minute))
+	if !limited || wait != time.Minute {
+		t.Errorf("RetryAfter() = %v, %v; want 1m, true", wait, limited)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	retryAfter := 30 * time.Second
+	tests := []struct {
+		name    string
+		err     error
+		want    time.Duration
+		limited bool
+	}{
+		{name: "Other error", err: errors.New("boom")},
+		{name: "Secondary limit with Retry-After", err: &github.AbuseRateLimitError{RetryAfter: &retryAfter}, want: retryAfter, limited: true},
+		{name: "Secondary limit without Retry-After", err: &github.AbuseRateLimitError{}, want: time.Minute, limited: true},
+		{name: "Primary limit already reset", err: &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(-time.Minute)}}}, limited: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, limited := repository.RetryAfter(tt.err, time.Now())
+			if got != tt.want || limited != tt.limited {
+				t.Errorf("RetryAfter() = %v, %v; want %v, %v", got, limited, tt.want, tt.limited)
+			}
+		})
+	}
+}
@@ -4,32 +4,111 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v80/github"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/nacl/box"
 )
 
+// tracerName identifies the spans created by this package.
+const tracerName = "github.com/RobinMaas95/gh-secret-broker/internal/repository"
+
+// startSpan starts a span for an operation on the repository owner/repo.
+// The tracer is looked up on every call so that it always uses the current
+// global provider; spans are no-ops until tracing has been set up.
+func startSpan(ctx context.Context, name, owner, repo string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(
+		attribute.String("github.owner", owner),
+		attribute.String("github.repo", repo),
+	))
+}
+
+// endSpan records err on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
 // RepositoryService defines the interface for repository operations.
 // This allows for mocking in tests.
+//
+// Errors returned by the methods are *Error values; test for them with
+// errors.Is(err, ErrNotFound) etc.
 type RepositoryService interface {
-	ListMaintainableRepositories(ctx context.Context, client *github.Client, orgName string) ([]*github.Repository, error)
+	ListMaintainableRepositories(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error)
+	ListOrgRepositories(ctx context.Context, client *github.Client, org string) ([]*github.Repository, error)
 	ListSecrets(ctx context.Context, client *github.Client, owner, repo string) ([]string, error)
+	ListSecretMetadata(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error)
+	GetFileContent(ctx context.Context, client *github.Client, owner, repo, path string) ([]byte, error)
 	DeleteSecret(ctx context.Context, client *github.Client, owner, repo, name string) error
 	CreateOrUpdateSecret(ctx context.Context, client *github.Client, owner, repo, name, value string) error
 	HasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo string) (bool, error)
+	UserHasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error)
+	IsOrgMember(ctx context.Context, client *github.Client, org, login string) (bool, error)
+	IsOrgOwner(ctx context.Context, client *github.Client, org, login string) (bool, error)
+	IsTeamMember(ctx context.Context, client *github.Client, org, team, login string) (bool, error)
 }
 
-type Service struct{}
+// publicKeyTTL is how long a repository's secrets public key is reused.
+// GitHub rotates keys rarely; a write it rejects refetches the key anyway.
+const publicKeyTTL = time.Hour
+
+type Service struct {
+	// publicKeys caches the secrets public key per repository
+	publicKeys *ttlCache[*github.PublicKey]
+}
 
 func NewService() *Service {
-	return &Service{}
+	return &Service{publicKeys: newTTLCache[*github.PublicKey](publicKeyTTL)}
+}
+
+// InvalidatePublicKey drops the cached public key of a repository.
+func (s *Service) InvalidatePublicKey(owner, repo string) {
+	rk := repoKey(owner, repo)
+	s.publicKeys.deleteFunc(func(key string) bool { return key == rk })
 }
 
-// ListMaintainableRepositories lists all repositories in the given organization
+// InvalidatePublicKeys drops all cached public keys.
+func (s *Service) InvalidatePublicKeys() {
+	s.publicKeys.deleteFunc(func(string) bool { return true })
+}
+
+// publicKey returns the secrets public key of a repository, from the cache if
+// possible.
+func (s *Service) publicKey(ctx context.Context, client *github.Client, owner, repo string) (*github.PublicKey, error) {
+	if key, ok := s.publicKeys.get(repoKey(owner, repo)); ok {
+		return key, nil
+	}
+
+	ctx, span := startSpan(ctx, "github.GetRepoPublicKey", owner, repo)
+	var key *github.PublicKey
+	err := withRetry(ctx, func() (err error) {
+		key, _, err = client.Actions.GetRepoPublicKey(ctx, owner, repo)
+		return err
+	})
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	s.publicKeys.set(repoKey(owner, repo), key)
+	return key, nil
+}
+
+// ListMaintainableRepositories lists all repositories in the given organizations
 // where the authenticated user has 'maintain' or 'admin' permissions.
-func (s *Service) ListMaintainableRepositories(ctx context.Context, client *github.Client, orgName string) ([]*github.Repository, error) {
+// The user's repositories are paged through only once, regardless of how many
+// organizations are requested.
+func (s *Service) ListMaintainableRepositories(ctx context.Context, client *github.Client, orgNames ...string) ([]*github.Repository, error) {
 	opts := &github.RepositoryListByAuthenticatedUserOptions{
 		Type:        "all",
 		ListOptions: github.ListOptions{PerPage: 100},
@@ -37,14 +116,21 @@ func (s *Service) ListMaintainableRepositories(ctx context.Context, client *gith
 
 	var allRepos []*github.Repository
 	for {
-		repos, resp, err := client.Repositories.ListByAuthenticatedUser(ctx, opts)
+		var (
+			repos []*github.Repository
+			resp  *github.Response
+		)
+		err := withRetry(ctx, func() (err error) {
+			repos, resp, err = client.Repositories.ListByAuthenticatedUser(ctx, opts)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
 
 		for _, repo := range repos {
 			// Filter by Organization
-			if repo.Owner != nil && repo.Owner.Login != nil && strings.EqualFold(*repo.Owner.Login, orgName) {
+			if repo.Owner != nil && repo.Owner.Login != nil && containsFold(orgNames, *repo.Owner.Login) {
 				// Filter by Permissions (Admin or Maintain)
 				// Note: Maintain permission is often implied by Admin, but explicit check is safer.
 				if s.hasMaintainerPermissions(repo) {
@@ -62,21 +148,73 @@ func (s *Service) ListMaintainableRepositories(ctx context.Context, client *gith
 	return allRepos, nil
 }
 
+// ListOrgRepositories lists all repositories of org that client can see,
+// regardless of its permissions on them.
+func (s *Service) ListOrgRepositories(ctx context.Context, client *github.Client, org string) (allRepos []*github.Repository, err error) {
+	ctx, span := startSpan(ctx, "repository.ListOrgRepositories", org, "")
+	defer func() { endSpan(span, err) }()
+
+	opts := &github.RepositoryListByOrgOptions{
+		Type:        "all",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		var (
+			repos []*github.Repository
+			resp  *github.Response
+		)
+		err = withRetry(ctx, func() (err error) {
+			repos, resp, err = client.Repositories.ListByOrg(ctx, org, opts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		allRepos = append(allRepos, repos...)
+
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return allRepos, nil
+}
+
 // ListSecrets lists the names of secrets for a repository.
 // Note: GitHub API does not return secret values, only names and metadata.
 func (s *Service) ListSecrets(ctx context.Context, client *github.Client, owner, repo string) ([]string, error) {
+	secrets, err := s.ListSecretMetadata(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(secrets))
+	for i, secret := range secrets {
+		names[i] = secret.Name
+	}
+	return names, nil
+}
+
+// ListSecretMetadata lists the secrets of a repository with their creation
+// and update times.
+func (s *Service) ListSecretMetadata(ctx context.Context, client *github.Client, owner, repo string) ([]*github.Secret, error) {
 	opts := &github.ListOptions{PerPage: 100}
-	allSecrets := []string{}
+	allSecrets := []*github.Secret{}
 
 	for {
-		secrets, resp, err := client.Actions.ListRepoSecrets(ctx, owner, repo, opts)
+		var (
+			secrets *github.Secrets
+			resp    *github.Response
+		)
+		err := withRetry(ctx, func() (err error) {
+			secrets, resp, err = client.Actions.ListRepoSecrets(ctx, owner, repo, opts)
+			return err
+		})
 		if err != nil {
 			return nil, err
 		}
-
-		for _, secret := range secrets.Secrets {
-			allSecrets = append(allSecrets, secret.Name)
-		}
+		allSecrets = append(allSecrets, secrets.Secrets...)
 
 		if resp.NextPage == 0 {
 			break
@@ -87,24 +225,78 @@ func (s *Service) ListSecrets(ctx context.Context, client *github.Client, owner,
 	return allSecrets, nil
 }
 
+// GetFileContent returns the content of the file at path on the default
+// branch of a repository. A missing file is an ErrNotFound error.
+func (s *Service) GetFileContent(ctx context.Context, client *github.Client, owner, repo, path string) (content []byte, err error) {
+	ctx, span := startSpan(ctx, "repository.GetFileContent", owner, repo)
+	span.SetAttributes(attribute.String("github.path", path))
+	defer func() { endSpan(span, err) }()
+
+	var file *github.RepositoryContent
+	err = withRetry(ctx, func() (err error) {
+		file, _, _, err = client.Repositories.GetContents(ctx, owner, repo, path, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// A directory comes back as a listing instead of a file
+	if file == nil {
+		return nil, &Error{Code: CodeNotFound, Message: fmt.Sprintf("%s is not a file", path)}
+	}
+
+	text, err := file.GetContent()
+	if err != nil {
+		return nil, &Error{Code: CodeUpstream, Message: "Failed to decode the file from GitHub", Err: err}
+	}
+	return []byte(text), nil
+}
+
 // DeleteSecret deletes a secret from a repository.
 func (s *Service) DeleteSecret(ctx context.Context, client *github.Client, owner, repo, name string) error {
-	_, err := client.Actions.DeleteRepoSecret(ctx, owner, repo, name)
-	return err
+	return withRetry(ctx, func() error {
+		_, err := client.Actions.DeleteRepoSecret(ctx, owner, repo, name)
+		return err
+	})
 }
 
 // CreateOrUpdateSecret encrypts and uploads a secret to a repository.
-func (s *Service) CreateOrUpdateSecret(ctx context.Context, client *github.Client, owner, repo, name, value string) error {
-	// 1. Get Public Key from GitHub
-	publicKey, _, err := client.Actions.GetRepoPublicKey(ctx, owner, repo)
+func (s *Service) CreateOrUpdateSecret(ctx context.Context, client *github.Client, owner, repo, name, value string) (err error) {
+	ctx, span := startSpan(ctx, "repository.CreateOrUpdateSecret", owner, repo)
+	span.SetAttributes(attribute.String("github.secret", name))
+	defer func() { endSpan(span, err) }()
+
+	if err := ValidateSecretName(name); err != nil {
+		return err
+	}
+
+	// 1. Get Public Key from GitHub (or the cache)
+	publicKey, err := s.publicKey(ctx, client, owner, repo)
 	if err != nil {
 		return err
 	}
 
+	err = s.uploadSecret(ctx, client, owner, repo, name, value, publicKey)
+	if !errors.Is(err, ErrValidation) {
+		return err
+	}
+
+	// GitHub rejects values encrypted for a key it rotated away. Retry once
+	// with the current key, unless the key didn't change.
+	s.InvalidatePublicKey(owner, repo)
+	current, keyErr := s.publicKey(ctx, client, owner, repo)
+	if keyErr != nil || current.GetKeyID() == publicKey.GetKeyID() {
+		return err
+	}
+	return s.uploadSecret(ctx, client, owner, repo, name, value, current)
+}
+
+// uploadSecret encrypts value with publicKey and uploads it.
+func (s *Service) uploadSecret(ctx context.Context, client *github.Client, owner, repo, name, value string, publicKey *github.PublicKey) error {
 	// 2. Encrypt the secret
 	encryptedValue, err := encryptSecretWithPublicKey(publicKey, name, value)
 	if err != nil {
-		return err
+		return &Error{Code: CodeUpstream, Message: "Failed to encrypt the secret with the repository's public key", Err: err}
 	}
 
 	// 3. Create or Update Secret
@@ -114,7 +306,12 @@ func (s *Service) CreateOrUpdateSecret(ctx context.Context, client *github.Clien
 		EncryptedValue: encryptedValue,
 	}
 
-	_, err = client.Actions.CreateOrUpdateRepoSecret(ctx, owner, repo, secret)
+	ctx, span := startSpan(ctx, "github.CreateOrUpdateRepoSecret", owner, repo)
+	err = withRetry(ctx, func() error {
+		_, err := client.Actions.CreateOrUpdateRepoSecret(ctx, owner, repo, secret)
+		return err
+	})
+	endSpan(span, err)
 	return err
 }
 
@@ -148,8 +345,18 @@ func encryptSecretWithPublicKey(publicKey *github.PublicKey, secretName, secretV
 }
 
 // HasMaintainerAccess checks if the user has 'admin' or 'maintain' permissions on the repository.
-func (s *Service) HasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo string) (bool, error) {
-	repository, _, err := client.Repositories.Get(ctx, owner, repo)
+func (s *Service) HasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo string) (hasAccess bool, err error) {
+	ctx, span := startSpan(ctx, "repository.HasMaintainerAccess", owner, repo)
+	defer func() {
+		span.SetAttributes(attribute.Bool("access.granted", hasAccess))
+		endSpan(span, err)
+	}()
+
+	var repository *github.Repository
+	err = withRetry(ctx, func() (err error) {
+		repository, _, err = client.Repositories.Get(ctx, owner, repo)
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
@@ -161,7 +368,102 @@ func (s *Service) HasMaintainerAccess(ctx context.Context, client *github.Client
 	return s.hasMaintainerPermissions(repository), nil
 }
 
+// UserHasMaintainerAccess checks whether the user login can maintain or
+// administer the repository. Unlike HasMaintainerAccess, client does not need
+// to belong to that user; it is used for requests made with an API token,
+// where only the PAT is available.
+func (s *Service) UserHasMaintainerAccess(ctx context.Context, client *github.Client, owner, repo, login string) (hasAccess bool, err error) {
+	ctx, span := startSpan(ctx, "repository.UserHasMaintainerAccess", owner, repo)
+	defer func() {
+		span.SetAttributes(attribute.Bool("access.granted", hasAccess))
+		endSpan(span, err)
+	}()
+
+	var level *github.RepositoryPermissionLevel
+	err = withRetry(ctx, func() (err error) {
+		level, _, err = client.Repositories.GetPermissionLevel(ctx, owner, repo, login)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// role_name distinguishes maintain from write, permission does not
+	return level.GetPermission() == "admin" || level.GetRoleName() == "admin" || level.GetRoleName() == "maintain", nil
+}
+
+// IsOrgMember checks whether the user login is a member of org. client must
+// be able to see private memberships, e.g. a PAT of an org member.
+func (s *Service) IsOrgMember(ctx context.Context, client *github.Client, org, login string) (member bool, err error) {
+	ctx, span := startSpan(ctx, "repository.IsOrgMember", org, "")
+	defer func() {
+		span.SetAttributes(attribute.Bool("org.member", member))
+		endSpan(span, err)
+	}()
+
+	err = withRetry(ctx, func() (err error) {
+		member, _, err = client.Organizations.IsMember(ctx, org, login)
+		return err
+	})
+	return member, err
+}
+
+// IsOrgOwner checks whether the user login is an owner of org. client must be
+// able to see the org's memberships.
+func (s *Service) IsOrgOwner(ctx context.Context, client *github.Client, org, login string) (owner bool, err error) {
+	ctx, span := startSpan(ctx, "repository.IsOrgOwner", org, "")
+	defer func() {
+		span.SetAttributes(attribute.Bool("org.owner", owner))
+		endSpan(span, err)
+	}()
+
+	var membership *github.Membership
+	err = withRetry(ctx, func() (err error) {
+		membership, _, err = client.Organizations.GetOrgMembership(ctx, login, org)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return membership.GetState() == "active" && membership.GetRole() == "admin", nil
+}
+
+// IsTeamMember checks whether the user login is a member of the team with the
+// slug team in org.
+func (s *Service) IsTeamMember(ctx context.Context, client *github.Client, org, team, login string) (member bool, err error) {
+	ctx, span := startSpan(ctx, "repository.IsTeamMember", org, "")
+	defer func() {
+		span.SetAttributes(attribute.String("team", team), attribute.Bool("team.member", member))
+		endSpan(span, err)
+	}()
+
+	var membership *github.Membership
+	err = withRetry(ctx, func() (err error) {
+		membership, _, err = client.Teams.GetTeamMembershipBySlug(ctx, org, team, login)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return membership.GetState() == "active", nil
+}
+
 func (s *Service) hasMaintainerPermissions(repo *github.Repository) bool {
 	permissions := repo.GetPermissions()
 	return permissions["admin"] || permissions["maintain"]
 }
+
+func containsFold(list []string, val string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, val) {
+			return true
+		}
+	}
+	return false
+}
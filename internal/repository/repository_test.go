@@ -2,10 +2,13 @@ package repository_test
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
 	"github.com/google/go-github/v80/github"
@@ -89,6 +92,137 @@ func TestListUserRepositories(t *testing.T) {
 	}
 }
 
+func TestListUserRepositoriesMultipleOrgs(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	calls := 0
+	mux.HandleFunc("/user/repos", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		repos := []*github.Repository{
+			{
+				Name:        github.Ptr("repo-a"),
+				Owner:       &github.User{Login: github.Ptr("OrgA")},
+				Permissions: map[string]bool{"admin": true},
+			},
+			{
+				Name:        github.Ptr("repo-b"),
+				Owner:       &github.User{Login: github.Ptr("orgb")},
+				Permissions: map[string]bool{"maintain": true},
+			},
+			{
+				Name:        github.Ptr("repo-c"),
+				Owner:       &github.User{Login: github.Ptr("OrgC")},
+				Permissions: map[string]bool{"admin": true},
+			},
+		}
+		_ = json.NewEncoder(w).Encode(repos)
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	service := repository.NewService()
+	repos, err := service.ListMaintainableRepositories(context.Background(), client, "orga", "OrgB")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(repos) != 2 {
+		t.Fatalf("expected 2 repos, got %d", len(repos))
+	}
+	if repos[0].GetName() != "repo-a" || repos[1].GetName() != "repo-b" {
+		t.Errorf("unexpected repos: %s, %s", repos[0].GetName(), repos[1].GetName())
+	}
+	if calls != 1 {
+		t.Errorf("expected repositories to be listed once, got %d calls", calls)
+	}
+}
+
+func TestListOrgRepositories(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("GET /orgs/TargetOrg/repos", func(w http.ResponseWriter, r *http.Request) {
+		// No permissions: the client can see the repositories, nothing more
+		if r.URL.Query().Get("page") == "2" {
+			_ = json.NewEncoder(w).Encode([]*github.Repository{{Name: github.Ptr("repo-b")}})
+			return
+		}
+		w.Header().Set("Link", `<`+server.URL+`/orgs/TargetOrg/repos?page=2>; rel="next"`)
+		_ = json.NewEncoder(w).Encode([]*github.Repository{{Name: github.Ptr("repo-a")}})
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	repos, err := repository.NewService().ListOrgRepositories(context.Background(), client, "TargetOrg")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(repos) != 2 || repos[0].GetName() != "repo-a" || repos[1].GetName() != "repo-b" {
+		t.Errorf("expected repo-a and repo-b, got %v", repos)
+	}
+}
+
+func TestListSecretMetadata(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	updated := github.Timestamp{Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	mux.HandleFunc("/repos/TargetOrg/repo-1/actions/secrets", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&github.Secrets{
+			TotalCount: 1,
+			Secrets:    []*github.Secret{{Name: "SECRET_ONE", CreatedAt: updated, UpdatedAt: updated}},
+		})
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	secrets, err := repository.NewService().ListSecretMetadata(context.Background(), client, "TargetOrg", "repo-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(secrets) != 1 || secrets[0].Name != "SECRET_ONE" || !secrets[0].UpdatedAt.Equal(updated) {
+		t.Errorf("unexpected secrets: %+v", secrets)
+	}
+}
+
+func TestGetFileContent(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("/repos/TargetOrg/repo-1/contents/.github/secret-broker.yml", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&github.RepositoryContent{
+			Type:     github.Ptr("file"),
+			Encoding: github.Ptr("base64"),
+			Content:  github.Ptr(base64.StdEncoding.EncodeToString([]byte("template: deployable\n"))),
+		})
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+	service := repository.NewService()
+
+	content, err := service.GetFileContent(context.Background(), client, "TargetOrg", "repo-1", ".github/secret-broker.yml")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != "template: deployable\n" {
+		t.Errorf("content = %q", content)
+	}
+
+	_, err = service.GetFileContent(context.Background(), client, "TargetOrg", "repo-1", "missing.yml")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("err = %v, want ErrNotFound", err)
+	}
+}
+
 func TestListSecrets(t *testing.T) {
 	// Setup mock server
 	mux := http.NewServeMux()
@@ -172,3 +306,196 @@ func TestHasMaintainerAccess(t *testing.T) {
 		t.Error("expected denied, got access")
 	}
 }
+
+func TestUserHasMaintainerAccess(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	levels := map[string]*github.RepositoryPermissionLevel{
+		"admin":      {Permission: github.Ptr("admin"), RoleName: github.Ptr("admin")},
+		"maintainer": {Permission: github.Ptr("write"), RoleName: github.Ptr("maintain")},
+		"writer":     {Permission: github.Ptr("write"), RoleName: github.Ptr("write")},
+	}
+	mux.HandleFunc("GET /repos/TargetOrg/repo-1/collaborators/{login}/permission", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(levels[r.PathValue("login")])
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+	service := repository.NewService()
+
+	for login, want := range map[string]bool{"admin": true, "maintainer": true, "writer": false} {
+		hasAccess, err := service.UserHasMaintainerAccess(context.Background(), client, "TargetOrg", "repo-1", login)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if hasAccess != want {
+			t.Errorf("%s: expected access %v, got %v", login, want, hasAccess)
+		}
+	}
+}
+
+func TestIsOrgMember(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("GET /orgs/TargetOrg/members/{login}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("login") == "member" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+	service := repository.NewService()
+
+	for login, want := range map[string]bool{"member": true, "outsider": false} {
+		member, err := service.IsOrgMember(context.Background(), client, "TargetOrg", login)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if member != want {
+			t.Errorf("%s: expected member %v, got %v", login, want, member)
+		}
+	}
+}
+
+func TestIsOrgOwnerAndTeamMember(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	memberships := map[string]*github.Membership{
+		"owner":   {State: github.Ptr("active"), Role: github.Ptr("admin")},
+		"member":  {State: github.Ptr("active"), Role: github.Ptr("member")},
+		"invited": {State: github.Ptr("pending"), Role: github.Ptr("admin")},
+	}
+	serve := func(w http.ResponseWriter, r *http.Request) {
+		m, ok := memberships[r.PathValue("login")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(m)
+	}
+	mux.HandleFunc("GET /orgs/TargetOrg/memberships/{login}", serve)
+	mux.HandleFunc("GET /orgs/TargetOrg/teams/admins/memberships/{login}", serve)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+	service := repository.NewService()
+
+	for login, want := range map[string]bool{"owner": true, "member": false, "invited": false, "outsider": false} {
+		owner, err := service.IsOrgOwner(context.Background(), client, "TargetOrg", login)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if owner != want {
+			t.Errorf("%s: expected owner %v, got %v", login, want, owner)
+		}
+	}
+	for login, want := range map[string]bool{"owner": true, "member": true, "invited": false, "outsider": false} {
+		member, err := service.IsTeamMember(context.Background(), client, "TargetOrg", "admins", login)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if member != want {
+			t.Errorf("%s: expected team member %v, got %v", login, want, member)
+		}
+	}
+}
+
+func TestCreateOrUpdateSecret_CachesPublicKey(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	keyCalls := 0
+	failUpload := false
+	mux.HandleFunc("GET /repos/TargetOrg/repo-1/actions/secrets/public-key", func(w http.ResponseWriter, r *http.Request) {
+		keyCalls++
+		_ = json.NewEncoder(w).Encode(&github.PublicKey{
+			KeyID: github.Ptr("key-1"),
+			Key:   github.Ptr("MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="),
+		})
+	})
+	mux.HandleFunc("PUT /repos/TargetOrg/repo-1/actions/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if failUpload {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+	service := repository.NewService()
+
+	for range 2 {
+		if err := service.CreateOrUpdateSecret(context.Background(), client, "TargetOrg", "repo-1", "S", "v"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if keyCalls != 1 {
+		t.Errorf("expected the public key to be fetched once, got %d", keyCalls)
+	}
+
+	// A rejected write refetches the key, it may have been rotated
+	failUpload = true
+	if err := service.CreateOrUpdateSecret(context.Background(), client, "TargetOrg", "repo-1", "S", "v"); err == nil {
+		t.Fatal("expected error")
+	}
+	failUpload = false
+	if err := service.CreateOrUpdateSecret(context.Background(), client, "TargetOrg", "repo-1", "S", "v"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyCalls != 2 {
+		t.Errorf("expected the public key to be refetched after a failed write, got %d", keyCalls)
+	}
+}
+
+func TestCreateOrUpdateSecret_RotatedPublicKey(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	keyID, keyCalls, uploads := "key-1", 0, 0
+	mux.HandleFunc("GET /repos/TargetOrg/repo-1/actions/secrets/public-key", func(w http.ResponseWriter, r *http.Request) {
+		keyCalls++
+		_ = json.NewEncoder(w).Encode(&github.PublicKey{
+			KeyID: github.Ptr(keyID),
+			Key:   github.Ptr("MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI="),
+		})
+	})
+	mux.HandleFunc("PUT /repos/TargetOrg/repo-1/actions/secrets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		uploads++
+		var secret github.EncryptedSecret
+		_ = json.NewDecoder(r.Body).Decode(&secret)
+		if secret.KeyID != keyID {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+	service := repository.NewService()
+
+	if err := service.CreateOrUpdateSecret(context.Background(), client, "TargetOrg", "repo-1", "S", "v"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The cached key is stale now; the write is retried with the new one
+	keyID = "key-2"
+	if err := service.CreateOrUpdateSecret(context.Background(), client, "TargetOrg", "repo-1", "S", "v"); err != nil {
+		t.Fatalf("expected the write to succeed with the rotated key: %v", err)
+	}
+	if keyCalls != 2 || uploads != 3 {
+		t.Errorf("expected 2 key fetches and 3 uploads, got %d and %d", keyCalls, uploads)
+	}
+}
@@ -0,0 +1,102 @@
+package repository_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/repository"
+	"github.com/google/go-github/v80/github"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupTracing installs an in-memory exporter as the global tracer provider
+// for the duration of the test.
+func setupTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previous)
+	})
+	return exporter
+}
+
+func TestCreateOrUpdateSecretSpans(t *testing.T) {
+	exporter := setupTracing(t)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	mux.HandleFunc("GET /repos/TargetOrg/repo-1/actions/secrets/public-key", func(w http.ResponseWriter, r *http.Request) {
+		key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+		_ = json.NewEncoder(w).Encode(&github.PublicKey{KeyID: github.Ptr("key-1"), Key: github.Ptr(key)})
+	})
+	mux.HandleFunc("PUT /repos/TargetOrg/repo-1/actions/secrets/NEW_SECRET", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	service := repository.NewService()
+	if err := service.CreateOrUpdateSecret(context.Background(), client, "TargetOrg", "repo-1", "NEW_SECRET", "value"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+
+	parent, ok := byName["repository.CreateOrUpdateSecret"]
+	if !ok {
+		t.Fatal("expected repository.CreateOrUpdateSecret span")
+	}
+	for _, name := range []string{"github.GetRepoPublicKey", "github.CreateOrUpdateRepoSecret"} {
+		child, ok := byName[name]
+		if !ok {
+			t.Fatalf("expected %s span", name)
+		}
+		if child.Parent.SpanID() != parent.SpanContext.SpanID() {
+			t.Errorf("expected %s to be a child of repository.CreateOrUpdateSecret", name)
+		}
+	}
+}
+
+func TestHasMaintainerAccessSpanRecordsError(t *testing.T) {
+	exporter := setupTracing(t)
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+	service := repository.NewService()
+	if _, err := service.HasMaintainerAccess(context.Background(), client, "TargetOrg", "missing"); err == nil {
+		t.Fatal("expected error")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "repository.HasMaintainerAccess" {
+		t.Fatalf("expected a repository.HasMaintainerAccess span, got %v", spans)
+	}
+	if len(spans[0].Events) == 0 {
+		t.Error("expected the error to be recorded on the span")
+	}
+}
@@ -0,0 +1,121 @@
+// Package roles assigns broker roles to GitHub users.
+//
+// Every user has the user role, which grants access to the repositories they
+// maintain. Auditors can additionally see org-wide information, admins can
+// change it. Roles are assigned by GitHub login, by membership in a GitHub
+// team or, for admins, optionally by being an owner of one of the broker's
+// organizations.
+package roles
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/config"
+)
+
+// Role is a broker role. Higher roles include the lower ones.
+type Role int
+
+const (
+	User Role = iota
+	Auditor
+	Admin
+)
+
+func (r Role) String() string {
+	switch r {
+	case User:
+		return "user"
+	case Auditor:
+		return "auditor"
+	case Admin:
+		return "admin"
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// Lookup answers the questions about GitHub memberships that role
+// assignment needs.
+type Lookup interface {
+	IsOrgOwner(ctx context.Context, org, login string) (bool, error)
+	IsTeamMember(ctx context.Context, org, team, login string) (bool, error)
+}
+
+// Assignments are the configured role assignments.
+type Assignments struct {
+	AdminUsers   []string
+	AdminTeams   []string
+	AuditorUsers []string
+	AuditorTeams []string
+	// OrgOwnersAreAdmins makes the owners of Orgs admins.
+	OrgOwnersAreAdmins bool
+	Orgs               []string
+}
+
+// FromConfig returns the role assignments of cfg.
+func FromConfig(cfg *config.Config) Assignments {
+	return Assignments{
+		AdminUsers:         cfg.AdminUsers,
+		AdminTeams:         cfg.AdminTeams,
+		AuditorUsers:       cfg.AuditorUsers,
+		AuditorTeams:       cfg.AuditorTeams,
+		OrgOwnersAreAdmins: cfg.OrgOwnersAreAdmins,
+		Orgs:               cfg.GithubOrgs,
+	}
+}
+
+// Resolve returns the highest role of the GitHub user login. Logins are
+// checked before teams and org ownership, so configured users don't cost a
+// GitHub request.
+func (a Assignments) Resolve(ctx context.Context, lookup Lookup, login string) (Role, error) {
+	var owners []string
+	if a.OrgOwnersAreAdmins {
+		owners = a.Orgs
+	}
+	admin, err := a.has(ctx, lookup, login, a.AdminUsers, a.AdminTeams, owners)
+	if err != nil {
+		return User, err
+	}
+	if admin {
+		return Admin, nil
+	}
+	auditor, err := a.has(ctx, lookup, login, a.AuditorUsers, a.AuditorTeams, nil)
+	if err != nil {
+		return User, err
+	}
+	if auditor {
+		return Auditor, nil
+	}
+	return User, nil
+}
+
+func (a Assignments) has(ctx context.Context, lookup Lookup, login string, users, teams, ownedOrgs []string) (bool, error) {
+	for _, user := range users {
+		// GitHub logins are case-insensitive
+		if strings.EqualFold(user, login) {
+			return true, nil
+		}
+	}
+	for _, team := range teams {
+		org, slug, _ := strings.Cut(team, "/")
+		member, err := lookup.IsTeamMember(ctx, org, slug, login)
+		if err != nil {
+			return false, err
+		}
+		if member {
+			return true, nil
+		}
+	}
+	for _, org := range ownedOrgs {
+		owner, err := lookup.IsOrgOwner(ctx, org, login)
+		if err != nil {
+			return false, err
+		}
+		if owner {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,94 @@
+package roles
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeLookup knows the org owners and team members, "org/team" -> logins.
+type fakeLookup struct {
+	owners map[string][]string
+	teams  map[string][]string
+	err    error
+	calls  int
+}
+
+func (f *fakeLookup) IsOrgOwner(ctx context.Context, org, login string) (bool, error) {
+	f.calls++
+	return contains(f.owners[org], login), f.err
+}
+
+func (f *fakeLookup) IsTeamMember(ctx context.Context, org, team, login string) (bool, error) {
+	f.calls++
+	return contains(f.teams[org+"/"+team], login), f.err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestResolve(t *testing.T) {
+	lookup := &fakeLookup{
+		owners: map[string][]string{"org": {"owner"}},
+		teams: map[string][]string{
+			"org/security": {"sec"},
+			"org/platform": {"platform"},
+		},
+	}
+	a := Assignments{
+		AdminUsers:         []string{"Root"},
+		AdminTeams:         []string{"org/platform"},
+		AuditorTeams:       []string{"org/security"},
+		AuditorUsers:       []string{"reviewer"},
+		OrgOwnersAreAdmins: true,
+		Orgs:               []string{"org"},
+	}
+
+	tests := map[string]Role{
+		"root":     Admin,
+		"platform": Admin,
+		"owner":    Admin,
+		"sec":      Auditor,
+		"reviewer": Auditor,
+		"someone":  User,
+	}
+	for login, want := range tests {
+		got, err := a.Resolve(context.Background(), lookup, login)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", login, err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %s, got %s", login, want, got)
+		}
+	}
+
+	t.Run("Owners only with the option", func(t *testing.T) {
+		a := a
+		a.OrgOwnersAreAdmins = false
+		if got, _ := a.Resolve(context.Background(), lookup, "owner"); got != User {
+			t.Errorf("expected user, got %s", got)
+		}
+	})
+
+	t.Run("Configured logins need no lookup", func(t *testing.T) {
+		lookup.calls = 0
+		_, _ = a.Resolve(context.Background(), lookup, "root")
+		if lookup.calls != 0 {
+			t.Errorf("expected no lookups, got %d", lookup.calls)
+		}
+	})
+
+	t.Run("Lookup errors", func(t *testing.T) {
+		failing := &fakeLookup{err: errors.New("boom")}
+		got, err := a.Resolve(context.Background(), failing, "someone")
+		if err == nil || got != User {
+			t.Errorf("expected an error and the user role, got %s, %v", got, err)
+		}
+	})
+}
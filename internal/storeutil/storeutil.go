@@ -0,0 +1,36 @@
+// Package storeutil holds what the file-backed stores of API tokens and
+// break-glass grants share: writing their file safely and generating IDs.
+package storeutil
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces the file at path with data. It writes a temporary file
+// in the same directory first and renames it, so a crash can't leave a
+// truncated file behind.
+func WriteFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// RandomString returns n random bytes, base64url-encoded without padding.
+func RandomString(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b) // never returns an error
+	return base64.RawURLEncoding.EncodeToString(b)
+}
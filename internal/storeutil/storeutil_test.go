@@ -0,0 +1,38 @@
+package storeutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RobinMaas95/gh-secret-broker/internal/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens.json")
+
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, string(got), data)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(dir)
+	assert.Equal(t, len(entries), 1)
+
+	err := WriteFile(filepath.Join(dir, "missing", "tokens.json"), []byte("data"))
+	assert.Equal(t, err != nil, true)
+}
+
+func TestRandomString(t *testing.T) {
+	a, b := RandomString(9), RandomString(9)
+	assert.Equal(t, len(a), 12)
+	assert.Equal(t, a != b, true)
+}
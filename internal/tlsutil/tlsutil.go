@@ -0,0 +1,140 @@
+// Package tlsutil builds the server's TLS configuration and keeps the
+// certificate up to date when the files on disk are replaced, e.g. by
+// cert-manager or certbot.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a certificate loaded from disk and reloads it when the
+// certificate or key file changes. If reloading fails, the previous
+// certificate keeps being served.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// NewCertReloader loads the initial certificate. It fails if the files
+// cannot be loaded, so that a broken setup is noticed at startup.
+func NewCertReloader(certFile, keyFile string, logger *slog.Logger) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if r.changed() {
+		if err := r.reload(); err != nil {
+			r.logger.Error("Failed to reload TLS certificate, keeping current one", slog.String("error", err.Error()))
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// changed reports whether the modification time of one of the files differs
+// from the one of the loaded certificate.
+func (r *CertReloader) changed() bool {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod)
+}
+
+func (r *CertReloader) reload() error {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil {
+		r.logger.Info("Reloaded TLS certificate", slog.String("cert_file", r.certFile))
+	}
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+	return nil
+}
+
+func (r *CertReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// ServerConfig returns the TLS configuration for the HTTP server.
+// clientAuth is one of "none", "optional" or "require". For "optional",
+// clients may present a certificate signed by the CA in clientCAFile, and
+// connections with any other certificate are refused; "require" refuses
+// connections without one.
+//
+// Client certificates only gate the transport. They don't identify a user:
+// requests still need a session or an API token.
+func ServerConfig(reloader *CertReloader, clientCAFile, clientAuth string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	switch clientAuth {
+	case "", "none":
+		return tlsConfig, nil
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unknown client auth mode %q", clientAuth)
+	}
+
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", clientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,155 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for commonName and its key to
+// dir and returns the file paths.
+func writeCert(t *testing.T, dir, commonName string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "first")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	reloader, err := NewCertReloader(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cert, _ := reloader.GetCertificate(nil)
+	if got := commonName(t, cert); got != "first" {
+		t.Errorf("expected first certificate, got %q", got)
+	}
+
+	// Replace the files and make sure the modification time changes even on
+	// file systems with a coarse timestamp resolution.
+	writeCert(t, dir, "second")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	_ = os.Chtimes(keyFile, future, future)
+
+	cert, _ = reloader.GetCertificate(nil)
+	if got := commonName(t, cert); got != "second" {
+		t.Errorf("expected reloaded certificate, got %q", got)
+	}
+
+	// A broken file keeps the current certificate
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := future.Add(time.Minute)
+	_ = os.Chtimes(certFile, later, later)
+
+	cert, _ = reloader.GetCertificate(nil)
+	if got := commonName(t, cert); got != "second" {
+		t.Errorf("expected previous certificate to be kept, got %q", got)
+	}
+}
+
+func TestNewCertReloader_MissingFiles(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := NewCertReloader("missing.crt", "missing.key", logger); err == nil {
+		t.Error("expected error for missing files")
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "server")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	reloader, err := NewCertReloader(certFile, keyFile, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clientAuth string
+		want       tls.ClientAuthType
+	}{
+		{"none", tls.NoClientCert},
+		{"optional", tls.VerifyClientCertIfGiven},
+		{"require", tls.RequireAndVerifyClientCert},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.clientAuth, func(t *testing.T) {
+			cfg, err := ServerConfig(reloader, certFile, tt.clientAuth)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.ClientAuth != tt.want {
+				t.Errorf("ClientAuth = %v, want %v", cfg.ClientAuth, tt.want)
+			}
+			if cfg.MinVersion != tls.VersionTLS12 {
+				t.Errorf("MinVersion = %v, want TLS 1.2", cfg.MinVersion)
+			}
+			if tt.clientAuth != "none" && cfg.ClientCAs == nil {
+				t.Error("expected client CA pool")
+			}
+		})
+	}
+
+	t.Run("Invalid CA file", func(t *testing.T) {
+		if _, err := ServerConfig(reloader, keyFile, "require"); err == nil {
+			t.Error("expected error for CA file without certificates")
+		}
+	})
+}
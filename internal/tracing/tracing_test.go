@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup_WithoutEndpoint(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() { _ = shutdown(context.Background()) }()
+
+	// Trace context is still propagated
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	sc := trace.SpanContextFromContext(ctx)
+	if sc.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected trace ID to be extracted, got %s", sc.TraceID())
+	}
+}
+
+func TestSetup_WithEndpoint(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	shutdown, err := Setup(context.Background(), "http://localhost:4318")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "span")
+	if !span.IsRecording() {
+		t.Error("expected spans to be recorded once an endpoint is configured")
+	}
+	span.End()
+
+	// Nothing is listening, so flushing may fail; it must not hang though.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = shutdown(ctx)
+}
@@ -0,0 +1,504 @@
+// Package client is a Go client for the broker's REST API. The request and
+// response types are shared with the server, so both sides agree on the
+// wire format.
+//
+// Clients authenticate with an API token, sent as a bearer token. A token can
+// be created in the UI or obtained with the device login flow, see
+// StartDeviceLogin. Clients acting for a logged-in browser user can read with
+// the session cookie instead, see NewSession.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes of the problem details the broker answers errors with.
+const (
+	CodeBadRequest   = "bad_request"
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeNotFound     = "not_found"
+	CodeValidation   = "validation_failed"
+	CodeRateLimited  = "rate_limited"
+	CodeUpstream     = "upstream_error"
+	CodeInternal     = "internal_error"
+	// CodeCSRFFailed is returned when a session request changes state
+	// without the CSRF protection only the broker's own pages pass.
+	CodeCSRFFailed = "csrf_failed"
+)
+
+// Error codes of POST /api/device/token while the login isn't complete. They
+// follow RFC 8628.
+const (
+	CodeAuthorizationPending = "authorization_pending"
+	CodeSlowDown             = "slow_down"
+	CodeExpiredToken         = "expired_token"
+	CodeAccessDenied         = "access_denied"
+)
+
+// Error is a failed request, decoded from the problem details (RFC 9457) the
+// broker answers errors with.
+type Error struct {
+	Status    int        `json:"status"`
+	Code      string     `json:"code"`
+	Title     string     `json:"title"`
+	Detail    string     `json:"detail,omitempty"`
+	RequestID string     `json:"request_id,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Detail
+	if msg == "" {
+		msg = e.Title
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s (%d %s, request %s)", msg, e.Status, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("%s (%d %s)", msg, e.Status, e.Code)
+}
+
+// IsCode reports whether err is an *Error with the given code.
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// DefaultMaxRetries is the MaxRetries of clients returned by New and
+// NewSession.
+const DefaultMaxRetries = 2
+
+// maxRetryWait is the longest a request waits before it is retried. Rate
+// limits that reset later are returned to the caller.
+const maxRetryWait = 30 * time.Second
+
+// Client calls the broker API. It is safe for concurrent use.
+type Client struct {
+	// BaseURL is the broker's address, e.g. "https://secrets.example.com".
+	BaseURL string
+	// Token is the API token. Requests are sent without one if it is empty.
+	Token string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// MaxRetries is how often a request is retried after a rate limit, and,
+	// unless it is a POST, after a network error or an unavailable server.
+	MaxRetries int
+}
+
+// New returns a client for the broker at baseURL using the API token.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token, MaxRetries: DefaultMaxRetries}
+}
+
+// NewSession returns a client for the broker at baseURL that authenticates
+// with the session cookie of a logged-in user. Requests that change state
+// fail with CodeCSRFFailed: the broker only accepts them from its own pages
+// in a browser. Use an API token for those, which is exempt from the check.
+func NewSession(baseURL string, session *http.Cookie) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	cookie := *session
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	jar.SetCookies(u, []*http.Cookie{&cookie})
+
+	c := New(baseURL, "")
+	c.HTTPClient = &http.Client{Jar: jar}
+	return c, nil
+}
+
+// Providers returns the login providers.
+func (c *Client) Providers(ctx context.Context) (*Providers, error) {
+	var p Providers
+	if err := c.do(ctx, http.MethodGet, "/api/providers", nil, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// User returns the logged-in user. It only works with a session.
+func (c *Client) User(ctx context.Context) (*User, error) {
+	var u User
+	if err := c.do(ctx, http.MethodGet, "/api/user", nil, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Role returns the broker role of the logged-in user. It only works with a
+// session.
+func (c *Client) Role(ctx context.Context) (*UserRole, error) {
+	var role UserRole
+	if err := c.do(ctx, http.MethodGet, "/api/user/role", nil, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// RoleAssignments returns the configured role assignments. It requires the
+// auditor role and only works with a session.
+func (c *Client) RoleAssignments(ctx context.Context) (*RoleAssignments, error) {
+	var a RoleAssignments
+	if err := c.do(ctx, http.MethodGet, "/api/admin/roles", nil, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// AuditRepositories returns all repositories of the broker's organizations,
+// grouped per organization. It requires the auditor role and only works with
+// a session.
+func (c *Client) AuditRepositories(ctx context.Context) ([]OrgRepositories, error) {
+	var groups []OrgRepositories
+	err := c.do(ctx, http.MethodGet, "/api/admin/repos", nil, &groups)
+	return groups, err
+}
+
+// AuditSecrets returns the secrets of any repository of the broker's
+// organizations. It requires the auditor role and only works with a session.
+func (c *Client) AuditSecrets(ctx context.Context, owner, repo string) ([]SecretMetadata, error) {
+	var secrets []SecretMetadata
+	err := c.do(ctx, http.MethodGet, "/api/admin"+secretsPath(owner, repo), nil, &secrets)
+	return secrets, err
+}
+
+// BreakGlass grants the user time-boxed write access to a repository they
+// don't maintain, for emergencies. It only works with a session.
+func (c *Client) BreakGlass(ctx context.Context, req BreakGlassRequest) (*BreakGlassGrant, error) {
+	var g BreakGlassGrant
+	if err := c.do(ctx, http.MethodPost, "/api/break-glass", req, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// ListBreakGlass returns the break-glass grants, newest first, or only those
+// that still need a review. It requires the auditor role and only works with
+// a session.
+func (c *Client) ListBreakGlass(ctx context.Context, pending bool) ([]BreakGlassGrant, error) {
+	path := "/api/admin/break-glass"
+	if pending {
+		path += "?pending=true"
+	}
+	var grants []BreakGlassGrant
+	err := c.do(ctx, http.MethodGet, path, nil, &grants)
+	return grants, err
+}
+
+// ReviewBreakGlass signs off a break-glass grant. It requires the admin role
+// and only works with a session.
+func (c *Client) ReviewBreakGlass(ctx context.Context, id, note string) (*BreakGlassGrant, error) {
+	var g BreakGlassGrant
+	if err := c.do(ctx, http.MethodPost, "/api/admin/break-glass/"+url.PathEscape(id)+"/review", ReviewBreakGlassRequest{Note: note}, &g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// PreviewIncident starts looking up the repositories that hold the secret of
+// req, without changing anything. Poll the returned incident with
+// GetIncident until it is done. It requires the admin role and only works
+// with a session.
+func (c *Client) PreviewIncident(ctx context.Context, req IncidentRequest) (*Incident, error) {
+	var i Incident
+	if err := c.do(ctx, http.MethodPost, "/api/admin/incidents/preview", req, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// RunIncident starts deleting or replacing the secret of req in the
+// repositories confirmed from the preview. Poll the returned incident with
+// GetIncident until it is done. It requires the admin role and only works
+// with a session.
+func (c *Client) RunIncident(ctx context.Context, req IncidentRequest) (*Incident, error) {
+	var i Incident
+	if err := c.do(ctx, http.MethodPost, "/api/admin/incidents", req, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// GetIncident returns the incident with the given ID. It requires the admin
+// role and only works with a session.
+func (c *Client) GetIncident(ctx context.Context, id string) (*Incident, error) {
+	var i Incident
+	if err := c.do(ctx, http.MethodGet, "/api/admin/incidents/"+url.PathEscape(id), nil, &i); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// ListRepositories returns the repositories the user can manage secrets of,
+// grouped per organization.
+func (c *Client) ListRepositories(ctx context.Context) ([]OrgRepositories, error) {
+	var groups []OrgRepositories
+	err := c.do(ctx, http.MethodGet, "/api/user/repos", nil, &groups)
+	return groups, err
+}
+
+// ListOrgs returns the configured organizations and the active one. It only
+// works with a session.
+func (c *Client) ListOrgs(ctx context.Context) (*Orgs, error) {
+	var orgs Orgs
+	if err := c.do(ctx, http.MethodGet, "/api/orgs", nil, &orgs); err != nil {
+		return nil, err
+	}
+	return &orgs, nil
+}
+
+// SetActiveOrg selects the organization whose repositories are listed, or
+// all of them if org is empty. It only works with a session.
+func (c *Client) SetActiveOrg(ctx context.Context, org string) error {
+	return c.do(ctx, http.MethodPut, "/api/orgs/active", SetActiveOrgRequest{Org: org}, nil)
+}
+
+// ListSecrets returns the names of a repository's secrets.
+func (c *Client) ListSecrets(ctx context.Context, owner, repo string) ([]string, error) {
+	var names []string
+	err := c.do(ctx, http.MethodGet, secretsPath(owner, repo), nil, &names)
+	return names, err
+}
+
+// RequiredSecrets returns the secrets the repository is required to have and
+// which of them are missing.
+func (c *Client) RequiredSecrets(ctx context.Context, owner, repo string) (*RequiredSecrets, error) {
+	var res RequiredSecrets
+	if err := c.do(ctx, http.MethodGet, "/api/repo/"+url.PathEscape(owner)+"/"+url.PathEscape(repo)+"/required-secrets", nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetSecret creates or updates a secret.
+func (c *Client) SetSecret(ctx context.Context, owner, repo, name, value string) error {
+	return c.do(ctx, http.MethodPut, secretsPath(owner, repo)+"/"+url.PathEscape(name), SetSecretRequest{Value: value}, nil)
+}
+
+// DeleteSecret deletes a secret.
+func (c *Client) DeleteSecret(ctx context.Context, owner, repo, name string) error {
+	return c.do(ctx, http.MethodDelete, secretsPath(owner, repo)+"/"+url.PathEscape(name), nil, nil)
+}
+
+// ListTokens returns the user's API tokens. It only works with a session.
+func (c *Client) ListTokens(ctx context.Context) ([]APIToken, error) {
+	var tokens []APIToken
+	err := c.do(ctx, http.MethodGet, "/api/tokens", nil, &tokens)
+	return tokens, err
+}
+
+// CreateToken creates an API token. It only works with a session.
+func (c *Client) CreateToken(ctx context.Context, req CreateTokenRequest) (*CreatedToken, error) {
+	var created CreatedToken
+	if err := c.do(ctx, http.MethodPost, "/api/tokens", req, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// RevokeToken revokes one of the user's API tokens. It only works with a
+// session.
+func (c *Client) RevokeToken(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/tokens/"+url.PathEscape(id), nil, nil)
+}
+
+// StartDeviceLogin starts a device login whose API token has the scope of
+// req; the zero value asks for the default scope. Show the user code and
+// verification URI to the user, then call PollDeviceLogin.
+func (c *Client) StartDeviceLogin(ctx context.Context, req DeviceCodeRequest) (*DeviceCode, error) {
+	var code DeviceCode
+	if err := c.do(ctx, http.MethodPost, "/api/device/code", req, &code); err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// PollDeviceLogin waits until the user completed the device login and returns
+// the API token it yields. It fails when the login is denied, expires or ctx
+// is done.
+func (c *Client) PollDeviceLogin(ctx context.Context, code *DeviceCode) (*DeviceToken, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if code.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-after(interval):
+		}
+
+		var tok DeviceToken
+		err := c.do(ctx, http.MethodPost, "/api/device/token", DeviceTokenRequest{DeviceCode: code.DeviceCode}, &tok)
+		switch {
+		case err == nil:
+			return &tok, nil
+		case IsCode(err, CodeAuthorizationPending):
+		case IsCode(err, CodeSlowDown):
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+}
+
+// after is replaced in tests to poll and retry without waiting.
+var after = time.After
+
+func secretsPath(owner, repo string) string {
+	return "/api/repo/" + url.PathEscape(owner) + "/" + url.PathEscape(repo) + "/secrets"
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// do sends a request with body encoded as JSON and decodes the response into
+// out. Error responses are returned as *Error. Failed requests are retried as
+// described at MaxRetries.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(ctx, method, path, data)
+		if err != nil {
+			if ctx.Err() != nil || method == http.MethodPost || attempt >= c.MaxRetries {
+				return err
+			}
+			if err := wait(ctx, backoff(attempt)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if res.StatusCode < 400 {
+			err := decodeResponse(res, out)
+			if err != nil {
+				err = fmt.Errorf("decoding response of %s %s: %w", method, path, err)
+			}
+			return err
+		}
+
+		apiErr := decodeError(res)
+		delay, ok := retryDelay(method, apiErr, res.Header, attempt)
+		if !ok || attempt >= c.MaxRetries {
+			return apiErr
+		}
+		if err := wait(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// send sends a single request.
+func (c *Client) send(ctx context.Context, method, path string, data []byte) (*http.Response, error) {
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.httpClient().Do(req)
+}
+
+// retryDelay reports whether a failed request is retried and how long to wait
+// before. Rate-limited requests weren't carried out, so they are retried
+// whatever the method; other server errors only if repeating the request is
+// harmless.
+func retryDelay(method string, e *Error, header http.Header, attempt int) (time.Duration, bool) {
+	switch e.Status {
+	case http.StatusTooManyRequests:
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if method == http.MethodPost {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	delay := backoff(attempt)
+	if e.RetryAt != nil {
+		delay = time.Until(*e.RetryAt)
+	} else if secs, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		delay = time.Duration(secs) * time.Second
+	}
+	if delay > maxRetryWait {
+		return 0, false
+	}
+	return max(delay, 0), true
+}
+
+// backoff is the delay before retry attempt+1 when the server gave none.
+func backoff(attempt int) time.Duration {
+	return 500 * time.Millisecond << attempt
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-after(d):
+		return nil
+	}
+}
+
+func decodeResponse(res *http.Response, out any) error {
+	defer func() { _ = res.Body.Close() }()
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// decodeError reads the problem details of a failed response. Responses that
+// don't carry any, e.g. from a proxy, become an Error with just the status.
+func decodeError(res *http.Response) *Error {
+	defer func() { _ = res.Body.Close() }()
+	e := &Error{Status: res.StatusCode, Title: http.StatusText(res.StatusCode)}
+	if strings.Contains(res.Header.Get("Content-Type"), "json") {
+		_ = json.NewDecoder(res.Body).Decode(e)
+	}
+	e.Status = res.StatusCode
+	return e
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_SetSecret(t *testing.T) {
+	var got SetSecretRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.EscapedPath() != "/api/repo/org/my%20repo/secrets/API_KEY" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.EscapedPath())
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer gsb_token" {
+			t.Errorf("expected the token as bearer, got %q", auth)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL+"/", "gsb_token")
+	if err := c.SetSecret(context.Background(), "org", "my repo", "API_KEY", "value"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Value != "value" {
+		t.Errorf("expected the value in the body, got %q", got.Value)
+	}
+}
+
+func TestClient_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"title": "Forbidden", "status": 403, "code": "forbidden", "detail": "You need maintain or admin access to this repository", "request_id": "req-1"}`))
+	}))
+	defer srv.Close()
+
+	_, err := New(srv.URL, "gsb_token").ListSecrets(context.Background(), "org", "repo")
+
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if e.Status != http.StatusForbidden || e.Code != "forbidden" || e.RequestID != "req-1" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if want := "You need maintain or admin access to this repository (403 forbidden, request req-1)"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+}
+
+func TestClient_ErrorWithoutProblem(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "")
+	c.MaxRetries = 0
+	_, err := c.ListRepositories(context.Background())
+
+	var e *Error
+	if !errors.As(err, &e) || e.Status != http.StatusBadGateway || e.Title != "Bad Gateway" {
+		t.Errorf("expected a 502 error, got %v", err)
+	}
+}
+
+// noWait makes retries and polls happen immediately and records the delays.
+func noWait(t *testing.T) *[]time.Duration {
+	var waited []time.Duration
+	after = func(d time.Duration) <-chan time.Time {
+		waited = append(waited, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	t.Cleanup(func() { after = time.After })
+	return &waited
+}
+
+func TestClient_Retry(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		status   int
+		header   string
+		attempts int
+		waited   time.Duration
+	}{
+		{"Rate limited", http.MethodPost, http.StatusTooManyRequests, "3", 3, 3 * time.Second},
+		{"Rate limit resets too late", http.MethodGet, http.StatusTooManyRequests, "3600", 1, 0},
+		{"Unavailable", http.MethodGet, http.StatusServiceUnavailable, "", 3, 500 * time.Millisecond},
+		{"Unavailable POST", http.MethodPost, http.StatusServiceUnavailable, "", 1, 0},
+		{"Client error", http.MethodGet, http.StatusForbidden, "", 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waited := noWait(t)
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if tt.header != "" {
+					w.Header().Set("Retry-After", tt.header)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := New(srv.URL, "gsb_token").do(context.Background(), tt.method, "/api/tokens", nil, nil)
+
+			var e *Error
+			if !errors.As(err, &e) || e.Status != tt.status {
+				t.Fatalf("expected a %d error, got %v", tt.status, err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+			if len(*waited) > 0 && (*waited)[0] != tt.waited {
+				t.Errorf("expected to wait %v, got %v", tt.waited, (*waited)[0])
+			}
+		})
+	}
+}
+
+func TestClient_PollDeviceLogin(t *testing.T) {
+	noWait(t)
+
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req DeviceTokenRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.DeviceCode != "dev-1" {
+			t.Errorf("expected the device code, got %q", req.DeviceCode)
+		}
+
+		polls++
+		if polls < 3 {
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"status": 400, "code": "authorization_pending"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"token": "gsb_new"}`))
+	}))
+	defer srv.Close()
+
+	tok, err := New(srv.URL, "").PollDeviceLogin(context.Background(), &DeviceCode{DeviceCode: "dev-1", Interval: 1, ExpiresIn: 60})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tok.Token != "gsb_new" || polls != 3 {
+		t.Errorf("expected the token after 3 polls, got %q after %d", tok.Token, polls)
+	}
+}
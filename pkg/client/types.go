@@ -0,0 +1,257 @@
+package client
+
+import (
+	"time"
+
+	"github.com/google/go-github/v80/github"
+)
+
+// Providers lists the login providers, as returned by GET /api/providers.
+type Providers struct {
+	Providers []string `json:"Providers"`
+	// ProvidersMap maps a provider to its display name.
+	ProvidersMap map[string]string `json:"ProvidersMap"`
+}
+
+// User is the logged-in user, as returned by GET /api/user. Tokens of the
+// login provider are never part of it.
+type User struct {
+	UserID      string `json:"UserID"`
+	Provider    string `json:"Provider"`
+	NickName    string `json:"NickName"`
+	Name        string `json:"Name"`
+	Email       string `json:"Email"`
+	AvatarURL   string `json:"AvatarURL"`
+	Location    string `json:"Location"`
+	Description string `json:"Description"`
+}
+
+// OrgRepositories is a group of repositories belonging to one organization,
+// as returned by GET /api/user/repos.
+type OrgRepositories struct {
+	Org          string               `json:"org"`
+	Repositories []*github.Repository `json:"repositories"`
+}
+
+// Orgs lists the configured organizations, as returned by GET /api/orgs.
+type Orgs struct {
+	Orgs []string `json:"orgs"`
+	// Active is the organization selected in the session, empty for all.
+	Active string `json:"active"`
+}
+
+// SetActiveOrgRequest is the body of PUT /api/orgs/active. An empty Org
+// selects all organizations.
+type SetActiveOrgRequest struct {
+	Org string `json:"org"`
+}
+
+// SetSecretRequest is the body of PUT /api/repo/{owner}/{repo}/secrets/{name}.
+type SetSecretRequest struct {
+	Value string `json:"value"`
+}
+
+// CSRFToken is returned by GET /api/csrf-token. Session requests that change
+// state send it in the X-CSRF-Token header.
+type CSRFToken struct {
+	Token string `json:"token"`
+}
+
+// APIToken describes an API token, without the token itself.
+type APIToken struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	UserID string `json:"user_id"`
+	Login  string `json:"login"`
+	// Repositories are "owner/repo" or "owner/*" entries.
+	Repositories []string   `json:"repositories"`
+	Actions      []string   `json:"actions"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// CreateTokenRequest is the body of POST /api/tokens.
+type CreateTokenRequest struct {
+	Name         string   `json:"name"`
+	Repositories []string `json:"repositories"`
+	Actions      []string `json:"actions"`
+	// ExpiresInDays defaults to 30 days when zero.
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// CreatedToken is returned by POST /api/tokens. Secret is the token itself
+// and is only ever returned here.
+type CreatedToken struct {
+	Token  APIToken `json:"token"`
+	Secret string   `json:"secret"`
+}
+
+// DeviceCodeRequest is the optional body of POST /api/device/code. It
+// narrows the API token the device login yields. By default, the token can
+// read and write, but not delete, secrets in the broker's organizations for
+// 24 hours.
+type DeviceCodeRequest struct {
+	// Repositories are "owner/repo" or "owner/*" names in the broker's
+	// organizations.
+	Repositories []string `json:"repositories,omitempty"`
+	// Actions default to "secrets:read" and "secrets:write".
+	Actions []string `json:"actions,omitempty"`
+	// ExpiresInHours defaults to, and can't exceed, 24.
+	ExpiresInHours int `json:"expires_in_hours,omitempty"`
+}
+
+// DeviceCode starts a device login (RFC 8628), as returned by
+// POST /api/device/code. The user confirms UserCode at VerificationURI while
+// the client polls with DeviceCode.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	// ExpiresIn and Interval are in seconds
+	ExpiresIn int `json:"expires_in"`
+	Interval  int `json:"interval"`
+}
+
+// DeviceTokenRequest is the body of POST /api/device/token.
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// DeviceToken is the API token a completed device login yields.
+type DeviceToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UserRole is the broker role of the logged-in user: "user", "auditor" or
+// "admin".
+type UserRole struct {
+	Role string `json:"role"`
+}
+
+// RoleAssignments are the configured role assignments, as returned by
+// GET /api/admin/roles. Teams are given as "org/team-slug".
+type RoleAssignments struct {
+	AdminUsers         []string `json:"admin_users"`
+	AdminTeams         []string `json:"admin_teams"`
+	AuditorUsers       []string `json:"auditor_users"`
+	AuditorTeams       []string `json:"auditor_teams"`
+	OrgOwnersAreAdmins bool     `json:"org_owners_are_admins"`
+}
+
+// SecretMetadata describes a secret without its value, as returned by
+// GET /api/admin/repo/{owner}/{repo}/secrets.
+type SecretMetadata struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BreakGlassRequest is the body of POST /api/break-glass.
+type BreakGlassRequest struct {
+	// Repository is "owner/repo".
+	Repository    string `json:"repository"`
+	Justification string `json:"justification"`
+	// Ticket references the incident, e.g. "INC-1234".
+	Ticket string `json:"ticket"`
+}
+
+// BreakGlassGrant is time-boxed write access to one repository, granted in
+// an emergency.
+type BreakGlassGrant struct {
+	ID            string    `json:"id"`
+	Login         string    `json:"login"`
+	Repository    string    `json:"repository"`
+	Justification string    `json:"justification"`
+	Ticket        string    `json:"ticket"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	// Review is set once an admin reviewed the grant.
+	Review *BreakGlassReview `json:"review,omitempty"`
+}
+
+// BreakGlassReview is an admin's sign-off on a break-glass grant.
+type BreakGlassReview struct {
+	Reviewer string    `json:"reviewer"`
+	Note     string    `json:"note"`
+	At       time.Time `json:"at"`
+}
+
+// ReviewBreakGlassRequest is the body of
+// POST /api/admin/break-glass/{id}/review.
+type ReviewBreakGlassRequest struct {
+	Note string `json:"note"`
+}
+
+// IncidentRequest is the body of POST /api/admin/incidents/preview and
+// POST /api/admin/incidents.
+type IncidentRequest struct {
+	SecretName string `json:"secret_name"`
+	// Action is "delete" or "replace".
+	Action string `json:"action"`
+	// Value is the new value of the secret when replacing it.
+	Value string `json:"value,omitempty"`
+	// Org limits the incident to one organization; by default it covers all
+	// of the broker's organizations.
+	Org string `json:"org,omitempty"`
+	// Repositories are the repositories, as "owner/repo", confirmed from the
+	// preview. Only these are changed.
+	Repositories []string `json:"repositories,omitempty"`
+}
+
+// IncidentRepository is a repository that holds the secret of an incident.
+type IncidentRepository struct {
+	Repository string    `json:"repository"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Incident is a preview or a run of deleting or replacing a secret across
+// repositories. Incidents run in the background; poll them with GetIncident
+// until Status is "done". A run's ID is in the audit record of every change
+// it made.
+type Incident struct {
+	ID         string `json:"id"`
+	SecretName string `json:"secret_name"`
+	Action     string `json:"action"`
+	// Preview reports whether the incident only looks up the repositories
+	// that hold the secret.
+	Preview bool `json:"preview"`
+	// Status is "running" or "done".
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Repositories are the repositories found to hold the secret.
+	Repositories []IncidentRepository `json:"repositories"`
+	// Results are the outcome per confirmed repository of a run. A preview
+	// only lists the organizations and repositories it couldn't check.
+	Results []IncidentResult `json:"results"`
+}
+
+// IncidentResult is the outcome of an incident for one repository:
+// "success", "failure" or "skipped" if the repository no longer held the
+// secret. Repository is just the organization if its repositories couldn't
+// be listed.
+type IncidentResult struct {
+	Repository string `json:"repository"`
+	Outcome    string `json:"outcome"`
+	Error      string `json:"error,omitempty"`
+}
+
+// RequiredSecrets is the response of
+// GET /api/repo/{owner}/{repo}/required-secrets.
+type RequiredSecrets struct {
+	// Source is where the repository's requirements come from: "config" for
+	// a template assigned by the broker's config, "file" for the manifest
+	// file at Path, or empty if the repository declares none.
+	Source   string `json:"source,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Template string `json:"template,omitempty"`
+	// Required lists the required secret names, Missing those the
+	// repository doesn't have yet.
+	Required []string `json:"required"`
+	Missing  []string `json:"missing"`
+	// Problem explains why the manifest can't be used, e.g. an unknown
+	// template.
+	Problem string `json:"problem,omitempty"`
+}
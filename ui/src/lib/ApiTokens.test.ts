@@ -0,0 +1,66 @@
+import { render, screen, waitFor, fireEvent, cleanup } from '@testing-library/svelte';
+import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
+import ApiTokens from './ApiTokens.svelte';
+import type { ApiToken } from './types';
+
+const token: ApiToken = {
+    id: 'tok-1',
+    name: 'deploy',
+    login: 'octocat',
+    repositories: ['test-org/repo-1'],
+    actions: ['secrets:write'],
+    created_at: '2026-01-01T00:00:00Z',
+    expires_at: '2099-01-01T00:00:00Z',
+};
+
+describe('ApiTokens', () => {
+    beforeEach(() => {
+        window.fetch = vi.fn();
+    });
+
+    afterEach(() => {
+        cleanup();
+    });
+
+    it('lists tokens from props', () => {
+        render(ApiTokens, { tokens: [token], csrfToken: 'mock-token' });
+
+        expect(screen.getByText('deploy')).toBeInTheDocument();
+        expect(screen.getByText(/test-org\/repo-1/)).toBeInTheDocument();
+    });
+
+    it('shows empty state when there are no tokens', () => {
+        render(ApiTokens, { tokens: [], csrfToken: 'mock-token' });
+
+        expect(screen.getByText('You have no API tokens.')).toBeInTheDocument();
+    });
+
+    it('creates a token and shows it once', async () => {
+        (window.fetch as any).mockResolvedValue({
+            ok: true,
+            json: () => Promise.resolve({ token: { ...token, id: 'tok-2', name: 'ci' }, secret: 'gsb_new-secret' }),
+        });
+
+        render(ApiTokens, { tokens: [], csrfToken: 'mock-token' });
+
+        await fireEvent.input(screen.getByLabelText('Name'), { target: { value: 'ci' } });
+        await fireEvent.input(screen.getByLabelText('Repositories'), { target: { value: 'test-org/repo-1, test-org/*' } });
+        await fireEvent.click(screen.getByText('Create Token'));
+
+        await waitFor(() => {
+            expect(window.fetch).toHaveBeenCalledWith(
+                '/api/tokens',
+                expect.objectContaining({
+                    method: 'POST',
+                    headers: expect.objectContaining({ 'X-CSRF-Token': 'mock-token' }),
+                }),
+            );
+        });
+        const body = JSON.parse((window.fetch as any).mock.calls[0][1].body);
+        expect(body.repositories).toEqual(['test-org/repo-1', 'test-org/*']);
+        expect(body.actions).toEqual(['secrets:write']);
+
+        expect(await screen.findByText('gsb_new-secret')).toBeInTheDocument();
+        expect(screen.getByText('ci')).toBeInTheDocument();
+    });
+});
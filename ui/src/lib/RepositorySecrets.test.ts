@@ -116,4 +116,34 @@ describe('RepositorySecrets', () => {
         // Secret list is hidden on error in current implementation
         // expect(screen.getByText('SECRET_TO_KEEP')).toBeInTheDocument();
     });
+
+    it('renders a checklist of the required secrets', () => {
+        const required = {
+            source: 'config' as const,
+            template: 'deployable',
+            required: ['DEPLOY_KEY', 'SENTRY_DSN'],
+            missing: ['SENTRY_DSN'],
+        };
+        render(RepositorySecrets, { owner, repo, secrets: ['deploy_key'], csrfToken: 'mock-token', required });
+
+        const checklist = screen.getByRole('list', { name: 'Required secrets' });
+        expect(checklist).toHaveTextContent('DEPLOY_KEY');
+        expect(checklist).toHaveTextContent('SENTRY_DSN');
+        expect(screen.getByText('1 missing')).toBeInTheDocument();
+        expect(screen.getByLabelText('missing')).toBeInTheDocument();
+    });
+
+    it('shows why the manifest cannot be used', () => {
+        const required = {
+            source: 'file' as const,
+            path: '.github/secret-broker.yml',
+            required: [],
+            missing: [],
+            problem: 'invalid secret manifest: unknown template "library"',
+        };
+        render(RepositorySecrets, { owner, repo, secrets: [], csrfToken: 'mock-token', required });
+
+        expect(screen.getByText(/unknown template "library"/)).toBeInTheDocument();
+        expect(screen.queryByRole('list', { name: 'Required secrets' })).not.toBeInTheDocument();
+    });
 });
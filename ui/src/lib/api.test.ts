@@ -0,0 +1,35 @@
+import { describe, it, expect } from 'vitest';
+import { errorMessage } from './api';
+
+const problemHeaders = { 'Content-Type': 'application/problem+json' };
+
+describe('errorMessage', () => {
+    it('should return the fallback for plain errors', async () => {
+        const res = new Response('boom', { status: 500 });
+        expect(await errorMessage(res, 'Failed to fetch secrets')).toBe('Failed to fetch secrets');
+    });
+
+    it('should return the problem detail', async () => {
+        const res = new Response(
+            JSON.stringify({ title: 'Not Found', status: 404, code: 'not_found', detail: 'Repository or secret not found' }),
+            { status: 404, headers: problemHeaders },
+        );
+        expect(await errorMessage(res, 'Failed')).toBe('Repository or secret not found');
+    });
+
+    it('should name the retry time for rate limits', async () => {
+        const retryAt = new Date(2024, 0, 1, 14, 5);
+        const res = new Response(
+            JSON.stringify({ title: 'Too Many Requests', status: 429, code: 'rate_limited', retry_at: retryAt.toISOString() }),
+            { status: 429, headers: { ...problemHeaders, 'Retry-After': '60' } },
+        );
+        const message = await errorMessage(res, 'Failed');
+        expect(message).toContain('GitHub rate limit hit, retry at');
+        expect(message).toContain('05');
+    });
+
+    it('should fall back to Retry-After without a problem body', async () => {
+        const res = new Response('Too Many Requests', { status: 429, headers: { 'Retry-After': '60' } });
+        expect(await errorMessage(res, 'Failed')).toContain('GitHub rate limit hit, retry at');
+    });
+});
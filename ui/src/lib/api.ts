@@ -0,0 +1,47 @@
+// Helpers for talking to the broker API.
+
+// Problem details (RFC 9457), the error body of every /api endpoint.
+export interface Problem {
+    title: string;
+    status: number;
+    detail?: string;
+    code: string;
+    request_id?: string;
+    retry_at?: string;
+}
+
+function formatTime(date: Date): string {
+    return date.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+}
+
+async function readProblem(res: Response): Promise<Problem | null> {
+    if (!res.headers.get("Content-Type")?.includes("json")) {
+        return null;
+    }
+    try {
+        return (await res.json()) as Problem;
+    } catch {
+        return null;
+    }
+}
+
+// errorMessage returns a user-facing message for a failed response. Rate
+// limits say when to retry; other problems use their detail, anything else
+// falls back to the given message.
+export async function errorMessage(res: Response, fallback: string): Promise<string> {
+    const problem = await readProblem(res);
+
+    if (res.status === 429) {
+        let retryAt = problem?.retry_at ? new Date(problem.retry_at) : null;
+        const seconds = Number(res.headers.get("Retry-After"));
+        if ((!retryAt || isNaN(retryAt.getTime())) && seconds > 0) {
+            retryAt = new Date(Date.now() + seconds * 1000);
+        }
+        if (retryAt && !isNaN(retryAt.getTime())) {
+            return `GitHub rate limit hit, retry at ${formatTime(retryAt)}`;
+        }
+        return "GitHub rate limit hit, please retry later";
+    }
+
+    return problem?.detail || fallback;
+}
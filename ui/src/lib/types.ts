@@ -1,3 +1,5 @@
+// Mirrors the schemas in internal/openapi/openapi.json, served at /api/openapi.json.
+
 export interface ProvidersResponse {
     Providers: string[];
     ProvidersMap: Record<string, string>;
@@ -13,3 +15,52 @@ export interface User {
     UserID: string;
     Provider: string;
 }
+
+export interface Repository {
+    id: number;
+    name: string;
+    full_name: string;
+    html_url: string;
+    description: string;
+    private: boolean;
+}
+
+export interface OrgRepositories {
+    org: string;
+    repositories: Repository[];
+}
+
+export interface OrgsResponse {
+    orgs: string[];
+    active: string;
+}
+
+export type ApiTokenAction = "secrets:read" | "secrets:write" | "secrets:delete";
+
+export interface ApiToken {
+    id: string;
+    name: string;
+    login: string;
+    repositories: string[];
+    actions: ApiTokenAction[];
+    created_at: string;
+    expires_at: string;
+    last_used_at?: string;
+}
+
+// The token itself is only returned once, when it is created.
+export interface CreatedApiToken {
+    token: ApiToken;
+    secret: string;
+}
+
+// The secrets a repository is required to have, from a template assigned in
+// the broker's config or from the repository's manifest file.
+export interface RequiredSecrets {
+    source?: "config" | "file";
+    path?: string;
+    template?: string;
+    required: string[];
+    missing: string[];
+    problem?: string;
+}
@@ -1,17 +1,36 @@
 import type { PageLoad } from "./$types";
+import type { OrgRepositories, OrgsResponse } from "$lib/types";
 import { error, redirect } from "@sveltejs/kit";
+import { errorMessage } from "$lib/api";
 
 export const load: PageLoad = async ({ fetch }) => {
-    const res = await fetch("/api/user/repos");
+    const [reposRes, orgsRes, csrfRes] = await Promise.all([
+        fetch("/api/user/repos"),
+        fetch("/api/orgs"),
+        fetch("/api/csrf-token"),
+    ]);
 
-    if (res.status === 401 || res.status === 403) {
+    if (reposRes.status === 401 || reposRes.status === 403) {
         throw redirect(302, "/login?unauthorized=1");
     }
 
-    if (!res.ok) {
-        throw error(res.status, "Failed to fetch repositories");
+    if (!reposRes.ok) {
+        throw error(
+            reposRes.status,
+            await errorMessage(reposRes, "Failed to fetch repositories"),
+        );
     }
 
-    const repositories = await res.json();
-    return { repositories };
+    const groups: OrgRepositories[] = await reposRes.json();
+    let orgs: OrgsResponse = { orgs: [], active: "" };
+    if (orgsRes.ok) {
+        orgs = await orgsRes.json();
+    }
+    let csrfToken: string | null = null;
+    if (csrfRes.ok) {
+        const data = await csrfRes.json();
+        csrfToken = data.token;
+    }
+
+    return { groups, orgs, csrfToken };
 };
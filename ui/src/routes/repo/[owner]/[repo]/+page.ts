@@ -1,12 +1,15 @@
 import type { PageLoad } from "./$types";
 import { error, redirect } from "@sveltejs/kit";
+import { errorMessage } from "$lib/api";
+import type { RequiredSecrets } from "$lib/types";
 
 export const load: PageLoad = async ({ fetch, params }) => {
     const { owner, repo } = params;
 
-    const [secretsRes, csrfRes] = await Promise.all([
+    const [secretsRes, csrfRes, requiredRes] = await Promise.all([
         fetch(`/api/repo/${owner}/${repo}/secrets`),
         fetch("/api/csrf-token"),
+        fetch(`/api/repo/${owner}/${repo}/required-secrets`),
     ]);
 
     if (secretsRes.status === 401 || csrfRes.status === 401) {
@@ -14,7 +17,10 @@ export const load: PageLoad = async ({ fetch, params }) => {
     }
 
     if (!secretsRes.ok) {
-        throw error(secretsRes.status, "Failed to fetch secrets");
+        throw error(
+            secretsRes.status,
+            await errorMessage(secretsRes, "Failed to fetch secrets"),
+        );
     }
 
     const secrets = (await secretsRes.json()) || [];
@@ -24,5 +30,11 @@ export const load: PageLoad = async ({ fetch, params }) => {
         csrfToken = data.token;
     }
 
-    return { owner, repo, secrets, csrfToken };
+    // The checklist is optional; the secrets can be managed without it
+    let required: RequiredSecrets | null = null;
+    if (requiredRes.ok) {
+        required = await requiredRes.json();
+    }
+
+    return { owner, repo, secrets, csrfToken, required };
 };
@@ -0,0 +1,31 @@
+import type { PageLoad } from "./$types";
+import type { ApiToken } from "$lib/types";
+import { error, redirect } from "@sveltejs/kit";
+import { errorMessage } from "$lib/api";
+
+export const load: PageLoad = async ({ fetch }) => {
+    const [tokensRes, csrfRes] = await Promise.all([
+        fetch("/api/tokens"),
+        fetch("/api/csrf-token"),
+    ]);
+
+    if (tokensRes.status === 401) {
+        throw redirect(302, "/login?unauthorized=1");
+    }
+
+    if (!tokensRes.ok) {
+        throw error(
+            tokensRes.status,
+            await errorMessage(tokensRes, "Failed to fetch API tokens"),
+        );
+    }
+
+    const tokens: ApiToken[] = (await tokensRes.json()) || [];
+    let csrfToken: string | null = null;
+    if (csrfRes.ok) {
+        const data = await csrfRes.json();
+        csrfToken = data.token;
+    }
+
+    return { tokens, csrfToken };
+};